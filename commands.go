@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hexis-revival/hexagon/common"
+	"github.com/hexis-revival/hexagon/hscore"
+)
+
+type Command struct {
+	Name        string
+	Usage       string
+	Description string
+	Run         func(ctx *CommandContext) error
+}
+
+type CommandContext struct {
+	Command *Command
+	Args    []string
+	State   *common.State
+	Logger  *common.Logger
+	Server  *hscore.ScoreServer
+}
+
+var commands = []*Command{
+	{
+		Name:        "replay export",
+		Usage:       "<score id> <output file>",
+		Description: "Export a stored replay as json, or its frames as csv if the output ends in .csv",
+		Run:         ReplayExportCommand,
+	},
+	{
+		Name:        "replay import",
+		Usage:       "<input file> <output file> [score id]",
+		Description: "Convert an exported json replay back into the client replay format, or replace the frames of a stored replay if the input ends in .csv",
+		Run:         ReplayImportCommand,
+	},
+	{
+		Name:        "flags list",
+		Usage:       "[score id]",
+		Description: "List unreviewed score flags, or all flags of a score",
+		Run:         FlagsListCommand,
+	},
+	{
+		Name:        "flags review",
+		Usage:       "<flag id> <reviewer user id>",
+		Description: "Mark a score flag as reviewed by a staff member",
+		Run:         FlagsReviewCommand,
+	},
+	{
+		Name:        "beatmaps difficulty",
+		Usage:       "[beatmap id]",
+		Description: "Recalculate the star rating of one or all submitted beatmaps",
+		Run:         BeatmapDifficultyCommand,
+	},
+	{
+		Name:        "beatmaps rank-qualified",
+		Description: "Rank all qualified beatmapsets whose qualification cooldown has passed",
+		Run:         BeatmapRankQualifiedCommand,
+	},
+	{
+		Name:        "beatmaps leaderboard",
+		Usage:       "<beatmapset id>",
+		Description: "Re-evaluate all scores on a beatmapset after its status was changed manually",
+		Run:         BeatmapLeaderboardCommand,
+	},
+	{
+		Name:        "pp recalculate",
+		Usage:       "[user id]",
+		Description: "Recalculate the pp of all best scores & users, or of a single user",
+		Run:         PerformanceRecalculateCommand,
+	},
+	{
+		Name:        "scores deduplicate",
+		Description: "Remove resubmitted copies of the same score with their replays and recalculate the stats of their users",
+		Run:         ScoresDeduplicateCommand,
+	},
+	{
+		Name:        "stats verify",
+		Usage:       "[user id]",
+		Description: "Compare the stats of one or all users against a full recalculation from their scores",
+		Run:         StatsVerifyCommand,
+	},
+	{
+		Name:        "stats rebuild",
+		Usage:       "[--dry-run]",
+		Description: "Recalculate the stats of all users from their scores & repopulate the rankings",
+		Run:         StatsRebuildCommand,
+	},
+	{
+		Name:        "users restrict",
+		Usage:       "<user id>",
+		Description: "Restrict a user and remove them from the rankings",
+		Run:         UserRestrictCommand,
+	},
+	{
+		Name:        "users unrestrict",
+		Usage:       "<user id>",
+		Description: "Lift the restriction of a user and add them back into the rankings",
+		Run:         UserUnrestrictCommand,
+	},
+	{
+		Name:        "users permissions",
+		Usage:       "<user id> [nominate|rank|none...]",
+		Description: "Show the permissions of a user, or replace them with the given ones",
+		Run:         UserPermissionsCommand,
+	},
+	{
+		Name:        "jobs dead",
+		Description: "List background jobs that failed too often and were moved to the dead-letter list",
+		Run:         JobsDeadCommand,
+	},
+	{
+		Name:        "jobs retry",
+		Description: "Move all dead background jobs back onto the queue",
+		Run:         JobsRetryCommand,
+	},
+	{
+		Name:        "jobs requeue",
+		Description: "Move jobs that were left unfinished after a crash back onto the queue without waiting for their lease, while no workers are running",
+		Run:         JobsRequeueCommand,
+	},
+	{
+		Name:        "jobs enqueue",
+		Usage:       "<preview|thumbnail|difficulty> <id>",
+		Description: "Queue a background job for a beatmapset (preview, thumbnail) or beatmap (difficulty)",
+		Run:         JobsEnqueueCommand,
+	},
+	{
+		Name:        "storage verify",
+		Usage:       "[--quarantine]",
+		Description: "Check all stored objects against their checksums, and optionally quarantine corrupt ones",
+		Run:         StorageVerifyCommand,
+	},
+	{
+		Name:        "storage gc",
+		Usage:       "[--delete] [--grace <duration>]",
+		Description: "Report or remove stored objects, whose score, user, beatmap or beatmapset no longer exists",
+		Run:         StorageGarbageCollectCommand,
+	},
+}
+
+// ResolveCommand finds the command matching the leading
+// arguments and returns it, along with the remaining arguments.
+func ResolveCommand(args []string) (*Command, []string) {
+	for _, command := range commands {
+		name := strings.Fields(command.Name)
+
+		if len(args) < len(name) {
+			continue
+		}
+
+		if strings.Join(args[:len(name)], " ") != command.Name {
+			continue
+		}
+
+		return command, args[len(name):]
+	}
+
+	return nil, nil
+}
+
+func runCommand(args []string, state *common.State, logger *common.Logger) error {
+	command, commandArgs := ResolveCommand(args)
+
+	if command == nil {
+		return fmt.Errorf("unknown command '%s'\n%s", strings.Join(args, " "), commandsUsage())
+	}
+
+	ctx := &CommandContext{
+		Command: command,
+		Args:    commandArgs,
+		State:   state,
+		Logger:  logger,
+		Server:  hscore.NewServer("", 0, logger, state),
+	}
+
+	return command.Run(ctx)
+}
+
+func commandsUsage() string {
+	lines := []string{"Available commands:"}
+
+	for _, command := range commands {
+		lines = append(lines, fmt.Sprintf(
+			"  %s %s\n      %s",
+			command.Name, command.Usage, command.Description,
+		))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (ctx *CommandContext) RequireArgs(amount int) error {
+	if len(ctx.Args) < amount {
+		return fmt.Errorf("usage: %s %s", ctx.Command.Name, ctx.Command.Usage)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hexis-revival/hexagon/common"
+	"github.com/hexis-revival/hexagon/hscore"
+)
+
+func BeatmapDifficultyCommand(ctx *CommandContext) error {
+	if len(ctx.Args) > 0 {
+		beatmapId, err := strconv.Atoi(ctx.Args[0])
+		if err != nil {
+			return fmt.Errorf("invalid beatmap id: %s", ctx.Args[0])
+		}
+
+		beatmap, err := common.FetchBeatmapById(beatmapId, ctx.State)
+		if err != nil {
+			return err
+		}
+
+		if err = hscore.UpdateBeatmapDifficulty(beatmap, ctx.Server); err != nil {
+			return err
+		}
+
+		ctx.Logger.Infof("Beatmap %d: %.2f stars", beatmap.Id, beatmap.SR)
+		return nil
+	}
+
+	processed := 0
+	failed := 0
+
+	err := common.FetchBeatmapsInBatches(100, ctx.State, func(beatmaps []*common.Beatmap) error {
+		for _, beatmap := range beatmaps {
+			previousRating := beatmap.SR
+			processed++
+
+			if err := hscore.UpdateBeatmapDifficulty(beatmap, ctx.Server); err != nil {
+				ctx.Logger.Warningf("Failed to update beatmap %d: %s", beatmap.Id, err)
+				failed++
+				continue
+			}
+
+			ctx.Logger.Debugf(
+				"Beatmap %d: %.2f -> %.2f stars",
+				beatmap.Id, previousRating, beatmap.SR,
+			)
+		}
+
+		ctx.Logger.Infof("Processed %d beatmaps...", processed)
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	ctx.Logger.Infof("Updated %d beatmaps (%d failed)", processed-failed, failed)
+	return nil
+}
+
+func BeatmapRankQualifiedCommand(ctx *CommandContext) error {
+	ranked, err := hscore.RankQualifiedBeatmapsets(ctx.Server)
+	if err != nil {
+		return err
+	}
+
+	ctx.Logger.Infof("Ranked %d qualified beatmapsets", ranked)
+	return nil
+}
+
+func BeatmapLeaderboardCommand(ctx *CommandContext) error {
+	if err := ctx.RequireArgs(1); err != nil {
+		return err
+	}
+
+	setId, err := strconv.Atoi(ctx.Args[0])
+	if err != nil {
+		return fmt.Errorf("invalid beatmapset id: %s", ctx.Args[0])
+	}
+
+	userIds, err := hscore.RebuildBeatmapsetLeaderboards(setId, ctx.Server)
+	if err != nil {
+		return err
+	}
+
+	ctx.Logger.Infof("Rebuilt leaderboards of beatmapset %d, refreshing %d users...", setId, len(userIds))
+	return hscore.RefreshUserStatistics(userIds, ctx.Server)
+}
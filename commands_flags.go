@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hexis-revival/hexagon/common"
+)
+
+func FlagsListCommand(ctx *CommandContext) error {
+	var flags []*common.ScoreFlag
+	var err error
+
+	if len(ctx.Args) > 0 {
+		scoreId, parseErr := strconv.Atoi(ctx.Args[0])
+		if parseErr != nil {
+			return fmt.Errorf("invalid score id: %s", ctx.Args[0])
+		}
+
+		flags, err = common.FetchScoreFlags(scoreId, ctx.State, "User")
+	} else {
+		flags, err = common.FetchUnreviewedScoreFlags(ctx.State, "User")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, flag := range flags {
+		ctx.Logger.Infof(
+			"Flag %d on score %d by '%s' (%s, reviewed: %t): %s",
+			flag.Id, flag.ScoreId, flag.User.Name, flag.Type, flag.Reviewed, flag.Details,
+		)
+	}
+
+	ctx.Logger.Infof("%d score flags", len(flags))
+	return nil
+}
+
+func FlagsReviewCommand(ctx *CommandContext) error {
+	if err := ctx.RequireArgs(2); err != nil {
+		return err
+	}
+
+	flagId, err := strconv.Atoi(ctx.Args[0])
+	if err != nil {
+		return fmt.Errorf("invalid flag id: %s", ctx.Args[0])
+	}
+
+	reviewerId, err := strconv.Atoi(ctx.Args[1])
+	if err != nil {
+		return fmt.Errorf("invalid user id: %s", ctx.Args[1])
+	}
+
+	flag, err := common.FetchScoreFlagById(flagId, ctx.State)
+	if err != nil {
+		return err
+	}
+
+	reviewer, err := common.FetchUserById(reviewerId, ctx.State)
+	if err != nil {
+		return err
+	}
+
+	flag.Reviewed = true
+	flag.ReviewedBy = &reviewer.Id
+
+	if err = common.UpdateScoreFlag(flag, ctx.State); err != nil {
+		return err
+	}
+
+	ctx.Logger.Infof("Marked flag %d as reviewed by '%s'", flag.Id, reviewer.Name)
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hexis-revival/hexagon/common"
+)
+
+func JobsDeadCommand(ctx *CommandContext) error {
+	jobs, err := common.FetchDeadJobs(ctx.State)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		ctx.Logger.Infof(
+			"%s: %d attempts, last error: %s",
+			job, job.Attempts, job.Error,
+		)
+	}
+
+	ctx.Logger.Infof("%d dead jobs", len(jobs))
+	return nil
+}
+
+func JobsRetryCommand(ctx *CommandContext) error {
+	retried, err := common.RetryDeadJobs(ctx.State)
+	if err != nil {
+		return err
+	}
+
+	ctx.Logger.Infof("Requeued %d dead jobs", retried)
+	return nil
+}
+
+func JobsRequeueCommand(ctx *CommandContext) error {
+	requeued, err := common.RequeueProcessingJobs(ctx.State)
+	if err != nil {
+		return err
+	}
+
+	ctx.Logger.Infof("Requeued %d unfinished jobs", requeued)
+	return nil
+}
+
+func JobsEnqueueCommand(ctx *CommandContext) error {
+	if err := ctx.RequireArgs(2); err != nil {
+		return err
+	}
+
+	jobType := common.JobType(ctx.Args[0])
+
+	switch jobType {
+	case common.JobTypeAudioPreview, common.JobTypeThumbnail, common.JobTypeDifficulty:
+	default:
+		return fmt.Errorf("invalid job type: %s", ctx.Args[0])
+	}
+
+	target, err := strconv.Atoi(ctx.Args[1])
+	if err != nil {
+		return fmt.Errorf("invalid id: %s", ctx.Args[1])
+	}
+
+	job := common.NewJob(jobType, target)
+	if err = common.EnqueueJob(job, ctx.State); err != nil {
+		return err
+	}
+
+	ctx.Logger.Infof("Enqueued job %s", job)
+	return nil
+}
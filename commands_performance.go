@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hexis-revival/hexagon/common"
+	"github.com/hexis-revival/hexagon/hscore"
+)
+
+func PerformanceRecalculateCommand(ctx *CommandContext) error {
+	if len(ctx.Args) > 0 {
+		userId, err := strconv.Atoi(ctx.Args[0])
+		if err != nil {
+			return fmt.Errorf("invalid user id: %s", ctx.Args[0])
+		}
+
+		return recalculateUserPerformance(userId, ctx)
+	}
+
+	processed := 0
+	updated := 0
+	failed := 0
+
+	err := common.FetchBeatmapsInBatches(100, ctx.State, func(beatmaps []*common.Beatmap) error {
+		for _, beatmap := range beatmaps {
+			processed++
+
+			scores, err := common.FetchRangeScores(beatmap.Id, ctx.State)
+			if err != nil {
+				return err
+			}
+
+			count, err := hscore.UpdateScoresPerformance(beatmap, scores, ctx.Server)
+			updated += count
+
+			if err != nil {
+				ctx.Logger.Warningf("Failed to update scores on beatmap %d: %s", beatmap.Id, err)
+				failed++
+			}
+		}
+
+		ctx.Logger.Infof("Processed %d beatmaps...", processed)
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	ctx.Logger.Infof("Updated pp of %d scores on %d beatmaps (%d failed)", updated, processed, failed)
+	users := 0
+
+	err = common.FetchUsersInBatches(100, ctx.State, func(batch []*common.User) error {
+		for _, user := range batch {
+			if err := hscore.UpdateUserPerformance(user, ctx.Server); err != nil {
+				return fmt.Errorf("failed to update user %d: %w", user.Id, err)
+			}
+			users++
+		}
+
+		ctx.Logger.Infof("Processed %d users...", users)
+		return nil
+	}, "Stats")
+
+	if err != nil {
+		return err
+	}
+
+	ctx.Logger.Infof("Updated pp of %d users", users)
+	return nil
+}
+
+func recalculateUserPerformance(userId int, ctx *CommandContext) error {
+	user, err := common.FetchUserById(userId, ctx.State, "Stats")
+	if err != nil {
+		return err
+	}
+
+	statuses := []common.BeatmapStatus{
+		common.BeatmapStatusRanked,
+		common.BeatmapStatusApproved,
+	}
+
+	for _, status := range statuses {
+		scores, err := common.FetchBestScores(user.Id, int(status), ctx.State)
+		if err != nil {
+			return err
+		}
+
+		for _, score := range scores {
+			beatmap, err := common.FetchBeatmapById(score.BeatmapId, ctx.State)
+			if err != nil {
+				return err
+			}
+
+			_, err = hscore.UpdateScoresPerformance(beatmap, []*common.Score{score}, ctx.Server)
+			if err != nil {
+				ctx.Logger.Warningf("Failed to update score %d: %s", score.Id, err)
+			}
+		}
+	}
+
+	previousPerformance := user.Stats.PP
+
+	if err = hscore.UpdateUserPerformance(user, ctx.Server); err != nil {
+		return err
+	}
+
+	ctx.Logger.Infof("User %d: %.2fpp -> %.2fpp", user.Id, previousPerformance, user.Stats.PP)
+	return nil
+}
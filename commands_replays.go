@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hexis-revival/hexagon/common"
+)
+
+func ReplayExportCommand(ctx *CommandContext) error {
+	if err := ctx.RequireArgs(2); err != nil {
+		return err
+	}
+
+	scoreId, err := strconv.Atoi(ctx.Args[0])
+	if err != nil {
+		return fmt.Errorf("invalid score id: %s", ctx.Args[0])
+	}
+
+	score, err := common.FetchScoreById(scoreId, ctx.State, "Beatmap", "User")
+	if err != nil {
+		return err
+	}
+
+	replay, err := common.LoadScoreReplay(score, ctx.State.Storage)
+	if err != nil {
+		return err
+	}
+
+	output := ctx.Args[1]
+	var data []byte
+
+	if strings.HasSuffix(output, ".csv") {
+		data, err = replay.ExportFramesCSV()
+	} else {
+		data, err = replay.ExportJSON()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	ctx.Logger.Infof("Exported replay of score %d to '%s'", score.Id, output)
+	return os.WriteFile(output, data, 0644)
+}
+
+func ReplayImportCommand(ctx *CommandContext) error {
+	if err := ctx.RequireArgs(2); err != nil {
+		return err
+	}
+
+	input := ctx.Args[0]
+	data, err := os.ReadFile(input)
+	if err != nil {
+		return err
+	}
+
+	var replay *common.ReplayData
+
+	if strings.HasSuffix(input, ".csv") {
+		replay, err = importReplayFramesCSV(data, ctx)
+	} else {
+		replay, err = common.ImportReplayJSON(data)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	stream := common.NewIOStream([]byte{}, binary.BigEndian)
+	replay.Serialize(stream)
+
+	ctx.Logger.Infof("Imported replay with %d frames to '%s'", len(replay.Frames), ctx.Args[1])
+	return os.WriteFile(ctx.Args[1], stream.Get(), 0644)
+}
+
+// importReplayFramesCSV replaces the frames of a stored replay with the
+// frames of a csv file, since the csv export doesn't contain the rest
+func importReplayFramesCSV(data []byte, ctx *CommandContext) (*common.ReplayData, error) {
+	if len(ctx.Args) < 3 {
+		return nil, fmt.Errorf("importing csv frames requires the score id of the replay they belong to")
+	}
+
+	scoreId, err := strconv.Atoi(ctx.Args[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid score id: %s", ctx.Args[2])
+	}
+
+	score, err := common.FetchScoreById(scoreId, ctx.State, "Beatmap", "User")
+	if err != nil {
+		return nil, err
+	}
+
+	replay, err := common.LoadScoreReplay(score, ctx.State.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	replay.Frames, err = common.ImportReplayFramesCSV(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return replay, nil
+}
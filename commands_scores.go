@@ -0,0 +1,20 @@
+package main
+
+import (
+	"github.com/hexis-revival/hexagon/hscore"
+)
+
+func ScoresDeduplicateCommand(ctx *CommandContext) error {
+	duplicates, err := hscore.RemoveDuplicateScores(ctx.Server)
+
+	for _, score := range duplicates {
+		ctx.Logger.Infof("Removed score %d of user %d (%s)", score.Id, score.UserId, score.Checksum)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	ctx.Logger.Infof("Removed %d duplicate scores, the unique checksum index is added on the next migration", len(duplicates))
+	return nil
+}
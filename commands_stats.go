@@ -0,0 +1,249 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hexis-revival/hexagon/common"
+	"github.com/hexis-revival/hexagon/hscore"
+)
+
+func StatsVerifyCommand(ctx *CommandContext) error {
+	if len(ctx.Args) > 0 {
+		userId, err := strconv.Atoi(ctx.Args[0])
+		if err != nil {
+			return fmt.Errorf("invalid user id: %s", ctx.Args[0])
+		}
+
+		user, err := common.FetchUserById(userId, ctx.State, "Stats")
+		if err != nil {
+			return err
+		}
+
+		if verifyUserStatistics(user, ctx) {
+			ctx.Logger.Infof("Stats of user %d are consistent", user.Id)
+		}
+		return nil
+	}
+
+	processed := 0
+	mismatched := 0
+
+	err := common.FetchUsersInBatches(100, ctx.State, func(users []*common.User) error {
+		for _, user := range users {
+			processed++
+
+			if !verifyUserStatistics(user, ctx) {
+				mismatched++
+			}
+		}
+
+		ctx.Logger.Infof("Verified %d users...", processed)
+		return nil
+	}, "Stats")
+
+	if err != nil {
+		return err
+	}
+
+	ctx.Logger.Infof("Verified %d users (%d mismatched)", processed, mismatched)
+	return nil
+}
+
+// verifyUserStatistics compares the incrementally updated stats of a user
+// with a full recalculation, and logs any differences between them.
+func verifyUserStatistics(user *common.User, ctx *CommandContext) bool {
+	expected, err := hscore.CalculateUserStatistics(user, ctx.Server)
+	if err != nil {
+		ctx.Logger.Warningf("Failed to calculate stats of user %d: %s", user.Id, err)
+		return false
+	}
+
+	differences := common.CompareStats(expected, &user.Stats)
+
+	if len(differences) > 0 {
+		ctx.Logger.Warningf(
+			"Stats of user %d differ: %s",
+			user.Id, strings.Join(differences, ", "),
+		)
+		return false
+	}
+
+	return true
+}
+
+func StatsRebuildCommand(ctx *CommandContext) error {
+	flags := flag.NewFlagSet(ctx.Command.Name, flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "Only show differences, without writing anything")
+
+	if err := flags.Parse(ctx.Args); err != nil {
+		return err
+	}
+
+	if !*dryRun {
+		// Rankings are rebuilt into separate sets, which replace the live
+		// ones at the end, so that players stay ranked in the meantime
+		if err := common.StartRankingsRebuild(ctx.State); err != nil {
+			return err
+		}
+	}
+
+	processed := 0
+	changed := 0
+
+	err := common.FetchUsersInBatches(100, ctx.State, func(users []*common.User) error {
+		for _, user := range users {
+			processed++
+
+			differences, err := rebuildUserStatistics(user, *dryRun, ctx)
+			if err != nil {
+				return fmt.Errorf("failed to rebuild stats of user %d: %w", user.Id, err)
+			}
+
+			if len(differences) > 0 {
+				ctx.Logger.Infof("User %d: %s", user.Id, strings.Join(differences, ", "))
+				changed++
+			}
+		}
+
+		ctx.Logger.Infof("Processed %d users...", processed)
+
+		if *dryRun {
+			return nil
+		}
+
+		return common.RefreshRankingsRebuild(ctx.State)
+	}, "Stats")
+
+	if err != nil {
+		if !*dryRun {
+			common.AbortRankingsRebuild(ctx.State)
+		}
+		return err
+	}
+
+	if *dryRun {
+		ctx.Logger.Infof("Found differences for %d of %d users", changed, processed)
+		return nil
+	}
+
+	// Removes entries of deleted users & stale countries
+	if err = common.FinishRankingsRebuild(ctx.State); err != nil {
+		return err
+	}
+
+	// Ranks can only be resolved once all rankings entries exist
+	err = common.FetchUsersInBatches(100, ctx.State, func(users []*common.User) error {
+		for _, user := range users {
+			if user.Stats.Clears() <= 0 || user.Restricted {
+				continue
+			}
+
+			rank, err := common.GetScoreRank(user.Id, ctx.State)
+			if err != nil {
+				return err
+			}
+
+			if rank == user.Stats.Rank {
+				continue
+			}
+
+			if err = common.UpdateStatsRank(user.Id, rank, ctx.State); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, "Stats")
+
+	if err != nil {
+		return err
+	}
+
+	ctx.Logger.Infof("Rebuilt stats of %d users (%d changed)", processed, changed)
+	return nil
+}
+
+// rebuildUserStatistics recalculates the stats of a user and writes them,
+// along with their rebuilt rankings entries. It returns the differences to
+// the previous stats & rankings entries, which is all it does in a dry run.
+func rebuildUserStatistics(user *common.User, dryRun bool, ctx *CommandContext) ([]string, error) {
+	if dryRun {
+		return compareUserStatistics(user, ctx)
+	}
+
+	differences := []string{}
+
+	err := ctx.Server.Transaction(func(tx *hscore.ScoreServer) error {
+		if err := user.EnsureStats(tx.State); err != nil {
+			return err
+		}
+
+		// Submissions of the user wait until their stats are written
+		stats, err := common.FetchStatsForUpdate(user.Id, tx.State)
+		if err != nil {
+			return err
+		}
+		user.Stats = *stats
+
+		expected, err := hscore.CalculateUserStatistics(user, tx)
+		if err != nil {
+			return err
+		}
+
+		differences = common.CompareStats(expected, &user.Stats)
+		user.Stats = *expected
+
+		if err = common.UpdateStats(&user.Stats, tx.State); err != nil {
+			return err
+		}
+
+		if expected.Clears() <= 0 || user.Restricted {
+			return nil
+		}
+
+		return common.UpdateRebuiltRankingsEntry(&user.Stats, user.Country, tx.State)
+	})
+
+	return differences, err
+}
+
+// compareUserStatistics returns the differences between the stored stats
+// & rankings entries of a user and a full recalculation
+func compareUserStatistics(user *common.User, ctx *CommandContext) ([]string, error) {
+	expected, err := hscore.CalculateUserStatistics(user, ctx.Server)
+	if err != nil {
+		return nil, err
+	}
+
+	differences := common.CompareStats(expected, &user.Stats)
+
+	entries, err := common.FetchRankingsEntries(user.Id, ctx.State)
+	if err != nil {
+		return nil, err
+	}
+
+	if expected.Clears() <= 0 || user.Restricted {
+		for key := range entries {
+			differences = append(differences, fmt.Sprintf("%s: unexpected entry", key))
+		}
+		return differences, nil
+	}
+
+	for key, score := range common.RankingsEntries(expected) {
+		current, ok := entries[key]
+
+		if !ok {
+			differences = append(differences, fmt.Sprintf("%s: missing entry", key))
+			continue
+		}
+
+		if current != score {
+			differences = append(differences, fmt.Sprintf("%s: %v != %v", key, current, score))
+		}
+	}
+
+	return differences, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"time"
+
+	"github.com/hexis-revival/hexagon/common"
+)
+
+func StorageVerifyCommand(ctx *CommandContext) error {
+	flags := flag.NewFlagSet(ctx.Command.Name, flag.ContinueOnError)
+	quarantine := flags.Bool("quarantine", false, "Move corrupt objects into the quarantine")
+
+	if err := flags.Parse(ctx.Args); err != nil {
+		return err
+	}
+
+	verified := 0
+	missing := 0
+	pending := 0
+	corrupt := 0
+
+	for _, bucket := range common.StorageBuckets {
+		keys, err := ctx.State.Storage.List(bucket)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			err = common.VerifyObject(ctx.State.Storage, key, bucket)
+
+			if err == nil {
+				verified++
+				continue
+			}
+
+			if errors.Is(err, common.ErrChecksumMissing) {
+				ctx.Logger.Debugf("%s/%s has no checksum", bucket, key)
+				missing++
+				continue
+			}
+
+			if errors.Is(err, common.ErrChecksumPending) {
+				ctx.Logger.Warningf("%s/%s was saved without its checksum", bucket, key)
+				pending++
+				continue
+			}
+
+			ctx.Logger.Warningf("%s/%s is corrupt: %s", bucket, key, err)
+			corrupt++
+
+			if !*quarantine {
+				continue
+			}
+
+			if err = ctx.State.Storage.Quarantine(key, bucket); err != nil {
+				ctx.Logger.Errorf("Failed to quarantine %s/%s: %s", bucket, key, err)
+			}
+		}
+
+		ctx.Logger.Infof("Verified bucket '%s' (%d objects)", bucket, len(keys))
+	}
+
+	ctx.Logger.Infof(
+		"%d objects verified, %d without checksum, %d pending, %d corrupt",
+		verified, missing, pending, corrupt,
+	)
+	return nil
+}
+
+func StorageGarbageCollectCommand(ctx *CommandContext) error {
+	flags := flag.NewFlagSet(ctx.Command.Name, flag.ContinueOnError)
+	remove := flags.Bool("delete", false, "Remove orphaned objects instead of only reporting them")
+	grace := flags.Duration("grace", common.StorageGracePeriod, "Skip objects that were saved more recently")
+
+	if err := flags.Parse(ctx.Args); err != nil {
+		return err
+	}
+
+	references, err := common.FetchStorageReferences(ctx.State)
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-*grace)
+	orphans, err := common.FindOrphanedObjects(ctx.State.Storage, references, cutoff)
+	if err != nil {
+		return err
+	}
+
+	removed := 0
+
+	for _, orphan := range orphans {
+		if !*remove {
+			ctx.Logger.Infof("Orphaned object: %s", orphan)
+			continue
+		}
+
+		if err = ctx.State.Storage.Remove(orphan.Key, orphan.Bucket); err != nil {
+			ctx.Logger.Warningf("Failed to remove %s: %s", orphan, err)
+			continue
+		}
+
+		ctx.Logger.Debugf("Removed %s", orphan)
+		removed++
+	}
+
+	ctx.Logger.Infof("Found %d orphaned objects, removed %d", len(orphans), removed)
+	return nil
+}
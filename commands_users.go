@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hexis-revival/hexagon/common"
+	"github.com/hexis-revival/hexagon/hscore"
+)
+
+func UserRestrictCommand(ctx *CommandContext) error {
+	return setUserRestricted(true, ctx)
+}
+
+func UserUnrestrictCommand(ctx *CommandContext) error {
+	return setUserRestricted(false, ctx)
+}
+
+func setUserRestricted(restricted bool, ctx *CommandContext) error {
+	if err := ctx.RequireArgs(1); err != nil {
+		return err
+	}
+
+	userId, err := strconv.Atoi(ctx.Args[0])
+	if err != nil {
+		return fmt.Errorf("invalid user id: %s", ctx.Args[0])
+	}
+
+	user, err := common.FetchUserById(userId, ctx.State, "Stats")
+	if err != nil {
+		return err
+	}
+
+	if err = user.EnsureStats(ctx.State); err != nil {
+		return err
+	}
+
+	if err = hscore.SetUserRestricted(user, restricted, ctx.Server); err != nil {
+		return err
+	}
+
+	ctx.Logger.Infof("Set restriction of user %d to %t", user.Id, restricted)
+	return nil
+}
+
+var permissionNames = []struct {
+	Name       string
+	Permission common.UserPermissions
+}{
+	{"nominate", common.PermissionNominate},
+	{"rank", common.PermissionRank},
+}
+
+func UserPermissionsCommand(ctx *CommandContext) error {
+	if err := ctx.RequireArgs(1); err != nil {
+		return err
+	}
+
+	userId, err := strconv.Atoi(ctx.Args[0])
+	if err != nil {
+		return fmt.Errorf("invalid user id: %s", ctx.Args[0])
+	}
+
+	user, err := common.FetchUserById(userId, ctx.State)
+	if err != nil {
+		return err
+	}
+
+	if len(ctx.Args) == 1 {
+		ctx.Logger.Infof("User %d has permissions: %s", user.Id, formatPermissions(user.Permissions))
+		return nil
+	}
+
+	permissions := common.UserPermissions(0)
+
+	for _, name := range ctx.Args[1:] {
+		if name == "none" {
+			continue
+		}
+
+		permission, err := parsePermission(name)
+		if err != nil {
+			return err
+		}
+
+		permissions |= permission
+	}
+
+	if err = common.UpdateUserPermissions(user.Id, permissions, ctx.State); err != nil {
+		return err
+	}
+
+	ctx.Logger.Infof(
+		"Changed permissions of user %d from %s to %s",
+		user.Id, formatPermissions(user.Permissions), formatPermissions(permissions),
+	)
+	return nil
+}
+
+func formatPermissions(permissions common.UserPermissions) string {
+	names := []string{}
+
+	for _, entry := range permissionNames {
+		if permissions&entry.Permission != 0 {
+			names = append(names, entry.Name)
+		}
+	}
+
+	if len(names) == 0 {
+		return "none"
+	}
+
+	return strings.Join(names, ", ")
+}
+
+func parsePermission(name string) (common.UserPermissions, error) {
+	for _, entry := range permissionNames {
+		if entry.Name == name {
+			return entry.Permission, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid permission: %s", name)
+}
@@ -0,0 +1,138 @@
+package common
+
+// AchievementContext holds everything achievement rules are evaluated
+// against, after a score was submitted & the user's stats were updated.
+type AchievementContext struct {
+	Score   *Score
+	Beatmap *Beatmap
+	Stats   *Stats
+}
+
+// Ranked returns whether the score was passed on a ranked or approved
+// beatmap, which most score-based achievements require.
+func (ctx *AchievementContext) Ranked() bool {
+	return ctx.Score.Passed && ctx.Beatmap.Status >= BeatmapStatusRanked
+}
+
+func (ctx *AchievementContext) XRank() bool {
+	return ctx.Score.Grade == GradeX || ctx.Score.Grade == GradeXH
+}
+
+type Achievement struct {
+	Name        string
+	Title       string
+	Description string
+	Condition   func(ctx *AchievementContext) bool
+}
+
+var Achievements = []*Achievement{
+	{
+		Name:        "first-clear",
+		Title:       "First Steps",
+		Description: "Pass a ranked beatmap.",
+		Condition: func(ctx *AchievementContext) bool {
+			return ctx.Ranked()
+		},
+	},
+	{
+		Name:        "first-fc",
+		Title:       "Unbroken",
+		Description: "Get a full combo on a ranked beatmap.",
+		Condition: func(ctx *AchievementContext) bool {
+			return ctx.Ranked() && ctx.Score.FullCombo
+		},
+	},
+	{
+		Name:        "hidden-pass",
+		Title:       "Out of Sight",
+		Description: "Pass a ranked beatmap with Hidden.",
+		Condition: func(ctx *AchievementContext) bool {
+			return ctx.Ranked() && ctx.Score.ModHidden
+		},
+	},
+	{
+		Name:        "x-rank",
+		Title:       "Perfectionist",
+		Description: "Get an X rank on a ranked beatmap.",
+		Condition: func(ctx *AchievementContext) bool {
+			return ctx.Ranked() && ctx.XRank()
+		},
+	},
+	{
+		Name:        "x-rank-5-star",
+		Title:       "Flawless",
+		Description: "Get an X rank on a ranked beatmap of 5 stars or more.",
+		Condition: func(ctx *AchievementContext) bool {
+			return ctx.Ranked() && ctx.XRank() && ctx.Beatmap.SR >= 5
+		},
+	},
+	{
+		Name:        "pass-5-star",
+		Title:       "Rising Star",
+		Description: "Pass a ranked beatmap of 5 stars or more.",
+		Condition: func(ctx *AchievementContext) bool {
+			return ctx.Ranked() && ctx.Beatmap.SR >= 5
+		},
+	},
+	{
+		Name:        "combo-1000",
+		Title:       "Marathon",
+		Description: "Reach a combo of 1000 on a ranked beatmap.",
+		Condition: func(ctx *AchievementContext) bool {
+			return ctx.Ranked() && ctx.Score.MaxCombo >= 1000
+		},
+	},
+	{
+		Name:        "plays-100",
+		Title:       "Regular",
+		Description: "Submit 100 plays.",
+		Condition: func(ctx *AchievementContext) bool {
+			return ctx.Stats.Playcount >= 100
+		},
+	},
+	{
+		Name:        "plays-1000",
+		Title:       "Dedicated",
+		Description: "Submit 1000 plays.",
+		Condition: func(ctx *AchievementContext) bool {
+			return ctx.Stats.Playcount >= 1000
+		},
+	},
+	{
+		Name:        "plays-10000",
+		Title:       "Addicted",
+		Description: "Submit 10000 plays.",
+		Condition: func(ctx *AchievementContext) bool {
+			return ctx.Stats.Playcount >= 10000
+		},
+	},
+}
+
+func FindAchievement(name string) *Achievement {
+	for _, achievement := range Achievements {
+		if achievement.Name == name {
+			return achievement
+		}
+	}
+	return nil
+}
+
+// CheckAchievements returns every achievement whose condition is met,
+// which is not part of the already unlocked achievement names.
+func CheckAchievements(ctx *AchievementContext, unlocked map[string]bool) []*Achievement {
+	achievements := []*Achievement{}
+
+	for _, achievement := range Achievements {
+		if unlocked[achievement.Name] {
+			continue
+		}
+
+		if !achievement.Condition(ctx) {
+			continue
+		}
+
+		achievements = append(achievements, achievement)
+	}
+
+	return achievements
+}
@@ -0,0 +1,47 @@
+package common
+
+import "testing"
+
+func hasAchievement(achievements []*Achievement, name string) bool {
+	for _, achievement := range achievements {
+		if achievement.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCheckAchievements(t *testing.T) {
+	ctx := &AchievementContext{
+		Score: &Score{
+			Passed:    true,
+			FullCombo: true,
+			Grade:     GradeXH,
+			ModHidden: true,
+			MaxCombo:  500,
+		},
+		Beatmap: &Beatmap{Status: BeatmapStatusRanked, SR: 5.2},
+		Stats:   &Stats{Playcount: 120},
+	}
+
+	achievements := CheckAchievements(ctx, map[string]bool{"first-clear": true})
+
+	for _, name := range []string{"first-fc", "hidden-pass", "x-rank-5-star", "plays-100"} {
+		if !hasAchievement(achievements, name) {
+			t.Errorf("expected achievement %s to be unlocked", name)
+		}
+	}
+
+	for _, name := range []string{"first-clear", "combo-1000", "plays-1000"} {
+		if hasAchievement(achievements, name) {
+			t.Errorf("expected achievement %s not to be unlocked", name)
+		}
+	}
+
+	ctx.Beatmap.Status = BeatmapStatusPending
+	achievements = CheckAchievements(ctx, map[string]bool{})
+
+	if len(achievements) != 1 || achievements[0].Name != "plays-100" {
+		t.Errorf("expected only playcount achievements on unranked beatmaps, got %d", len(achievements))
+	}
+}
@@ -1,46 +1,207 @@
 package common
 
 import (
-	"io"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"strconv"
+	"time"
 
 	ffmpeg "github.com/u2takey/ffmpeg-go"
 )
 
-// ExtractAudioSnippet extracts a snippet from the audio byte slice, compresses it, and saves it into an MP3 file
-func ExtractAudioSnippet(audioData []byte, offset int, duration int, bitrate int, storage Storage) ([]byte, error) {
-	beatmapAudio, err := storage.CreateTempFile()
-	if err != nil {
-		return nil, err
+const (
+	AudioPreviewDuration = 10 * time.Second
+	AudioPreviewBitrate  = 64
+	AudioPreviewFadeIn   = 500 * time.Millisecond
+	AudioPreviewFadeOut  = 1 * time.Second
+)
+
+type AudioSnippetOptions struct {
+	Offset   time.Duration
+	Duration time.Duration
+	// Bitrate of the resulting mp3 file in kbit/s
+	Bitrate int
+	FadeIn  time.Duration
+	FadeOut time.Duration
+}
+
+// AudioProcessor probes & transcodes audio files
+type AudioProcessor interface {
+	// Duration returns the length of the audio
+	Duration(audio []byte) (time.Duration, error)
+	// Snippet extracts a part of the audio into an mp3 file
+	Snippet(audio []byte, options AudioSnippetOptions) ([]byte, error)
+}
+
+// CreateAudioPreview extracts a preview snippet starting at the offset,
+// which is moved back if the snippet would exceed the end of the audio
+func CreateAudioPreview(audio []byte, offset time.Duration, processor AudioProcessor) ([]byte, error) {
+	if offset < 0 {
+		return nil, errors.New("invalid preview offset")
 	}
 
-	_, err = beatmapAudio.Write(audioData)
+	length, err := processor.Duration(audio)
 	if err != nil {
 		return nil, err
 	}
 
-	inputArgs := ffmpeg.KwArgs{"ss": offset}
-	outputArgs := ffmpeg.KwArgs{"t": duration, "ab": bitrate}
+	options := AudioSnippetOptions{
+		Offset:   offset,
+		Duration: AudioPreviewDuration,
+		Bitrate:  AudioPreviewBitrate,
+		FadeIn:   AudioPreviewFadeIn,
+		FadeOut:  AudioPreviewFadeOut,
+	}
+
+	return processor.Snippet(audio, ClampAudioSnippet(options, length))
+}
+
+// ClampAudioSnippet fits the snippet into audio of the given length
+func ClampAudioSnippet(options AudioSnippetOptions, length time.Duration) AudioSnippetOptions {
+	if options.Duration > length {
+		options.Duration = length
+	}
+
+	if options.Offset+options.Duration > length {
+		options.Offset = length - options.Duration
+	}
+
+	// Fades may not overlap in short snippets
+	if options.FadeIn+options.FadeOut > options.Duration {
+		options.FadeIn = options.Duration / 2
+		options.FadeOut = options.Duration / 2
+	}
+
+	return options
+}
+
+// ErrAudioDisabled is returned when no audio processor was configured
+var ErrAudioDisabled = errors.New("audio processing is disabled")
+
+// DisabledAudioProcessor fails every request, for servers without ffmpeg.
+// Previews are then missing, until they are generated again.
+type DisabledAudioProcessor struct{}
+
+func (processor *DisabledAudioProcessor) Duration(audio []byte) (time.Duration, error) {
+	return 0, ErrAudioDisabled
+}
+
+func (processor *DisabledAudioProcessor) Snippet(audio []byte, options AudioSnippetOptions) ([]byte, error) {
+	return nil, ErrAudioDisabled
+}
+
+// FFmpegAudioProcessor runs ffmpeg on temporary files of the storage
+type FFmpegAudioProcessor struct {
+	storage Storage
+}
+
+func NewFFmpegAudioProcessor(storage Storage) AudioProcessor {
+	// Commands are logged by ffmpeg-go globally, so this is set
+	// once here instead of on every run, which would race
 	ffmpeg.LogCompiledCommand = false
+	return &FFmpegAudioProcessor{storage: storage}
+}
 
-	err = ffmpeg.Input(beatmapAudio.Name(), inputArgs).
-		Output(beatmapAudio.Name()+".mp3", outputArgs).
-		Run()
+func (processor *FFmpegAudioProcessor) Duration(audio []byte) (time.Duration, error) {
+	filename, err := processor.writeTempFile(audio)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(filename)
 
+	output, err := ffmpeg.Probe(filename)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	file, err := os.Open(beatmapAudio.Name() + ".mp3")
+	probe := struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}{}
+
+	if err = json.Unmarshal([]byte(output), &probe); err != nil {
+		return 0, err
+	}
+
+	seconds, err := strconv.ParseFloat(probe.Format.Duration, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid audio duration: %s", probe.Format.Duration)
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func (processor *FFmpegAudioProcessor) Snippet(audio []byte, options AudioSnippetOptions) ([]byte, error) {
+	filename, err := processor.writeTempFile(audio)
 	if err != nil {
 		return nil, err
 	}
 
+	outputFilename := filename + ".mp3"
+
 	defer func() {
-		file.Close()
-		os.Remove(beatmapAudio.Name())
-		os.Remove(beatmapAudio.Name() + ".mp3")
+		os.Remove(filename)
+		os.Remove(outputFilename)
 	}()
 
-	return io.ReadAll(file)
+	inputArgs := ffmpeg.KwArgs{"ss": options.Offset.Seconds()}
+	outputArgs := ffmpeg.KwArgs{
+		"t":   options.Duration.Seconds(),
+		"b:a": fmt.Sprintf("%dk", options.Bitrate),
+	}
+
+	if filter := audioFadeFilter(options); filter != "" {
+		outputArgs["af"] = filter
+	}
+
+	err = ffmpeg.Input(filename, inputArgs).
+		Output(outputFilename, outputArgs).
+		OverWriteOutput().
+		Run()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(outputFilename)
+}
+
+func (processor *FFmpegAudioProcessor) writeTempFile(data []byte) (string, error) {
+	file, err := processor.storage.CreateTempFile()
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err = file.Write(data); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
+
+func audioFadeFilter(options AudioSnippetOptions) string {
+	filter := ""
+
+	if options.FadeIn > 0 {
+		filter = fmt.Sprintf("afade=t=in:st=0:d=%.3f", options.FadeIn.Seconds())
+	}
+
+	if options.FadeOut > 0 {
+		if filter != "" {
+			filter += ","
+		}
+
+		filter += fmt.Sprintf(
+			"afade=t=out:st=%.3f:d=%.3f",
+			(options.Duration - options.FadeOut).Seconds(),
+			options.FadeOut.Seconds(),
+		)
+	}
+
+	return filter
 }
@@ -0,0 +1,67 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCreateAudioPreview(t *testing.T) {
+	processor := &FakeAudioProcessor{
+		AudioDuration: 60 * time.Second,
+		Result:        []byte("preview"),
+	}
+
+	preview, err := CreateAudioPreview([]byte("audio"), 20*time.Second, processor)
+	if err != nil {
+		t.Fatalf("failed to create preview: %s", err)
+	}
+
+	if string(preview) != "preview" {
+		t.Fatalf("unexpected preview data: %s", preview)
+	}
+
+	if len(processor.Snippets) != 1 {
+		t.Fatalf("expected 1 snippet, got %d", len(processor.Snippets))
+	}
+
+	snippet := processor.Snippets[0]
+
+	if snippet.Offset != 20*time.Second || snippet.Duration != AudioPreviewDuration {
+		t.Fatalf("unexpected snippet: %+v", snippet)
+	}
+
+	if snippet.FadeIn != AudioPreviewFadeIn || snippet.FadeOut != AudioPreviewFadeOut {
+		t.Fatalf("expected preview to fade in & out: %+v", snippet)
+	}
+
+	if _, err = CreateAudioPreview([]byte("audio"), -time.Second, processor); err == nil {
+		t.Fatalf("expected negative offset to be rejected")
+	}
+}
+
+func TestClampAudioSnippet(t *testing.T) {
+	options := AudioSnippetOptions{
+		Offset:   55 * time.Second,
+		Duration: 10 * time.Second,
+		FadeIn:   time.Second,
+		FadeOut:  time.Second,
+	}
+
+	// Offset past the end of the audio
+	clamped := ClampAudioSnippet(options, 60*time.Second)
+
+	if clamped.Offset != 50*time.Second || clamped.Duration != 10*time.Second {
+		t.Fatalf("unexpected snippet: %+v", clamped)
+	}
+
+	// Audio shorter than the snippet
+	clamped = ClampAudioSnippet(options, time.Second)
+
+	if clamped.Offset != 0 || clamped.Duration != time.Second {
+		t.Fatalf("unexpected snippet: %+v", clamped)
+	}
+
+	if clamped.FadeIn+clamped.FadeOut > clamped.Duration {
+		t.Fatalf("fades exceed snippet duration: %+v", clamped)
+	}
+}
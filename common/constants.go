@@ -22,6 +22,23 @@ const (
 	BeatmapStatusApproved     BeatmapStatus = iota
 )
 
+type BeatmapStatusAction string
+
+const (
+	BeatmapActionNominate   BeatmapStatusAction = "nominate"
+	BeatmapActionQualify    BeatmapStatusAction = "qualify"
+	BeatmapActionDisqualify BeatmapStatusAction = "disqualify"
+	BeatmapActionRank       BeatmapStatusAction = "rank"
+	BeatmapActionUnrank     BeatmapStatusAction = "unrank"
+)
+
+type UserPermissions int
+
+const (
+	PermissionNominate UserPermissions = 1 << iota
+	PermissionRank
+)
+
 type BeatmapAvailability int
 
 const (
@@ -39,6 +56,17 @@ const (
 	ScoreStatusPB        ScoreStatus = iota
 )
 
+type ScoreFlagType string
+
+const (
+	ScoreFlagFrameTiming    ScoreFlagType = "frame_timing"
+	ScoreFlagTimewarp       ScoreFlagType = "timewarp"
+	ScoreFlagCursorSnap     ScoreFlagType = "cursor_snap"
+	ScoreFlagTapConsistency ScoreFlagType = "tap_consistency"
+	ScoreFlagJudgement      ScoreFlagType = "judgement"
+	ScoreFlagReplayTheft    ScoreFlagType = "replay_theft"
+)
+
 type Grade int
 
 const (
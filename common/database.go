@@ -1,9 +1,11 @@
 package common
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	gormLogger "gorm.io/gorm/logger"
@@ -48,3 +50,9 @@ func CreateDatabaseSession(config *DatabaseConfiguration) (*gorm.DB, error) {
 
 	return db, nil
 }
+
+// IsUniqueViolation returns whether a query failed on a unique constraint
+func IsUniqueViolation(err error) bool {
+	var pgError *pgconn.PgError
+	return errors.As(err, &pgError) && pgError.Code == "23505"
+}
@@ -1,9 +1,12 @@
 package common
 
 import (
+	"sort"
 	"strings"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func CreateUser(user *User, state *State) error {
@@ -51,6 +54,40 @@ func FetchUserByNameCaseInsensitive(name string, state *State, preload ...string
 	return user, nil
 }
 
+func FetchUsersInBatches(batchSize int, state *State, callback func(users []*User) error, preload ...string) error {
+	users := []*User{}
+	query := preloadQuery(state, preload).Order("id ASC")
+	result := query.FindInBatches(&users, batchSize, func(_ *gorm.DB, _ int) error {
+		return callback(users)
+	})
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func UpdateUserRestricted(userId int, restricted bool, state *State) error {
+	result := state.Database.Model(&User{}).Where("id = ?", userId).Update("restricted", restricted)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func UpdateUserPermissions(userId int, permissions UserPermissions, state *State) error {
+	result := state.Database.Model(&User{}).Where("id = ?", userId).Update("permissions", permissions)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
 func CreateStats(stats *Stats, state *State) error {
 	result := state.Database.Create(stats)
 
@@ -72,6 +109,56 @@ func FetchStatsByUserId(userId int, state *State) (*Stats, error) {
 	return stats, nil
 }
 
+// FetchStatsForUpdate locks the stats row of a user until the
+// surrounding transaction ends, to serialize their submissions.
+func FetchStatsForUpdate(userId int, state *State) (*Stats, error) {
+	stats := &Stats{}
+	query := state.Database.Clauses(clause.Locking{Strength: "UPDATE"})
+	result := query.First(stats, "user_id = ?", userId)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return stats, nil
+}
+
+// FetchRankedUserCount returns the amount of unrestricted
+// users that have cleared at least one ranked beatmap.
+func FetchRankedUserCount(state *State) (int, error) {
+	var count int64
+	query := state.Database.Model(&Stats{}).Joins("JOIN users ON users.id = stats.user_id")
+	result := rankedUsersFilter(query).Count(&count)
+
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	return int(count), nil
+}
+
+// FetchRandomRankedUsers returns a random sample of the users,
+// that are counted by FetchRankedUserCount
+func FetchRandomRankedUsers(amount int, state *State) ([]*User, error) {
+	users := []*User{}
+	query := preloadQuery(state, []string{"Stats"}).Joins("JOIN stats ON stats.user_id = users.id")
+	result := rankedUsersFilter(query).Order("random()").Limit(amount).Find(&users)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return users, nil
+}
+
+func rankedUsersFilter(query *gorm.DB) *gorm.DB {
+	return query.Where(
+		"users.restricted = ? AND (stats.xh_count + stats.x_count + stats.sh_count + stats.s_count + "+
+			"stats.a_count + stats.b_count + stats.c_count + stats.d_count) > 0",
+		false,
+	)
+}
+
 func UpdateStats(stats *Stats, state *State) error {
 	result := state.Database.Save(stats)
 
@@ -82,6 +169,59 @@ func UpdateStats(stats *Stats, state *State) error {
 	return nil
 }
 
+// UpdateStatsBests only writes the stats that are derived from the personal
+// bests of a user, e.g. pp & accuracy, and leaves their play totals as they are
+func UpdateStatsBests(stats *Stats, state *State) error {
+	result := state.Database.Model(stats).
+		Select(
+			"ranked_score", "accuracy", "pp", "max_combo",
+			"xh_count", "x_count", "sh_count", "s_count",
+			"a_count", "b_count", "c_count", "d_count",
+		).
+		Updates(stats)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+// UpdateStatsRank only writes the rank of a user, so that it
+// can't overwrite concurrent changes to the rest of their stats
+func UpdateStatsRank(userId int, rank int, state *State) error {
+	result := state.Database.Model(&Stats{}).Where("user_id = ?", userId).Update("rank", rank)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+// FetchScoreTotals sums up the total score, hits & amount
+// of all scores a user has ever submitted.
+func FetchScoreTotals(userId int, state *State) (totalScore int64, totalHits int64, playcount int, err error) {
+	totals := struct {
+		TotalScore int64
+		TotalHits  int64
+		Playcount  int
+	}{}
+
+	query := state.Database.Model(&Score{}).Where("user_id = ?", userId)
+	result := query.Select(
+		"COALESCE(SUM(total_score), 0) AS total_score, " +
+			"COALESCE(SUM(count_300 + count_100 + count_50), 0) AS total_hits, " +
+			"COUNT(*) AS playcount",
+	).Scan(&totals)
+
+	if result.Error != nil {
+		return 0, 0, 0, result.Error
+	}
+
+	return totals.TotalScore, totals.TotalHits, totals.Playcount, nil
+}
+
 func UpdatePlaytime(userId int, secondsToAdd int, state *State) error {
 	result := state.Database.Exec(
 		"UPDATE stats SET playtime = playtime + ? WHERE user_id = ?",
@@ -159,6 +299,20 @@ func FetchBeatmapsetById(id int, state *State, preload ...string) (*Beatmapset,
 	return beatmapset, nil
 }
 
+// FetchBeatmapsetForUpdate locks the row of a beatmapset until the
+// surrounding transaction ends, to serialize its status changes.
+func FetchBeatmapsetForUpdate(id int, state *State) (*Beatmapset, error) {
+	beatmapset := &Beatmapset{}
+	query := state.Database.Clauses(clause.Locking{Strength: "UPDATE"})
+	result := query.First(beatmapset, id)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return beatmapset, nil
+}
+
 func RemoveBeatmapset(beatmapset *Beatmapset, state *State) error {
 	result := state.Database.Delete(beatmapset)
 
@@ -169,6 +323,31 @@ func RemoveBeatmapset(beatmapset *Beatmapset, state *State) error {
 	return nil
 }
 
+// FetchBeatmapsetsByIds returns the beatmapsets in the order of the given ids
+func FetchBeatmapsetsByIds(ids []int, state *State, preload ...string) ([]*Beatmapset, error) {
+	beatmapsets := []*Beatmapset{}
+
+	if len(ids) == 0 {
+		return beatmapsets, nil
+	}
+
+	result := preloadQuery(state, preload).Find(&beatmapsets, "id IN ?", ids)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	positions := make(map[int]int, len(ids))
+	for i, id := range ids {
+		positions[id] = i
+	}
+
+	sort.Slice(beatmapsets, func(i, j int) bool {
+		return positions[beatmapsets[i].Id] < positions[beatmapsets[j].Id]
+	})
+
+	return beatmapsets, nil
+}
+
 func FetchBeatmapsetsByCreatorId(userId int, state *State, preload ...string) ([]Beatmapset, error) {
 	beatmapsets := []Beatmapset{}
 	result := preloadQuery(state, preload).Find(&beatmapsets, "creator_id = ?", userId)
@@ -234,6 +413,33 @@ func UpdateBeatmapset(beatmapset *Beatmapset, state *State) error {
 	return nil
 }
 
+// UpdateBeatmapsetStatus only writes the status columns of a beatmapset,
+// so that it can't overwrite concurrent changes to the rest of it
+func UpdateBeatmapsetStatus(beatmapset *Beatmapset, state *State) error {
+	result := state.Database.Model(beatmapset).
+		Select("status", "qualified_at", "approved_at", "approved_by").
+		Updates(beatmapset)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func IncrementBeatmapsetDownloads(beatmapsetId int, state *State) error {
+	result := state.Database.Exec(
+		"UPDATE beatmapsets SET downloads = downloads + 1 WHERE id = ?",
+		beatmapsetId,
+	)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
 func CreateBeatmap(beatmap *Beatmap, state *State) error {
 	result := state.Database.Create(beatmap)
 
@@ -288,6 +494,21 @@ func FetchBeatmapsBySetId(setId int, state *State, preload ...string) ([]Beatmap
 	return beatmaps, nil
 }
 
+// FetchBeatmapsInBatches calls the callback for every batch of submitted beatmaps
+func FetchBeatmapsInBatches(batchSize int, state *State, callback func(beatmaps []*Beatmap) error) error {
+	beatmaps := []*Beatmap{}
+	query := state.Database.Where("status > ?", BeatmapStatusNotSubmitted).Order("id ASC")
+	result := query.FindInBatches(&beatmaps, batchSize, func(_ *gorm.DB, _ int) error {
+		return callback(beatmaps)
+	})
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
 func UpdateBeatmap(beatmap *Beatmap, state *State) error {
 	result := state.Database.Save(beatmap)
 
@@ -318,6 +539,85 @@ func RemoveBeatmapsBySetId(setId int, state *State) error {
 	return nil
 }
 
+// UpdateBeatmapsStatusBySetId sets the status of every beatmap in a set
+func UpdateBeatmapsStatusBySetId(setId int, status BeatmapStatus, state *State) error {
+	result := state.Database.Model(&Beatmap{}).Where("set_id = ?", setId).Update("status", status)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+// FetchQualifiedBeatmapsets returns all beatmapsets that
+// have been qualified before the given point in time.
+func FetchQualifiedBeatmapsets(qualifiedBefore time.Time, state *State, preload ...string) ([]*Beatmapset, error) {
+	beatmapsets := []*Beatmapset{}
+	query := preloadQuery(state, preload).Where("status = ? AND qualified_at <= ?", BeatmapStatusPending, qualifiedBefore)
+	result := query.Order("qualified_at ASC").Find(&beatmapsets)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return beatmapsets, nil
+}
+
+func CreateBeatmapNomination(nomination *BeatmapNomination, state *State) error {
+	result := state.Database.Create(nomination)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func FetchBeatmapNominations(setId int, state *State, preload ...string) ([]*BeatmapNomination, error) {
+	nominations := []*BeatmapNomination{}
+	query := preloadQuery(state, preload).Where("set_id = ?", setId)
+	result := query.Order("created_at ASC").Find(&nominations)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return nominations, nil
+}
+
+func RemoveBeatmapNominations(setId int, state *State) error {
+	result := state.Database.Where("set_id = ?", setId).Delete(&BeatmapNomination{})
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func CreateBeatmapStatusChange(change *BeatmapStatusChange, state *State) error {
+	result := state.Database.Create(change)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func FetchBeatmapStatusChanges(setId int, state *State, preload ...string) ([]*BeatmapStatusChange, error) {
+	changes := []*BeatmapStatusChange{}
+	query := preloadQuery(state, preload).Where("set_id = ?", setId)
+	result := query.Order("created_at ASC, id ASC").Find(&changes)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return changes, nil
+}
+
 func CreateForum(forum *Forum, state *State) error {
 	result := state.Database.Create(forum)
 
@@ -401,6 +701,18 @@ func UpdateTopic(topic *ForumTopic, state *State) error {
 	return nil
 }
 
+// MoveTopic moves a topic & all of its posts into another forum
+func MoveTopic(topic *ForumTopic, forumId int, state *State) error {
+	result := state.Database.Model(&ForumPost{}).Where("topic_id = ?", topic.Id).Update("forum_id", forumId)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	topic.ForumId = forumId
+	return UpdateTopic(topic, state)
+}
+
 func DeleteTopic(topic *ForumTopic, state *State) error {
 	result := state.Database.Delete(topic)
 
@@ -500,6 +812,18 @@ func FetchPersonalBest(userId int, beatmapId int, state *State, preload ...strin
 	return score, nil
 }
 
+func FetchScoreByChecksum(userId int, checksum string, state *State, preload ...string) (*Score, error) {
+	score := &Score{}
+	query := preloadQuery(state, preload).Where("user_id = ? AND checksum = ?", userId, checksum)
+	result := query.First(score)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return score, nil
+}
+
 func FetchBestScores(userId int, beatmapStatus int, state *State) ([]*Score, error) {
 	scores := []*Score{}
 	query := state.Database.Joins("JOIN beatmaps ON scores.beatmap_id = beatmaps.id")
@@ -513,10 +837,55 @@ func FetchBestScores(userId int, beatmapStatus int, state *State) ([]*Score, err
 	return scores, nil
 }
 
+// FetchTopPerformanceScores returns the best scores of a user
+// on ranked & approved beatmaps, ordered by their pp.
+func FetchTopPerformanceScores(userId int, limit int, state *State) ([]*Score, error) {
+	scores := []*Score{}
+	query := state.Database.Joins("JOIN beatmaps ON scores.beatmap_id = beatmaps.id")
+	query = query.Where("scores.user_id = ? AND scores.status = ? AND beatmaps.status IN ?", userId, ScoreStatusPB, []BeatmapStatus{BeatmapStatusRanked, BeatmapStatusApproved})
+	result := query.Order("scores.pp DESC").Limit(limit).Find(&scores)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return scores, nil
+}
+
+// FetchBestAccuracy returns the average accuracy across the best
+// scores of a user on ranked & approved beatmaps.
+func FetchBestAccuracy(userId int, state *State) (float64, error) {
+	accuracy := 0.0
+	query := state.Database.Model(&Score{}).Joins("JOIN beatmaps ON scores.beatmap_id = beatmaps.id")
+	query = query.Where("scores.user_id = ? AND scores.status = ? AND beatmaps.status IN ?", userId, ScoreStatusPB, []BeatmapStatus{BeatmapStatusRanked, BeatmapStatusApproved})
+	result := query.Select("COALESCE(AVG(scores.accuracy), 0)").Scan(&accuracy)
+
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	return accuracy, nil
+}
+
+// FetchBestMaxCombo returns the highest combo across the best
+// scores of a user on ranked & approved beatmaps.
+func FetchBestMaxCombo(userId int, state *State) (int, error) {
+	maxCombo := 0
+	query := state.Database.Model(&Score{}).Joins("JOIN beatmaps ON scores.beatmap_id = beatmaps.id")
+	query = query.Where("scores.user_id = ? AND scores.status = ? AND beatmaps.status IN ?", userId, ScoreStatusPB, []BeatmapStatus{BeatmapStatusRanked, BeatmapStatusApproved})
+	result := query.Select("COALESCE(MAX(scores.max_combo), 0)").Scan(&maxCombo)
+
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	return maxCombo, nil
+}
+
 func FetchRangeScores(beatmapId int, state *State, preload ...string) ([]*Score, error) {
 	scores := []*Score{}
 	query := state.Database.Where("scores.beatmap_id = ? AND scores.status = ?", beatmapId, ScoreStatusPB)
-	result := query.Order("total_score DESC").Find(&scores)
+	result := query.Order("total_score DESC, id ASC").Find(&scores)
 
 	if result.Error != nil {
 		return nil, result.Error
@@ -525,6 +894,49 @@ func FetchRangeScores(beatmapId int, state *State, preload ...string) ([]*Score,
 	return scores, nil
 }
 
+// FetchScorePosition returns the position of a personal best on the
+// leaderboard of its beatmap, where equal scores are ordered by age.
+func FetchScorePosition(score *Score, state *State) (int, error) {
+	var count int64
+	query := state.Database.Model(&Score{}).Where("beatmap_id = ? AND status = ?", score.BeatmapId, ScoreStatusPB)
+	query = query.Where("total_score > ? OR (total_score = ? AND id < ?)", score.TotalScore, score.TotalScore, score.Id)
+	result := query.Count(&count)
+
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	return int(count) + 1, nil
+}
+
+// FetchBeatmapScores returns all scores on a beatmap, best scores first
+// and older scores before newer ones with the same total score
+func FetchBeatmapScores(beatmapId int, state *State) ([]*Score, error) {
+	scores := []*Score{}
+	query := state.Database.Where("beatmap_id = ?", beatmapId)
+	result := query.Order("total_score DESC, id ASC").Find(&scores)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return scores, nil
+}
+
+func UpdateScoresStatus(scoreIds []int, status ScoreStatus, state *State) error {
+	if len(scoreIds) == 0 {
+		return nil
+	}
+
+	result := state.Database.Model(&Score{}).Where("id IN ?", scoreIds).Update("status", status)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
 func UpdateScore(score *Score, state *State) error {
 	result := state.Database.Save(score)
 
@@ -535,6 +947,38 @@ func UpdateScore(score *Score, state *State) error {
 	return nil
 }
 
+func IncrementReplayViews(scoreId int, state *State) error {
+	result := state.Database.Exec(
+		"UPDATE scores SET replay_views = replay_views + 1 WHERE id = ?",
+		scoreId,
+	)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+// FetchDuplicateScores returns resubmitted copies of the same score, which
+// are all scores of a user & checksum except for the personal best, if
+// there is one, or otherwise the first submission
+func FetchDuplicateScores(state *State) ([]*Score, error) {
+	copies := state.Database.Model(&Score{}).Select(
+		"id, ROW_NUMBER() OVER (PARTITION BY user_id, checksum ORDER BY status DESC, id ASC) AS position",
+	)
+	duplicates := state.Database.Table("(?) AS copies", copies).Select("id").Where("position > 1")
+
+	scores := []*Score{}
+	result := state.Database.Where("id IN (?)", duplicates).Order("id ASC").Find(&scores)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return scores, nil
+}
+
 func DeleteScore(score *Score, state *State) error {
 	result := state.Database.Delete(score)
 
@@ -545,6 +989,149 @@ func DeleteScore(score *Score, state *State) error {
 	return nil
 }
 
+func DeleteScores(scoreIds []int, state *State) error {
+	result := state.Database.Delete(&Score{}, scoreIds)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func CreateScoreFlags(flags []*ScoreFlag, state *State) error {
+	if len(flags) == 0 {
+		return nil
+	}
+
+	result := state.Database.Create(flags)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func FetchScoreFlags(scoreId int, state *State, preload ...string) ([]*ScoreFlag, error) {
+	flags := []*ScoreFlag{}
+	result := preloadQuery(state, preload).Find(&flags, "score_id = ?", scoreId)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return flags, nil
+}
+
+func FetchScoreFlagById(id int, state *State, preload ...string) (*ScoreFlag, error) {
+	flag := &ScoreFlag{}
+	result := preloadQuery(state, preload).First(flag, id)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return flag, nil
+}
+
+func FetchUnreviewedScoreFlags(state *State, preload ...string) ([]*ScoreFlag, error) {
+	flags := []*ScoreFlag{}
+	query := preloadQuery(state, preload).Where("reviewed = ?", false)
+	result := query.Order("created_at ASC").Find(&flags)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return flags, nil
+}
+
+func UpdateScoreFlag(flag *ScoreFlag, state *State) error {
+	result := state.Database.Save(flag)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+func CreateScoreFingerprint(fingerprint *ScoreFingerprint, state *State) error {
+	result := state.Database.Create(fingerprint)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+// FetchScoreFingerprints returns the fingerprints on a beatmap set by other
+// users, whose centroid lies close to the given one, nearest first
+func FetchScoreFingerprints(beatmapId int, excludeUserId int, centroidX float64, centroidY float64, state *State) ([]*ScoreFingerprint, error) {
+	fingerprints := []*ScoreFingerprint{}
+	tolerance := float64(FingerprintCentroidTolerance)
+
+	query := state.Database.
+		Where("beatmap_id = ? AND user_id != ?", beatmapId, excludeUserId).
+		Where("centroid_x BETWEEN ? AND ?", centroidX-tolerance, centroidX+tolerance).
+		Where("centroid_y BETWEEN ? AND ?", centroidY-tolerance, centroidY+tolerance).
+		Order(clause.OrderBy{
+			Expression: clause.Expr{
+				SQL:                "ABS(centroid_x - ?) + ABS(centroid_y - ?)",
+				Vars:               []interface{}{centroidX, centroidY},
+				WithoutParentheses: true,
+			},
+		}).
+		Limit(FingerprintMaxCandidates)
+
+	result := query.Find(&fingerprints)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return fingerprints, nil
+}
+
+// CreateUserAchievement stores an unlocked achievement, unless the user
+// has already unlocked it, e.g. through a concurrent submission. It
+// returns whether the achievement was stored.
+func CreateUserAchievement(achievement *UserAchievement, state *State) (bool, error) {
+	result := state.Database.Clauses(clause.OnConflict{DoNothing: true}).Create(achievement)
+
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+func FetchUserAchievements(userId int, state *State) ([]*UserAchievement, error) {
+	achievements := []*UserAchievement{}
+	query := state.Database.Where("user_id = ?", userId)
+	result := query.Order("unlocked_at ASC").Find(&achievements)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return achievements, nil
+}
+
+// FetchIds returns the ids of all rows of the model's table
+func FetchIds(model interface{}, state *State) ([]int, error) {
+	ids := []int{}
+	result := state.Database.Model(model).Pluck("id", &ids)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return ids, nil
+}
+
 func preloadQuery(state *State, preload []string) *gorm.DB {
 	result := state.Database
 
@@ -17,9 +17,15 @@ type User struct {
 	Restricted     bool      `gorm:"not null;default:false"`
 	Activated      bool      `gorm:"not null;default:false"`
 
+	Permissions UserPermissions `gorm:"not null;default:0"`
+
 	Stats Stats `gorm:"foreignKey:UserId"`
 }
 
+func (user *User) HasPermission(permission UserPermissions) bool {
+	return user.Permissions&permission == permission
+}
+
 type Stats struct {
 	UserId      int     `gorm:"primaryKey;not null"`
 	Rank        int     `gorm:"not null;default:0"`
@@ -28,6 +34,7 @@ type Stats struct {
 	Playcount   int     `gorm:"not null;default:0"`
 	Playtime    int     `gorm:"not null;default:0"`
 	Accuracy    float64 `gorm:"not null;default:0.0000"`
+	PP          float64 `gorm:"not null;default:0"`
 	MaxCombo    int     `gorm:"not null;default:0"`
 	TotalHits   int64   `gorm:"not null;default:0"`
 	XHCount     int     `gorm:"not null;default:0"`
@@ -80,6 +87,8 @@ type Beatmapset struct {
 	AvailabilityStatus BeatmapAvailability `gorm:"not null;default:0"`
 	AvailabilityInfo   string              `gorm:"type:text;not null;default:''"`
 	TopicId            *int                `gorm:"default:null"`
+	QualifiedAt        *time.Time          `gorm:"default:null"`
+	Downloads          int                 `gorm:"not null;default:0"`
 
 	Beatmaps []Beatmap  `gorm:"foreignKey:SetId"`
 	Topic    ForumTopic `gorm:"foreignKey:TopicId"`
@@ -120,6 +129,27 @@ func (beatmap *Beatmap) TotalObjects() int {
 	return beatmap.TotalCircles + beatmap.TotalSliders + beatmap.TotalSpinners + beatmap.TotalHolds
 }
 
+type BeatmapNomination struct {
+	SetId     int       `gorm:"primaryKey;not null"`
+	UserId    int       `gorm:"primaryKey;not null"`
+	CreatedAt time.Time `gorm:"not null;default:now()"`
+
+	User User `gorm:"foreignKey:UserId"`
+}
+
+type BeatmapStatusChange struct {
+	Id             int                 `gorm:"primaryKey;autoIncrement;not null"`
+	SetId          int                 `gorm:"not null;index"`
+	UserId         *int                `gorm:"default:null"`
+	Action         BeatmapStatusAction `gorm:"size:32;not null"`
+	PreviousStatus BeatmapStatus       `gorm:"not null"`
+	Status         BeatmapStatus       `gorm:"not null"`
+	Reason         string              `gorm:"type:text;not null;default:''"`
+	CreatedAt      time.Time           `gorm:"not null;default:now()"`
+
+	User *User `gorm:"foreignKey:UserId"`
+}
+
 type Forum struct {
 	Id          int       `gorm:"primaryKey;autoIncrement;not null"`
 	ParentId    *int      `gorm:"default:null"`
@@ -170,14 +200,15 @@ type ForumPost struct {
 type Score struct {
 	Id            int         `gorm:"primaryKey;autoIncrement;not null"`
 	BeatmapId     int         `gorm:"not null"`
-	UserId        int         `gorm:"not null"`
-	Checksum      string      `gorm:"size:32;not null"`
+	UserId        int         `gorm:"not null;uniqueIndex:idx_scores_user_checksum"`
+	Checksum      string      `gorm:"size:32;not null;uniqueIndex:idx_scores_user_checksum"`
 	Status        ScoreStatus `gorm:"not null"`
 	CreatedAt     time.Time   `gorm:"not null;default:now()"`
 	ClientVersion int         `gorm:"not null"`
 	TotalScore    int64       `gorm:"not null"`
 	MaxCombo      int         `gorm:"not null"`
 	Accuracy      float64     `gorm:"not null"`
+	PP            float64     `gorm:"not null;default:0"`
 	FullCombo     bool        `gorm:"not null"`
 	Passed        bool        `gorm:"not null"`
 	Grade         Grade       `gorm:"type:score_grade;not null"`
@@ -197,7 +228,45 @@ type Score struct {
 	ModNoFail     bool        `gorm:"not null;column:mod_nofail"`
 	Visible       bool        `gorm:"not null;default:true"`
 	Pinned        bool        `gorm:"not null;default:false"`
+	ReplayViews   int         `gorm:"not null;default:0"`
 
 	Beatmap Beatmap `gorm:"foreignKey:BeatmapId"`
 	User    User    `gorm:"foreignKey:UserId"`
 }
+
+type ScoreFlag struct {
+	Id         int           `gorm:"primaryKey;autoIncrement;not null"`
+	ScoreId    int           `gorm:"not null;index"`
+	UserId     int           `gorm:"not null;index"`
+	Type       ScoreFlagType `gorm:"size:32;not null"`
+	Details    string        `gorm:"type:text;not null;default:''"`
+	CreatedAt  time.Time     `gorm:"not null;default:now()"`
+	Reviewed   bool          `gorm:"not null;default:false"`
+	ReviewedBy *int          `gorm:"default:null"`
+
+	Score    Score `gorm:"foreignKey:ScoreId"`
+	User     User  `gorm:"foreignKey:UserId"`
+	Reviewer *User `gorm:"foreignKey:ReviewedBy"`
+}
+
+type ScoreFingerprint struct {
+	ScoreId     int       `gorm:"primaryKey;not null"`
+	BeatmapId   int       `gorm:"not null;index:idx_score_fingerprints_centroid,priority:1"`
+	UserId      int       `gorm:"not null"`
+	CentroidX   float64   `gorm:"not null;index:idx_score_fingerprints_centroid,priority:2"`
+	CentroidY   float64   `gorm:"not null"`
+	Fingerprint []byte    `gorm:"type:bytea;not null"`
+	CreatedAt   time.Time `gorm:"not null;default:now()"`
+
+	Score Score `gorm:"foreignKey:ScoreId"`
+}
+
+type UserAchievement struct {
+	UserId     int       `gorm:"primaryKey;not null"`
+	Name       string    `gorm:"primaryKey;size:64;not null"`
+	ScoreId    *int      `gorm:"default:null"`
+	UnlockedAt time.Time `gorm:"not null;default:now()"`
+
+	User  User   `gorm:"foreignKey:UserId"`
+	Score *Score `gorm:"foreignKey:ScoreId"`
+}
@@ -0,0 +1,21 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestIsUniqueViolation(t *testing.T) {
+	violation := fmt.Errorf("failed to insert score: %w", &pgconn.PgError{Code: "23505"})
+
+	if !IsUniqueViolation(violation) {
+		t.Fatal("expected wrapped unique violation to be detected")
+	}
+
+	if IsUniqueViolation(&pgconn.PgError{Code: "23503"}) || IsUniqueViolation(errors.New("record not found")) {
+		t.Fatal("expected other errors to not be unique violations")
+	}
+}
@@ -0,0 +1,202 @@
+package common
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// Distances are normalized to a circle radius of 52 pixels
+	difficultyNormalizedRadius = 52.0
+
+	// Minimum time between two objects in ms, to avoid extreme values
+	difficultyMinimumDeltaTime = 25.0
+
+	// Strains are measured in sections of this length (in ms of real time)
+	difficultySectionLength = 400.0
+
+	// Weight of each subsequent section peak, when summing them up
+	difficultyDecayWeight = 0.9
+
+	difficultyStarScaling = 0.0675
+
+	aimDecayBase       = 0.15
+	aimWeightScaling   = 26.25
+	speedDecayBase     = 0.3
+	speedWeightScaling = 1400.0
+
+	// Spacing thresholds for speed strain, in normalized pixels
+	speedSingleSpacing  = 125.0
+	speedStreamSpacing  = 110.0
+	speedAlmostDiameter = 90.0
+)
+
+type DifficultyAttributes struct {
+	StarRating  float64
+	AimRating   float64
+	SpeedRating float64
+
+	// Effective difficulty settings, including mods
+	CS           float64
+	OD           float64
+	AR           float64
+	PlaybackRate float64
+
+	ObjectCount  int
+	CircleCount  int
+	SpinnerCount int
+}
+
+type difficultyObject struct {
+	Time      float64
+	DeltaTime float64
+	Distance  float64
+}
+
+// CalculateDifficulty computes the star rating of a beatmap from its hit
+// objects, using separate aim & speed strains. Difficulty settings should
+// already include mod offsets, which makes the rating mod-adjusted.
+func CalculateDifficulty(objects []HitObject, difficulty JudgementDifficulty) *DifficultyAttributes {
+	attributes := &DifficultyAttributes{
+		CS:           difficulty.CS,
+		OD:           difficulty.OD,
+		AR:           difficulty.AR,
+		PlaybackRate: difficulty.PlaybackRate,
+		ObjectCount:  len(objects),
+	}
+
+	for _, object := range objects {
+		switch object.Type {
+		case HitObjectCircle:
+			attributes.CircleCount++
+		case HitObjectSpinner:
+			attributes.SpinnerCount++
+		}
+	}
+
+	difficultyObjects := createDifficultyObjects(objects, difficulty)
+
+	if len(difficultyObjects) == 0 {
+		return attributes
+	}
+
+	aimStrain := calculateStrain(difficultyObjects, aimDecayBase, aimWeightScaling, aimValue)
+	speedStrain := calculateStrain(difficultyObjects, speedDecayBase, speedWeightScaling, speedValue)
+
+	attributes.AimRating = math.Sqrt(aimStrain) * difficultyStarScaling * approachRateMultiplier(difficulty.AR)
+	attributes.SpeedRating = math.Sqrt(speedStrain) * difficultyStarScaling * overallDifficultyMultiplier(difficulty.OD)
+	attributes.StarRating = attributes.AimRating + attributes.SpeedRating +
+		math.Abs(attributes.SpeedRating-attributes.AimRating)*0.5
+
+	return attributes
+}
+
+// createDifficultyObjects converts hit objects into real-time deltas and
+// normalized jump distances. Spinners are skipped, as they have no position.
+func createDifficultyObjects(objects []HitObject, difficulty JudgementDifficulty) []difficultyObject {
+	sorted := make([]HitObject, 0, len(objects))
+
+	for _, object := range objects {
+		if object.Type == HitObjectSpinner {
+			continue
+		}
+		sorted = append(sorted, object)
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time < sorted[j].Time
+	})
+
+	rate := difficulty.PlaybackRate
+	if rate <= 0 {
+		rate = 1
+	}
+
+	scale := difficultyNormalizedRadius / math.Max(difficulty.CircleRadius(), 1)
+	result := make([]difficultyObject, 0, len(sorted))
+
+	for i := 1; i < len(sorted); i++ {
+		previous, current := sorted[i-1], sorted[i]
+
+		result = append(result, difficultyObject{
+			Time:      current.Time / rate,
+			DeltaTime: math.Max((current.Time-previous.Time)/rate, difficultyMinimumDeltaTime),
+			Distance:  math.Hypot(current.X-previous.X, current.Y-previous.Y) * scale,
+		})
+	}
+
+	return result
+}
+
+// calculateStrain accumulates a decaying strain over all objects and
+// returns the weighted sum of the highest strain of every section.
+func calculateStrain(objects []difficultyObject, decayBase float64, weightScaling float64, value func(difficultyObject) float64) float64 {
+	peaks := []float64{}
+	strain := 0.0
+	sectionPeak := 0.0
+	sectionEnd := math.Ceil(objects[0].Time/difficultySectionLength) * difficultySectionLength
+
+	for _, object := range objects {
+		for object.Time > sectionEnd {
+			peaks = append(peaks, sectionPeak)
+
+			// Next section starts with the strain decayed up to that point
+			sectionPeak = strain * math.Pow(decayBase, (sectionEnd-(object.Time-object.DeltaTime))/1000)
+			sectionEnd += difficultySectionLength
+		}
+
+		strain = strain*math.Pow(decayBase, object.DeltaTime/1000) + value(object)*weightScaling
+		sectionPeak = math.Max(sectionPeak, strain)
+	}
+
+	peaks = append(peaks, sectionPeak)
+	sort.Sort(sort.Reverse(sort.Float64Slice(peaks)))
+
+	total := 0.0
+	weight := 1.0
+
+	for _, peak := range peaks {
+		total += peak * weight
+		weight *= difficultyDecayWeight
+	}
+
+	return total
+}
+
+func aimValue(object difficultyObject) float64 {
+	return math.Pow(object.Distance, 0.99) / object.DeltaTime
+}
+
+func speedValue(object difficultyObject) float64 {
+	distance := object.Distance
+	value := 0.95
+
+	switch {
+	case distance > speedSingleSpacing:
+		value = 2.5
+	case distance > speedStreamSpacing:
+		value = 1.6 + 0.9*(distance-speedStreamSpacing)/(speedSingleSpacing-speedStreamSpacing)
+	case distance > speedAlmostDiameter:
+		value = 1.2 + 0.4*(distance-speedAlmostDiameter)/(speedStreamSpacing-speedAlmostDiameter)
+	case distance > speedAlmostDiameter/2:
+		value = 0.95 + 0.25*(distance-speedAlmostDiameter/2)/(speedAlmostDiameter/2)
+	}
+
+	return value / object.DeltaTime
+}
+
+// approachRateMultiplier rewards reading very high & very low approach rates
+func approachRateMultiplier(ar float64) float64 {
+	switch {
+	case ar > 9:
+		return 1 + 0.05*(ar-9)
+	case ar < 7:
+		return 1 + 0.02*(7-ar)
+	}
+	return 1
+}
+
+// overallDifficultyMultiplier rewards tapping accurately in tighter hit windows
+func overallDifficultyMultiplier(od float64) float64 {
+	return 1 + 0.01*(od-5)
+}
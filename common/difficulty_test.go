@@ -0,0 +1,59 @@
+package common
+
+import "testing"
+
+// createPatternObjects creates alternating objects with a fixed spacing & interval
+func createPatternObjects(amount int, spacing float64, interval float64) []HitObject {
+	objects := make([]HitObject, 0, amount)
+
+	for i := 0; i < amount; i++ {
+		objects = append(objects, HitObject{
+			Type: HitObjectCircle,
+			Time: 1000 + float64(i)*interval,
+			X:    200 + float64(i%2)*spacing,
+			Y:    200,
+		})
+	}
+
+	return objects
+}
+
+func TestCalculateDifficulty(t *testing.T) {
+	difficulty := NewJudgementDifficulty(4, 5, 9, 0, 0, 0, 0)
+
+	empty := CalculateDifficulty([]HitObject{}, difficulty)
+	if empty.StarRating != 0 {
+		t.Fatalf("expected 0 stars for empty beatmap, got %f", empty.StarRating)
+	}
+
+	easy := CalculateDifficulty(createPatternObjects(200, 100, 500), difficulty)
+	stream := CalculateDifficulty(createPatternObjects(200, 30, 100), difficulty)
+	jumps := CalculateDifficulty(createPatternObjects(200, 300, 250), difficulty)
+
+	if easy.StarRating <= 0 {
+		t.Fatalf("expected positive star rating, got %f", easy.StarRating)
+	}
+
+	if stream.SpeedRating <= easy.SpeedRating {
+		t.Errorf("expected stream to have higher speed rating than easy map")
+	}
+
+	if jumps.AimRating <= easy.AimRating {
+		t.Errorf("expected jumps to have higher aim rating than easy map")
+	}
+}
+
+func TestCalculateDifficultyMods(t *testing.T) {
+	objects := createPatternObjects(200, 200, 300)
+	nomod := CalculateDifficulty(objects, NewJudgementDifficulty(4, 5, 9, 0, 0, 0, 0))
+
+	faster := CalculateDifficulty(objects, NewJudgementDifficulty(4, 5, 9, 0, 0, 0, 50))
+	if faster.StarRating <= nomod.StarRating {
+		t.Errorf("expected higher play speed to increase star rating")
+	}
+
+	smaller := CalculateDifficulty(objects, NewJudgementDifficulty(4, 5, 9, 3, 0, 0, 0))
+	if smaller.AimRating <= nomod.AimRating {
+		t.Errorf("expected higher circle size to increase aim rating")
+	}
+}
@@ -0,0 +1,334 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	JobsQueueKey      = "jobs:queue"
+	JobsProcessingKey = "jobs:processing"
+	JobsLeasesKey     = "jobs:leases"
+	JobsDelayedKey    = "jobs:delayed"
+	JobsDeadKey       = "jobs:dead"
+)
+
+// Jobs are moved to the dead-letter list after this many failed attempts
+const JobMaxAttempts = 5
+
+// Jobs that are still processing after this long are considered to be
+// abandoned by a crashed worker, and are moved back onto the queue
+const JobVisibilityTimeout = 10 * time.Minute
+
+type JobType string
+
+const (
+	JobTypeAudioPreview JobType = "preview"
+	JobTypeThumbnail    JobType = "thumbnail"
+	JobTypeDifficulty   JobType = "difficulty"
+)
+
+// Job is a unit of background work. The target is the id of the object
+// the job works on, e.g. a beatmapset id for previews & thumbnails.
+type Job struct {
+	Id        string    `json:"id"`
+	Type      JobType   `json:"type"`
+	Target    int       `json:"target"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Encoded form of the job, as it is stored in redis
+	raw string
+}
+
+func NewJob(jobType JobType, target int) *Job {
+	return &Job{
+		Id:        strconv.FormatInt(time.Now().UnixNano(), 36) + strconv.Itoa(rand.Intn(1000)),
+		Type:      jobType,
+		Target:    target,
+		CreatedAt: time.Now(),
+	}
+}
+
+func (job *Job) String() string {
+	return fmt.Sprintf("%s:%d (%s)", job.Type, job.Target, job.Id)
+}
+
+func (job *Job) encode() (string, error) {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func decodeJob(raw string) (*Job, error) {
+	job := &Job{raw: raw}
+	if err := json.Unmarshal([]byte(raw), job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// JobBackoff returns the delay before a failed job is attempted again
+func JobBackoff(attempts int) time.Duration {
+	return time.Duration(1<<min(attempts, 10)) * 5 * time.Second
+}
+
+func EnqueueJob(job *Job, state *State) error {
+	raw, err := job.encode()
+	if err != nil {
+		return err
+	}
+
+	return state.Redis.LPush(*state.RedisContext, JobsQueueKey, raw).Err()
+}
+
+// DequeueJob waits for the next job and moves it onto the processing list,
+// where it stays until it was completed or failed, or its lease expired.
+// Returns nil on timeout.
+func DequeueJob(timeout time.Duration, state *State) (*Job, error) {
+	raw, err := state.Redis.BLMove(
+		*state.RedisContext,
+		JobsQueueKey,
+		JobsProcessingKey,
+		"RIGHT", "LEFT",
+		timeout,
+	).Result()
+
+	if err == redis.Nil {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The job may stay on the processing list until its lease expires
+	err = state.Redis.ZAdd(*state.RedisContext, JobsLeasesKey, redis.Z{
+		Score:  float64(time.Now().Add(JobVisibilityTimeout).Unix()),
+		Member: raw,
+	}).Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeJob(raw)
+}
+
+func CompleteJob(job *Job, state *State) error {
+	err := state.Redis.LRem(*state.RedisContext, JobsProcessingKey, 1, job.raw).Err()
+	if err != nil {
+		return err
+	}
+
+	return state.Redis.ZRem(*state.RedisContext, JobsLeasesKey, job.raw).Err()
+}
+
+// FailJob schedules a failed job for another attempt, or moves it onto
+// the dead-letter list once it has reached the maximum attempts
+func FailJob(job *Job, jobError error, state *State) error {
+	err := CompleteJob(job, state)
+	if err != nil {
+		return err
+	}
+
+	job.Attempts++
+	job.Error = jobError.Error()
+
+	raw, err := job.encode()
+	if err != nil {
+		return err
+	}
+
+	if job.Attempts >= JobMaxAttempts {
+		return state.Redis.LPush(*state.RedisContext, JobsDeadKey, raw).Err()
+	}
+
+	return state.Redis.ZAdd(*state.RedisContext, JobsDelayedKey, redis.Z{
+		Score:  float64(time.Now().Add(JobBackoff(job.Attempts)).Unix()),
+		Member: raw,
+	}).Err()
+}
+
+// PromoteDelayedJobs moves all delayed jobs that are due back onto the queue
+func PromoteDelayedJobs(state *State) (int, error) {
+	due, err := state.Redis.ZRangeByScore(
+		*state.RedisContext,
+		JobsDelayedKey,
+		&redis.ZRangeBy{Min: "-inf", Max: strconv.FormatInt(time.Now().Unix(), 10)},
+	).Result()
+
+	if err != nil {
+		return 0, err
+	}
+
+	promoted := 0
+
+	for _, raw := range due {
+		// Another worker may have promoted the job already
+		removed, err := state.Redis.ZRem(*state.RedisContext, JobsDelayedKey, raw).Result()
+		if err != nil {
+			return promoted, err
+		}
+
+		if removed == 0 {
+			continue
+		}
+
+		if err = state.Redis.LPush(*state.RedisContext, JobsQueueKey, raw).Err(); err != nil {
+			return promoted, err
+		}
+
+		promoted++
+	}
+
+	return promoted, nil
+}
+
+// RequeueExpiredJobs moves processing jobs, whose lease has expired, back
+// onto the queue. Jobs that were dequeued without getting a lease, because
+// the worker crashed in between, are leased first.
+func RequeueExpiredJobs(state *State) (int, error) {
+	processing, err := state.Redis.LRange(*state.RedisContext, JobsProcessingKey, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	requeued := 0
+
+	for _, raw := range processing {
+		expiry, err := state.Redis.ZScore(*state.RedisContext, JobsLeasesKey, raw).Result()
+
+		if err == redis.Nil {
+			// The job may have been dequeued just now
+			err = state.Redis.ZAddNX(*state.RedisContext, JobsLeasesKey, redis.Z{
+				Score:  float64(now.Add(JobVisibilityTimeout).Unix()),
+				Member: raw,
+			}).Err()
+
+			if err != nil {
+				return requeued, err
+			}
+			continue
+		}
+
+		if err != nil {
+			return requeued, err
+		}
+
+		if expiry > float64(now.Unix()) {
+			continue
+		}
+
+		// Another worker may have requeued or completed the job already
+		removed, err := state.Redis.LRem(*state.RedisContext, JobsProcessingKey, 1, raw).Result()
+		if err != nil {
+			return requeued, err
+		}
+
+		if removed == 0 {
+			continue
+		}
+
+		if err = state.Redis.RPush(*state.RedisContext, JobsQueueKey, raw).Err(); err != nil {
+			return requeued, err
+		}
+
+		if err = state.Redis.ZRem(*state.RedisContext, JobsLeasesKey, raw).Err(); err != nil {
+			return requeued, err
+		}
+
+		requeued++
+	}
+
+	return requeued, nil
+}
+
+// RequeueProcessingJobs moves all jobs on the processing list back onto
+// the queue, without waiting for their leases to expire. Only safe to call
+// while no workers are running, since it can't tell unfinished jobs apart
+// from the ones that are currently processed.
+func RequeueProcessingJobs(state *State) (int, error) {
+	requeued := 0
+
+	for {
+		raw, err := state.Redis.LMove(
+			*state.RedisContext,
+			JobsProcessingKey,
+			JobsQueueKey,
+			"RIGHT", "RIGHT",
+		).Result()
+
+		if err == redis.Nil {
+			return requeued, nil
+		}
+
+		if err != nil {
+			return requeued, err
+		}
+
+		if err = state.Redis.ZRem(*state.RedisContext, JobsLeasesKey, raw).Err(); err != nil {
+			return requeued, err
+		}
+
+		requeued++
+	}
+}
+
+func FetchDeadJobs(state *State) ([]*Job, error) {
+	entries, err := state.Redis.LRange(*state.RedisContext, JobsDeadKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*Job, 0, len(entries))
+
+	for _, raw := range entries {
+		job, err := decodeJob(raw)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// RetryDeadJobs resets the attempts of all dead jobs & enqueues them again
+func RetryDeadJobs(state *State) (int, error) {
+	retried := 0
+
+	for {
+		raw, err := state.Redis.RPop(*state.RedisContext, JobsDeadKey).Result()
+		if err == redis.Nil {
+			return retried, nil
+		}
+
+		if err != nil {
+			return retried, err
+		}
+
+		job, err := decodeJob(raw)
+		if err != nil {
+			return retried, err
+		}
+
+		job.Attempts = 0
+		job.Error = ""
+
+		if err = EnqueueJob(job, state); err != nil {
+			return retried, err
+		}
+
+		retried++
+	}
+}
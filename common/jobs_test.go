@@ -0,0 +1,197 @@
+package common
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestJobEncoding(t *testing.T) {
+	job := NewJob(JobTypeThumbnail, 42)
+	job.Attempts = 2
+
+	raw, err := job.encode()
+	if err != nil {
+		t.Fatalf("failed to encode job: %s", err)
+	}
+
+	decoded, err := decodeJob(raw)
+	if err != nil {
+		t.Fatalf("failed to decode job: %s", err)
+	}
+
+	if decoded.Id != job.Id || decoded.Type != job.Type || decoded.Target != job.Target || decoded.Attempts != job.Attempts {
+		t.Fatalf("expected %v, got %v", job, decoded)
+	}
+
+	// Completing a job removes it by its stored form
+	if decoded.raw != raw {
+		t.Fatalf("expected raw job to be kept")
+	}
+}
+
+func TestJobBackoff(t *testing.T) {
+	if backoff := JobBackoff(1); backoff != 10*time.Second {
+		t.Fatalf("expected 10s backoff, got %s", backoff)
+	}
+
+	for attempts := 1; attempts < 20; attempts++ {
+		if JobBackoff(attempts) < JobBackoff(attempts-1) {
+			t.Fatalf("backoff decreased after %d attempts", attempts)
+		}
+	}
+}
+
+// makeDelayedJobsDue reschedules all delayed jobs to now, instead of
+// waiting for their backoff
+func makeDelayedJobsDue(t *testing.T, state *State) {
+	delayed, err := state.Redis.ZRange(*state.RedisContext, JobsDelayedKey, 0, -1).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, raw := range delayed {
+		err = state.Redis.ZAdd(*state.RedisContext, JobsDelayedKey, redis.Z{Score: 0, Member: raw}).Err()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestJobRetries(t *testing.T) {
+	state := NewTestRedisState(t)
+
+	if err := EnqueueJob(NewJob(JobTypeThumbnail, 42), state); err != nil {
+		t.Fatal(err)
+	}
+
+	for attempt := 1; attempt <= JobMaxAttempts; attempt++ {
+		job, err := DequeueJob(time.Second, state)
+		if err != nil || job == nil {
+			t.Fatalf("expected job on attempt %d, got %v (%v)", attempt, job, err)
+		}
+
+		if job.Attempts != attempt-1 {
+			t.Fatalf("expected %d previous attempts, got %d", attempt-1, job.Attempts)
+		}
+
+		if err = FailJob(job, errors.New("failed"), state); err != nil {
+			t.Fatal(err)
+		}
+
+		processing, _ := state.Redis.LLen(*state.RedisContext, JobsProcessingKey).Result()
+		if processing != 0 {
+			t.Fatalf("expected failed job to leave the processing list")
+		}
+
+		// The job is not due before its backoff has passed
+		if promoted, _ := PromoteDelayedJobs(state); promoted != 0 {
+			t.Fatalf("expected no due jobs, got %d", promoted)
+		}
+
+		makeDelayedJobsDue(t, state)
+		promoted, err := PromoteDelayedJobs(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := 1
+		if attempt == JobMaxAttempts {
+			expected = 0
+		}
+
+		if promoted != expected {
+			t.Fatalf("expected %d promoted jobs after attempt %d, got %d", expected, attempt, promoted)
+		}
+	}
+
+	dead, err := FetchDeadJobs(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dead) != 1 || dead[0].Attempts != JobMaxAttempts || dead[0].Error != "failed" {
+		t.Fatalf("expected one dead job after %d attempts, got %v", JobMaxAttempts, dead)
+	}
+
+	if retried, err := RetryDeadJobs(state); err != nil || retried != 1 {
+		t.Fatalf("expected one retried job, got %d (%v)", retried, err)
+	}
+
+	job, err := DequeueJob(time.Second, state)
+	if err != nil || job == nil || job.Attempts != 0 || job.Error != "" {
+		t.Fatalf("expected retried job with reset attempts, got %v (%v)", job, err)
+	}
+
+	// Left on the processing list, as if the worker had crashed
+	if requeued, err := RequeueProcessingJobs(state); err != nil || requeued != 1 {
+		t.Fatalf("expected one requeued job, got %d (%v)", requeued, err)
+	}
+
+	if job, err = DequeueJob(time.Second, state); err != nil || job == nil {
+		t.Fatalf("expected requeued job, got %v (%v)", job, err)
+	}
+
+	if err = CompleteJob(job, state); err != nil {
+		t.Fatal(err)
+	}
+
+	processing, _ := state.Redis.LLen(*state.RedisContext, JobsProcessingKey).Result()
+	if processing != 0 {
+		t.Fatalf("expected completed job to leave the processing list")
+	}
+}
+
+func TestRequeueExpiredJobs(t *testing.T) {
+	state := NewTestRedisState(t)
+
+	if err := EnqueueJob(NewJob(JobTypeThumbnail, 42), state); err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := DequeueJob(time.Second, state)
+	if err != nil || job == nil {
+		t.Fatalf("expected job, got %v (%v)", job, err)
+	}
+
+	// Jobs stay on the processing list while their lease is valid
+	if requeued, err := RequeueExpiredJobs(state); err != nil || requeued != 0 {
+		t.Fatalf("expected no requeued jobs, got %d (%v)", requeued, err)
+	}
+
+	// Simulates a worker that crashed before the lease expired
+	err = state.Redis.ZAdd(*state.RedisContext, JobsLeasesKey, redis.Z{Score: 0, Member: job.raw}).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if requeued, err := RequeueExpiredJobs(state); err != nil || requeued != 1 {
+		t.Fatalf("expected one requeued job, got %d (%v)", requeued, err)
+	}
+
+	job, err = DequeueJob(time.Second, state)
+	if err != nil || job == nil {
+		t.Fatalf("expected requeued job, got %v (%v)", job, err)
+	}
+
+	// Simulates a worker that crashed before leasing the job
+	state.Redis.ZRem(*state.RedisContext, JobsLeasesKey, job.raw)
+
+	if requeued, err := RequeueExpiredJobs(state); err != nil || requeued != 0 {
+		t.Fatalf("expected unleased job to be leased first, got %d requeued (%v)", requeued, err)
+	}
+
+	if _, err = state.Redis.ZScore(*state.RedisContext, JobsLeasesKey, job.raw).Result(); err != nil {
+		t.Fatalf("expected unleased job to get a lease, got %v", err)
+	}
+
+	if err = CompleteJob(job, state); err != nil {
+		t.Fatal(err)
+	}
+
+	if leases, _ := state.Redis.ZCard(*state.RedisContext, JobsLeasesKey).Result(); leases != 0 {
+		t.Fatalf("expected completed job to release its lease, got %d leases", leases)
+	}
+}
@@ -0,0 +1,237 @@
+package common
+
+import (
+	"math"
+	"sort"
+)
+
+type HitObjectType int
+
+const (
+	HitObjectCircle HitObjectType = iota
+	HitObjectSlider
+	HitObjectSpinner
+	HitObjectHold
+)
+
+// HitObject is a beatmap object reduced to what is needed for judging.
+// Times are given in milliseconds of song time.
+type HitObject struct {
+	Type    HitObjectType
+	Time    float64
+	EndTime float64
+	X       float64
+	Y       float64
+}
+
+// JudgementDifficulty holds the effective difficulty
+// settings of a play, with mod offsets already applied.
+type JudgementDifficulty struct {
+	CS           float64
+	OD           float64
+	AR           float64
+	PlaybackRate float64
+}
+
+func NewJudgementDifficulty(cs, od, ar float64, csOffset, odOffset, arOffset, psOffset int) JudgementDifficulty {
+	return JudgementDifficulty{
+		CS:           ApplyDifficultyOffset(cs, csOffset),
+		OD:           ApplyDifficultyOffset(od, odOffset),
+		AR:           ApplyDifficultyOffset(ar, arOffset),
+		PlaybackRate: PlaybackRate(psOffset),
+	}
+}
+
+// ApplyDifficultyOffset applies a mod offset to a difficulty
+// setting, while keeping it inside of the valid range.
+func ApplyDifficultyOffset(value float64, offset int) float64 {
+	return math.Max(0, math.Min(10, value+float64(offset)))
+}
+
+// HitWindows returns the 300, 100 & 50 hit windows in milliseconds
+func (difficulty JudgementDifficulty) HitWindows() (float64, float64, float64) {
+	return 80 - 6*difficulty.OD,
+		140 - 8*difficulty.OD,
+		200 - 10*difficulty.OD
+}
+
+// Preempt returns the time in milliseconds an object is visible before it has to be hit
+func (difficulty JudgementDifficulty) Preempt() float64 {
+	if difficulty.AR < 5 {
+		return 1200 + 120*(5-difficulty.AR)
+	}
+	return 1200 - 150*(difficulty.AR-5)
+}
+
+// CircleRadius returns the radius of hit circles in playfield pixels
+func (difficulty JudgementDifficulty) CircleRadius() float64 {
+	return 54.4 - 4.48*difficulty.CS
+}
+
+type JudgementResult struct {
+	Count300  int
+	Count100  int
+	Count50   int
+	CountMiss int
+
+	// Longest streak of hit objects without a miss
+	MaxCombo int
+}
+
+func (result *JudgementResult) TotalObjects() int {
+	return result.Count300 + result.Count100 + result.Count50 + result.CountMiss
+}
+
+// Divergence returns the share of objects whose judgement differs
+// between two results, e.g. between a claimed and a simulated play.
+func (result *JudgementResult) Divergence(other *JudgementResult) float64 {
+	totalObjects := max(result.TotalObjects(), other.TotalObjects())
+	if totalObjects == 0 {
+		return 0
+	}
+
+	difference := abs(result.Count300-other.Count300) +
+		abs(result.Count100-other.Count100) +
+		abs(result.Count50-other.Count50) +
+		abs(result.CountMiss-other.CountMiss)
+
+	// Every differing object is counted twice, once for each judgement
+	return float64(difference) / 2 / float64(totalObjects)
+}
+
+type replayPress struct {
+	Time float64
+	X    float64
+	Y    float64
+}
+
+// JudgeReplay simulates a replay against the hit objects of a beatmap.
+// Sliders and holds are judged by their head, spinners by being held
+// for their duration.
+func JudgeReplay(objects []HitObject, frames []*ReplayFrame, difficulty JudgementDifficulty) *JudgementResult {
+	result := &JudgementResult{}
+	presses := replayPresses(frames)
+
+	// Hit windows & preempt are given in real time, while
+	// the replay is recorded in song time
+	window300, window100, window50 := difficulty.HitWindows()
+	window300 *= difficulty.PlaybackRate
+	window100 *= difficulty.PlaybackRate
+	window50 *= difficulty.PlaybackRate
+	preempt := difficulty.Preempt() * difficulty.PlaybackRate
+	radius := difficulty.CircleRadius()
+
+	sorted := make([]HitObject, len(objects))
+	copy(sorted, objects)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time < sorted[j].Time
+	})
+
+	combo := 0
+	nextPress := 0
+
+	for _, object := range sorted {
+		hit := false
+
+		if object.Type == HitObjectSpinner {
+			hit = isHeldDuring(frames, object.Time, object.EndTime)
+
+			if hit {
+				result.Count300++
+			}
+		} else {
+			earliest := object.Time - math.Min(window50, preempt)
+			latest := object.Time + window50
+
+			// Presses before this object can not be used anymore
+			for nextPress < len(presses) && presses[nextPress].Time < earliest {
+				nextPress++
+			}
+
+			for index := nextPress; index < len(presses); index++ {
+				press := presses[index]
+
+				if press.Time > latest {
+					break
+				}
+
+				if math.Hypot(press.X-object.X, press.Y-object.Y) > radius {
+					continue
+				}
+
+				offset := math.Abs(press.Time - object.Time)
+
+				switch {
+				case offset <= window300:
+					result.Count300++
+				case offset <= window100:
+					result.Count100++
+				default:
+					result.Count50++
+				}
+
+				hit = true
+				nextPress = index + 1
+				break
+			}
+		}
+
+		if !hit {
+			result.CountMiss++
+			combo = 0
+			continue
+		}
+
+		combo++
+		result.MaxCombo = max(result.MaxCombo, combo)
+	}
+
+	return result
+}
+
+// replayPresses returns every frame in which a new key/button was pressed.
+// A single key can set multiple bits, so it is counted as one press.
+func replayPresses(frames []*ReplayFrame) []replayPress {
+	presses := []replayPress{}
+	previousState := uint32(0)
+
+	for _, frame := range frames {
+		newlyPressed := frame.ButtonState &^ previousState
+		previousState = frame.ButtonState
+
+		if newlyPressed == 0 {
+			continue
+		}
+
+		presses = append(presses, replayPress{
+			Time: float64(frame.SongTime()),
+			X:    frame.MouseX,
+			Y:    frame.MouseY,
+		})
+	}
+
+	return presses
+}
+
+func isHeldDuring(frames []*ReplayFrame, start float64, end float64) bool {
+	for _, frame := range frames {
+		time := float64(frame.SongTime())
+
+		if time < start || time > end {
+			continue
+		}
+
+		if frame.ButtonState != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
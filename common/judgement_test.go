@@ -0,0 +1,89 @@
+package common
+
+import "testing"
+
+func createTestObjects(amount int) []HitObject {
+	objects := make([]HitObject, 0, amount)
+
+	for i := 0; i < amount; i++ {
+		objects = append(objects, HitObject{
+			Type: HitObjectCircle,
+			Time: float64(1000 + i*500),
+			X:    float64(100 + (i%2)*200),
+			Y:    200,
+		})
+	}
+
+	return objects
+}
+
+// createTestFrames presses on every object, shifted by the given offset
+func createTestFrames(objects []HitObject, offset int, skip map[int]bool) []*ReplayFrame {
+	frames := []*ReplayFrame{}
+
+	for i, object := range objects {
+		if skip[i] {
+			continue
+		}
+
+		time := uint32(int(object.Time) + offset)
+
+		frames = append(frames,
+			&ReplayFrame{Time: time, MouseX: object.X, MouseY: object.Y, ButtonState: 5},
+			&ReplayFrame{Time: time + 50, MouseX: object.X, MouseY: object.Y, ButtonState: 0},
+		)
+	}
+
+	return frames
+}
+
+func TestJudgeReplay(t *testing.T) {
+	objects := createTestObjects(100)
+	difficulty := NewJudgementDifficulty(4, 5, 5, 0, 0, 0, 0)
+
+	result := JudgeReplay(objects, createTestFrames(objects, 0, nil), difficulty)
+	if result.Count300 != 100 || result.MaxCombo != 100 {
+		t.Fatalf("expected 100x 300 with full combo, got %+v", *result)
+	}
+
+	// 100 window at OD5 is 60-100ms
+	result = JudgeReplay(objects, createTestFrames(objects, 70, nil), difficulty)
+	if result.Count100 != 100 {
+		t.Fatalf("expected 100x 100, got %+v", *result)
+	}
+
+	result = JudgeReplay(objects, createTestFrames(objects, 0, map[int]bool{49: true}), difficulty)
+	if result.CountMiss != 1 || result.MaxCombo != 50 {
+		t.Fatalf("expected 1 miss and 50 combo, got %+v", *result)
+	}
+}
+
+func TestJudgeReplayModOffsets(t *testing.T) {
+	objects := createTestObjects(100)
+	frames := createTestFrames(objects, 70, nil)
+
+	// An OD offset shrinks the hit windows, turning 100s into 50s
+	difficulty := NewJudgementDifficulty(4, 5, 5, 0, 5, 0, 0)
+	result := JudgeReplay(objects, frames, difficulty)
+
+	if result.Count50 != 100 {
+		t.Fatalf("expected 100x 50, got %+v", *result)
+	}
+
+	// Faster play speed widens the windows in song time
+	difficulty = NewJudgementDifficulty(4, 5, 5, 0, 0, 0, 50)
+	result = JudgeReplay(objects, createTestFrames(objects, 45, nil), difficulty)
+
+	if result.Count300 != 100 {
+		t.Fatalf("expected 100x 300, got %+v", *result)
+	}
+}
+
+func TestJudgementDivergence(t *testing.T) {
+	claimed := &JudgementResult{Count300: 90, Count100: 10}
+	simulated := &JudgementResult{Count300: 80, Count100: 10, CountMiss: 10}
+
+	if divergence := claimed.Divergence(simulated); divergence != 0.1 {
+		t.Fatalf("expected divergence of 0.1, got %f", divergence)
+	}
+}
@@ -0,0 +1,98 @@
+package common
+
+import (
+	"embed"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Migrations are applied in order of their filename, and each one only
+// once. Applied migrations must never be edited, only followed by new ones.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Arbitrary key of the advisory lock, that keeps multiple instances
+// from applying the same migration at once
+const migrationLockKey = 0x68657861
+
+func MigrationNames() ([]string, error) {
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	for i, name := range names {
+		names[i] = strings.TrimPrefix(name, "migrations/")
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// RunMigrations applies all pending migrations, each inside of its own
+// transaction, and returns the names of the applied ones
+func RunMigrations(state *State) ([]string, error) {
+	err := state.Database.Exec(
+		"CREATE TABLE IF NOT EXISTS schema_migrations (" +
+			"name varchar(255) PRIMARY KEY, " +
+			"applied_at timestamptz NOT NULL DEFAULT now())",
+	).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := MigrationNames()
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []string{}
+
+	for _, name := range names {
+		ok, err := runMigration(name, state)
+		if err != nil {
+			return applied, err
+		}
+
+		if ok {
+			applied = append(applied, name)
+		}
+	}
+
+	return applied, nil
+}
+
+func runMigration(name string, state *State) (bool, error) {
+	query, err := migrationFiles.ReadFile("migrations/" + name)
+	if err != nil {
+		return false, err
+	}
+
+	applied := false
+
+	err = state.Database.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLockKey).Error; err != nil {
+			return err
+		}
+
+		count := int64(0)
+		err := tx.Table("schema_migrations").Where("name = ?", name).Count(&count).Error
+		if err != nil || count > 0 {
+			return err
+		}
+
+		if err = tx.Exec(string(query)).Error; err != nil {
+			return err
+		}
+
+		applied = true
+		return tx.Exec("INSERT INTO schema_migrations (name) VALUES (?)", name).Error
+	})
+
+	return applied, err
+}
@@ -0,0 +1 @@
+ALTER TABLE scores ADD COLUMN IF NOT EXISTS replay_views integer NOT NULL DEFAULT 0;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS score_flags (
+    id serial PRIMARY KEY,
+    score_id integer NOT NULL REFERENCES scores (id) ON DELETE CASCADE,
+    user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    type varchar(32) NOT NULL,
+    details text NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL DEFAULT now(),
+    reviewed boolean NOT NULL DEFAULT false,
+    reviewed_by integer DEFAULT NULL REFERENCES users (id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_score_flags_score_id ON score_flags (score_id);
+CREATE INDEX IF NOT EXISTS idx_score_flags_user_id ON score_flags (user_id);
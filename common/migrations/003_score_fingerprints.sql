@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS score_fingerprints (
+    score_id integer PRIMARY KEY REFERENCES scores (id) ON DELETE CASCADE,
+    beatmap_id integer NOT NULL,
+    user_id integer NOT NULL,
+    centroid_x double precision NOT NULL,
+    centroid_y double precision NOT NULL,
+    fingerprint bytea NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+-- Candidates are looked up by beatmap & mean cursor position
+CREATE INDEX IF NOT EXISTS idx_score_fingerprints_centroid
+    ON score_fingerprints (beatmap_id, centroid_x);
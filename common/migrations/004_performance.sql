@@ -0,0 +1,5 @@
+ALTER TABLE scores ADD COLUMN IF NOT EXISTS pp double precision NOT NULL DEFAULT 0;
+ALTER TABLE stats ADD COLUMN IF NOT EXISTS pp double precision NOT NULL DEFAULT 0;
+
+-- Only the top personal bests of a user are weighted into their pp
+CREATE INDEX IF NOT EXISTS idx_scores_user_pp ON scores (user_id, pp DESC) WHERE status = 3;
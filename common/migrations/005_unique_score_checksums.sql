@@ -0,0 +1,24 @@
+-- Resubmitted copies of the same score are never deleted here, since
+-- stats & replays depend on them. Instead the migration fails until
+-- they were removed with the "scores deduplicate" command.
+DO $$
+DECLARE
+    duplicates text;
+BEGIN
+    SELECT string_agg(format('user %s: %s', user_id, checksum), ', ')
+    INTO duplicates
+    FROM (
+        SELECT user_id, checksum FROM scores
+        GROUP BY user_id, checksum
+        HAVING count(*) > 1
+        ORDER BY user_id, checksum
+        LIMIT 20
+    ) copies;
+
+    IF duplicates IS NOT NULL THEN
+        RAISE EXCEPTION 'duplicate score checksums (%), remove them with "-db-migrate=false scores deduplicate" first', duplicates;
+    END IF;
+END
+$$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_scores_user_checksum ON scores (user_id, checksum);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS user_achievements (
+    user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name varchar(64) NOT NULL,
+    score_id integer DEFAULT NULL REFERENCES scores (id) ON DELETE SET NULL,
+    unlocked_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, name)
+);
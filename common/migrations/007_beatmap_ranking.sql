@@ -0,0 +1,23 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS permissions integer NOT NULL DEFAULT 0;
+ALTER TABLE beatmapsets ADD COLUMN IF NOT EXISTS qualified_at timestamptz DEFAULT NULL;
+
+CREATE TABLE IF NOT EXISTS beatmap_nominations (
+    set_id integer NOT NULL REFERENCES beatmapsets (id) ON DELETE CASCADE,
+    user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (set_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS beatmap_status_changes (
+    id serial PRIMARY KEY,
+    set_id integer NOT NULL REFERENCES beatmapsets (id) ON DELETE CASCADE,
+    user_id integer DEFAULT NULL REFERENCES users (id) ON DELETE SET NULL,
+    action varchar(32) NOT NULL,
+    previous_status integer NOT NULL,
+    status integer NOT NULL,
+    reason text NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_beatmap_status_changes_set_id ON beatmap_status_changes (set_id);
+CREATE INDEX IF NOT EXISTS idx_beatmapsets_qualified_at ON beatmapsets (qualified_at) WHERE qualified_at IS NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE beatmapsets ADD COLUMN IF NOT EXISTS downloads integer NOT NULL DEFAULT 0;
@@ -0,0 +1,10 @@
+-- array_to_string is only stable, so generated columns can't use it directly
+CREATE OR REPLACE FUNCTION beatmapset_search_vector(artist text, title text, source text, tags text[])
+RETURNS tsvector LANGUAGE sql IMMUTABLE PARALLEL SAFE AS $$
+    SELECT to_tsvector('simple', artist || ' ' || title || ' ' || source || ' ' || array_to_string(tags, ' '))
+$$;
+
+ALTER TABLE beatmapsets ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (beatmapset_search_vector(artist, title, source, tags)) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_beatmapsets_search_vector ON beatmapsets USING gin (search_vector);
@@ -0,0 +1,34 @@
+package common
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMigrationNames(t *testing.T) {
+	names, err := MigrationNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(names) == 0 {
+		t.Fatal("expected embedded migrations")
+	}
+
+	if !sort.StringsAreSorted(names) {
+		t.Fatalf("expected migrations in order, got %v", names)
+	}
+
+	for _, name := range names {
+		query, err := migrationFiles.ReadFile("migrations/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Migrations run without arguments, which would be substituted
+		if strings.Contains(string(query), "?") {
+			t.Fatalf("migration %s must not contain placeholders", name)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package common
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// Each subsequent best score counts this much less towards the total
+	PerformanceWeight = 0.95
+
+	// Only this many best scores count towards the total
+	PerformanceMaxScores = 100
+
+	performanceMultiplier = 1.12
+	performanceNoFail     = 0.90
+)
+
+// PerformanceScore holds the parts of a score relevant for pp
+type PerformanceScore struct {
+	Count300  int
+	Count100  int
+	Count50   int
+	CountMiss int
+	MaxCombo  int
+	Hidden    bool
+	NoFail    bool
+
+	// Maximum combo achievable on the beatmap
+	BeatmapMaxCombo int
+}
+
+func NewPerformanceScore(score *Score, beatmapMaxCombo int) PerformanceScore {
+	return PerformanceScore{
+		Count300:        score.Count300,
+		Count100:        score.Count100,
+		Count50:         score.Count50,
+		CountMiss:       score.CountMiss,
+		MaxCombo:        score.MaxCombo,
+		Hidden:          score.ModHidden,
+		NoFail:          score.ModNoFail,
+		BeatmapMaxCombo: beatmapMaxCombo,
+	}
+}
+
+func (score PerformanceScore) TotalHits() int {
+	return score.Count300 + score.Count100 + score.Count50 + score.CountMiss
+}
+
+func (score PerformanceScore) Accuracy() float64 {
+	if score.TotalHits() == 0 {
+		return 0
+	}
+
+	return float64(score.Count300*300+score.Count100*100+score.Count50*50) /
+		float64(score.TotalHits()*300)
+}
+
+// CalculatePerformance computes the pp of a score from the mod-adjusted
+// difficulty attributes of its beatmap, split into aim, speed & accuracy.
+func CalculatePerformance(attributes *DifficultyAttributes, score PerformanceScore) float64 {
+	if score.TotalHits() == 0 {
+		return 0
+	}
+
+	aim := calculateAimPerformance(attributes, score)
+	speed := calculateSpeedPerformance(attributes, score)
+	accuracy := calculateAccuracyPerformance(attributes, score)
+
+	multiplier := performanceMultiplier
+
+	if score.NoFail {
+		multiplier *= performanceNoFail
+	}
+
+	total := math.Pow(
+		math.Pow(aim, 1.1)+math.Pow(speed, 1.1)+math.Pow(accuracy, 1.1),
+		1.0/1.1,
+	)
+
+	return total * multiplier
+}
+
+// CalculateWeightedPerformance sums up the pp of a user's best scores,
+// where each subsequent score is weighted less than the one before.
+func CalculateWeightedPerformance(performances []float64) float64 {
+	sorted := make([]float64, len(performances))
+	copy(sorted, performances)
+	sort.Sort(sort.Reverse(sort.Float64Slice(sorted)))
+
+	total := 0.0
+	weight := 1.0
+
+	for index, performance := range sorted {
+		if index >= PerformanceMaxScores {
+			break
+		}
+
+		total += performance * weight
+		weight *= PerformanceWeight
+	}
+
+	return total
+}
+
+func calculateAimPerformance(attributes *DifficultyAttributes, score PerformanceScore) float64 {
+	value := strainToPerformance(attributes.AimRating)
+	value *= lengthBonus(score.TotalHits())
+	value *= missPenalty(score.CountMiss)
+	value *= comboScaling(score)
+
+	approachRateFactor := 1.0
+
+	if attributes.AR > 9 {
+		approachRateFactor += 0.1 * (attributes.AR - 9)
+	} else if attributes.AR < 8 {
+		approachRateFactor += 0.01 * (8 - attributes.AR)
+	}
+
+	value *= approachRateFactor
+
+	if score.Hidden {
+		value *= 1 + 0.04*(12-attributes.AR)
+	}
+
+	value *= 0.5 + score.Accuracy()/2
+	value *= 0.98 + attributes.OD*attributes.OD/2500
+	return value
+}
+
+func calculateSpeedPerformance(attributes *DifficultyAttributes, score PerformanceScore) float64 {
+	value := strainToPerformance(attributes.SpeedRating)
+	value *= lengthBonus(score.TotalHits())
+	value *= missPenalty(score.CountMiss)
+	value *= comboScaling(score)
+
+	if attributes.AR > 9 {
+		value *= 1 + 0.1*(attributes.AR-9)
+	}
+
+	if score.Hidden {
+		value *= 1 + 0.04*(12-attributes.AR)
+	}
+
+	value *= 0.02 + score.Accuracy()
+	value *= 0.96 + attributes.OD*attributes.OD/1600
+	return value
+}
+
+// calculateAccuracyPerformance only rewards accuracy on circles,
+// since sliders & spinners are easy to get a 300 on.
+func calculateAccuracyPerformance(attributes *DifficultyAttributes, score PerformanceScore) float64 {
+	circles := attributes.CircleCount
+	if circles <= 0 {
+		return 0
+	}
+
+	count300 := score.Count300 - (score.TotalHits() - circles)
+	accuracy := 0.0
+
+	if count300 > 0 {
+		accuracy = float64(count300*6+score.Count100*2+score.Count50) / float64(circles*6)
+	}
+
+	accuracy = math.Max(0, math.Min(1, accuracy))
+
+	value := math.Pow(1.52163, attributes.OD) * math.Pow(accuracy, 24) * 2.83
+	value *= math.Min(1.15, math.Pow(float64(circles)/1000, 0.3))
+
+	if score.Hidden {
+		value *= 1.08
+	}
+
+	return value
+}
+
+func strainToPerformance(rating float64) float64 {
+	return math.Pow(5*math.Max(1, rating/0.0675)-4, 3) / 100000
+}
+
+func lengthBonus(totalHits int) float64 {
+	bonus := 0.95 + 0.4*math.Min(1, float64(totalHits)/2000)
+
+	if totalHits > 2000 {
+		bonus += math.Log10(float64(totalHits)/2000) * 0.5
+	}
+
+	return bonus
+}
+
+func missPenalty(misses int) float64 {
+	return math.Pow(0.97, float64(misses))
+}
+
+func comboScaling(score PerformanceScore) float64 {
+	if score.BeatmapMaxCombo <= 0 {
+		return 1
+	}
+
+	return math.Min(
+		math.Pow(float64(score.MaxCombo), 0.8)/math.Pow(float64(score.BeatmapMaxCombo), 0.8),
+		1,
+	)
+}
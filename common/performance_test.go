@@ -0,0 +1,54 @@
+package common
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCalculatePerformance(t *testing.T) {
+	difficulty := NewJudgementDifficulty(4, 8, 9, 0, 0, 0, 0)
+	attributes := CalculateDifficulty(createPatternObjects(500, 150, 200), difficulty)
+
+	perfect := PerformanceScore{Count300: 500, MaxCombo: 500, BeatmapMaxCombo: 500}
+	misses := PerformanceScore{Count300: 490, CountMiss: 10, MaxCombo: 250, BeatmapMaxCombo: 500}
+	inaccurate := PerformanceScore{Count300: 400, Count100: 100, MaxCombo: 500, BeatmapMaxCombo: 500}
+
+	perfectPerformance := CalculatePerformance(attributes, perfect)
+
+	if perfectPerformance <= 0 {
+		t.Fatalf("expected positive pp, got %f", perfectPerformance)
+	}
+
+	if CalculatePerformance(attributes, misses) >= perfectPerformance {
+		t.Errorf("expected misses to reduce pp")
+	}
+
+	if CalculatePerformance(attributes, inaccurate) >= perfectPerformance {
+		t.Errorf("expected lower accuracy to reduce pp")
+	}
+
+	perfect.NoFail = true
+	if CalculatePerformance(attributes, perfect) >= perfectPerformance {
+		t.Errorf("expected nofail to reduce pp")
+	}
+
+	harder := CalculateDifficulty(createPatternObjects(500, 150, 200), NewJudgementDifficulty(4, 8, 9, 0, 0, 0, 50))
+	perfect.NoFail = false
+
+	if CalculatePerformance(harder, perfect) <= perfectPerformance {
+		t.Errorf("expected higher play speed to increase pp")
+	}
+}
+
+func TestCalculateWeightedPerformance(t *testing.T) {
+	total := CalculateWeightedPerformance([]float64{50, 100})
+	expected := 100 + 50*PerformanceWeight
+
+	if math.Abs(total-expected) > 1e-9 {
+		t.Fatalf("expected %f weighted pp, got %f", expected, total)
+	}
+
+	if CalculateWeightedPerformance([]float64{}) != 0 {
+		t.Fatal("expected 0 pp without scores")
+	}
+}
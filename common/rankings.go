@@ -1,114 +1,436 @@
 package common
 
 import (
+	"errors"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
-func UpdateRankingsEntry(stats *Stats, country string, state *State) error {
-	country = strings.ToLower(country)
-	errors := NewErrorCollection()
+// RankUnranked is the rank of users without a rankings entry
+const RankUnranked = 0
+
+const (
+	// Rankings are rebuilt into sets under this prefix, which
+	// replace the live sets once the rebuild is complete
+	RankingsRebuildPrefix = "rebuild:"
+	// Marks a running rebuild & expires, in case it was aborted.
+	// Rebuilds refresh it while they are making progress.
+	RankingsRebuildKey     = "rankings-rebuild"
+	RankingsRebuildTimeout = time.Hour
+	// Amount of users, whose entries are compared against
+	// their stats when checking if the rankings are outdated
+	RankingsSampleSize = 10
+)
+
+// ErrRankingsRebuildExpired is returned when the marker of a rebuild
+// expired before it was finished, since entries written in the meantime
+// only went into the live sets & would be lost with the rebuilt ones
+var ErrRankingsRebuildExpired = errors.New("rankings rebuild expired before it was finished")
+
+var RankingsKeys = []string{
+	"rankings:rscore",
+	"rankings:tscore",
+	"rankings:clears",
+	"rankings:pp",
+}
 
-	entries := map[string]float64{
+// RankingsEntries returns the score of a user in each global rankings set
+func RankingsEntries(stats *Stats) map[string]float64 {
+	return map[string]float64{
 		"rankings:rscore": float64(stats.RankedScore),
 		"rankings:tscore": float64(stats.TotalScore),
 		"rankings:clears": float64(stats.Clears()),
+		"rankings:pp":     stats.PP,
 	}
+}
+
+func UpdateRankingsEntry(stats *Stats, country string, state *State) error {
+	return updateRankingsEntry(stats, country, rankingsPrefixes(state), state)
+}
+
+func RemoveRankingsEntry(stats *Stats, country string, state *State) error {
+	country = strings.ToLower(country)
+	errors := NewErrorCollection()
 
-	for key, score := range entries {
-		result := state.Redis.ZAdd(
-			*state.RedisContext, key,
-			redis.Z{
-				Score:  score,
-				Member: stats.UserId,
-			},
-		)
-		errors.Add(result.Err())
+	for _, prefix := range rankingsPrefixes(state) {
+		for _, key := range RankingsKeys {
+			result := state.Redis.ZRem(
+				*state.RedisContext, prefix+key, stats.UserId,
+			)
+			errors.Add(result.Err())
 
-		result = state.Redis.ZAdd(
-			*state.RedisContext, key+":"+country,
-			redis.Z{
-				Score:  score,
-				Member: stats.UserId,
-			},
-		)
-		errors.Add(result.Err())
+			result = state.Redis.ZRem(
+				*state.RedisContext, prefix+key+":"+country, stats.UserId,
+			)
+			errors.Add(result.Err())
+		}
 	}
 
 	return errors.Next()
 }
 
-func RemoveRankingsEntry(stats *Stats, country string, state *State) error {
+func updateRankingsEntry(stats *Stats, country string, prefixes []string, state *State) error {
 	country = strings.ToLower(country)
 	errors := NewErrorCollection()
 
-	entries := []string{
-		"rankings:rscore",
-		"rankings:tscore",
-		"rankings:clears",
+	for _, prefix := range prefixes {
+		for key, score := range RankingsEntries(stats) {
+			result := state.Redis.ZAdd(
+				*state.RedisContext, prefix+key,
+				redis.Z{
+					Score:  score,
+					Member: stats.UserId,
+				},
+			)
+			errors.Add(result.Err())
+
+			result = state.Redis.ZAdd(
+				*state.RedisContext, prefix+key+":"+country,
+				redis.Z{
+					Score:  score,
+					Member: stats.UserId,
+				},
+			)
+			errors.Add(result.Err())
+		}
 	}
 
-	for _, key := range entries {
-		result := state.Redis.ZRem(
-			*state.RedisContext, key, stats.UserId,
-		)
-		errors.Add(result.Err())
+	return errors.Next()
+}
 
-		result = state.Redis.ZRem(
-			*state.RedisContext, key+":"+country, stats.UserId,
-		)
-		errors.Add(result.Err())
+// rankingsPrefixes returns the key prefixes of all rankings sets that
+// entries are written to, which includes the rebuilt sets during a rebuild
+func rankingsPrefixes(state *State) []string {
+	rebuilding, err := state.Redis.Exists(*state.RedisContext, RankingsRebuildKey).Result()
+	if err != nil || rebuilding == 0 {
+		return []string{""}
 	}
 
-	return errors.Next()
+	return []string{"", RankingsRebuildPrefix}
 }
 
-func GetScoreRank(userId int, state *State) (int, error) {
-	result := state.Redis.ZRevRank(
+// FetchRankingsEntries returns the current score of a user in each
+// global rankings set. Sets the user is missing from are left out.
+func FetchRankingsEntries(userId int, state *State) (map[string]float64, error) {
+	entries := make(map[string]float64, len(RankingsKeys))
+
+	for _, key := range RankingsKeys {
+		score, err := state.Redis.ZScore(
+			*state.RedisContext,
+			key, strconv.Itoa(userId),
+		).Result()
+
+		if err == redis.Nil {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		entries[key] = score
+	}
+
+	return entries, nil
+}
+
+// StartRankingsRebuild removes the leftovers of an aborted rebuild, and
+// marks a new one as running. Until it is finished, entries are written
+// into both the live & rebuilt rankings sets.
+func StartRankingsRebuild(state *State) error {
+	keys, err := scanKeys(RankingsRebuildPrefix+"rankings:*", state)
+	if err != nil {
+		return err
+	}
+
+	if len(keys) > 0 {
+		if err = state.Redis.Del(*state.RedisContext, keys...).Err(); err != nil {
+			return err
+		}
+	}
+
+	return state.Redis.Set(
 		*state.RedisContext,
-		"rankings:rscore", strconv.Itoa(userId),
-	)
-	return int(result.Val()) + 1, result.Err()
+		RankingsRebuildKey, 1,
+		RankingsRebuildTimeout,
+	).Err()
 }
 
-func GetCountryScoreRank(userId int, country string, state *State) (int, error) {
-	result := state.Redis.ZRevRank(
+// RefreshRankingsRebuild extends the expiry of the running rebuild,
+// and fails if it has already expired
+func RefreshRankingsRebuild(state *State) error {
+	refreshed, err := state.Redis.Expire(
 		*state.RedisContext,
-		"rankings:rscore:"+strings.ToLower(country), strconv.Itoa(userId),
-	)
-	return int(result.Val()) + 1, result.Err()
+		RankingsRebuildKey,
+		RankingsRebuildTimeout,
+	).Result()
+
+	if err != nil {
+		return err
+	}
+
+	if !refreshed {
+		return ErrRankingsRebuildExpired
+	}
+
+	return nil
+}
+
+// UpdateRebuiltRankingsEntry writes the entry of a user into the rebuilt
+// rankings sets only, while the live sets stay untouched
+func UpdateRebuiltRankingsEntry(stats *Stats, country string, state *State) error {
+	return updateRankingsEntry(stats, country, []string{RankingsRebuildPrefix}, state)
+}
+
+// FinishRankingsRebuild replaces all live rankings sets with the rebuilt
+// ones at once. Live sets without a rebuilt counterpart, e.g. of countries
+// without ranked users, are removed.
+func FinishRankingsRebuild(state *State) error {
+	liveKeys, err := scanKeys("rankings:*", state)
+	if err != nil {
+		return err
+	}
+
+	rebuiltKeys, err := scanKeys(RankingsRebuildPrefix+"rankings:*", state)
+	if err != nil {
+		return err
+	}
+
+	rebuilt := make(map[string]bool, len(rebuiltKeys))
+
+	for _, key := range rebuiltKeys {
+		rebuilt[strings.TrimPrefix(key, RankingsRebuildPrefix)] = true
+	}
+
+	// The swap is only applied if the marker still exists when it runs
+	return state.Redis.Watch(*state.RedisContext, func(tx *redis.Tx) error {
+		running, err := tx.Exists(*state.RedisContext, RankingsRebuildKey).Result()
+		if err != nil {
+			return err
+		}
+
+		if running == 0 {
+			return ErrRankingsRebuildExpired
+		}
+
+		_, err = tx.TxPipelined(*state.RedisContext, func(pipe redis.Pipeliner) error {
+			for _, key := range liveKeys {
+				if !rebuilt[key] {
+					pipe.Del(*state.RedisContext, key)
+				}
+			}
+
+			for _, key := range rebuiltKeys {
+				pipe.Rename(*state.RedisContext, key, strings.TrimPrefix(key, RankingsRebuildPrefix))
+			}
+
+			pipe.Del(*state.RedisContext, RankingsRebuildKey)
+			return nil
+		})
+
+		if err == redis.TxFailedErr {
+			// The marker expired while the swap was prepared
+			return ErrRankingsRebuildExpired
+		}
+
+		return err
+	}, RankingsRebuildKey)
+}
+
+// AbortRankingsRebuild removes the rebuilt rankings sets, and leaves
+// the live sets as they are
+func AbortRankingsRebuild(state *State) error {
+	keys, err := scanKeys(RankingsRebuildPrefix+"rankings:*", state)
+	if err != nil {
+		return err
+	}
+
+	keys = append(keys, RankingsRebuildKey)
+	return state.Redis.Del(*state.RedisContext, keys...).Err()
+}
+
+func scanKeys(pattern string, state *State) ([]string, error) {
+	keys := []string{}
+	cursor := uint64(0)
+
+	for {
+		batch, next, err := state.Redis.Scan(
+			*state.RedisContext,
+			cursor, pattern, 1000,
+		).Result()
+
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, batch...)
+
+		if next == 0 {
+			return keys, nil
+		}
+
+		cursor = next
+	}
+}
+
+// RankingsOutdated returns whether any of the global rankings sets
+// doesn't contain exactly the users that should be ranked, e.g.
+// after Redis was flushed or replaced, or whether the entries of
+// a sample of users don't match their stats anymore.
+func RankingsOutdated(state *State) (bool, error) {
+	rankedUsers, err := FetchRankedUserCount(state)
+	if err != nil {
+		return false, err
+	}
+
+	for _, key := range RankingsKeys {
+		count, err := state.Redis.ZCard(*state.RedisContext, key).Result()
+		if err != nil {
+			return false, err
+		}
+
+		if int(count) != rankedUsers {
+			return true, nil
+		}
+	}
+
+	sample, err := FetchRandomRankedUsers(RankingsSampleSize, state)
+	if err != nil {
+		return false, err
+	}
+
+	for _, user := range sample {
+		outdated, err := RankingsEntriesOutdated(&user.Stats, state)
+		if err != nil || outdated {
+			return outdated, err
+		}
+	}
+
+	return false, nil
+}
+
+// RankingsEntriesOutdated returns whether the global rankings
+// entries of a user are missing or differ from their stats
+func RankingsEntriesOutdated(stats *Stats, state *State) (bool, error) {
+	entries, err := FetchRankingsEntries(stats.UserId, state)
+	if err != nil {
+		return false, err
+	}
+
+	for key, score := range RankingsEntries(stats) {
+		if current, ok := entries[key]; !ok || current != score {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// RebuildRankings repopulates all global & country rankings sets
+// from the stats stored in the database. It returns the amount of
+// users that were ranked.
+func RebuildRankings(state *State) (int, error) {
+	if err := StartRankingsRebuild(state); err != nil {
+		return 0, err
+	}
+
+	ranked := 0
+
+	err := FetchUsersInBatches(500, state, func(users []*User) error {
+		for _, user := range users {
+			if user.Restricted || user.Stats.Clears() <= 0 {
+				continue
+			}
+
+			if err := UpdateRebuiltRankingsEntry(&user.Stats, user.Country, state); err != nil {
+				return err
+			}
+			ranked++
+		}
+
+		return RefreshRankingsRebuild(state)
+	}, "Stats")
+
+	if err != nil {
+		AbortRankingsRebuild(state)
+		return ranked, err
+	}
+
+	return ranked, FinishRankingsRebuild(state)
+}
+
+// EnsureRankings rebuilds the rankings sets, if they are outdated
+func EnsureRankings(state *State, logger *Logger) error {
+	outdated, err := RankingsOutdated(state)
+	if err != nil {
+		return err
+	}
+
+	if !outdated {
+		return nil
+	}
+
+	logger.Warning("Rankings are empty or outdated, rebuilding them...")
+
+	ranked, err := RebuildRankings(state)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Rebuilt rankings with %d users", ranked)
+	return nil
+}
+
+func GetScoreRank(userId int, state *State) (int, error) {
+	return fetchRank("rankings:rscore", userId, state)
+}
+
+func GetCountryScoreRank(userId int, country string, state *State) (int, error) {
+	return fetchRank("rankings:rscore:"+strings.ToLower(country), userId, state)
 }
 
 func GetTotalScoreRank(userId int, state *State) (int, error) {
-	result := state.Redis.ZRevRank(
-		*state.RedisContext,
-		"rankings:tscore", strconv.Itoa(userId),
-	)
-	return int(result.Val()) + 1, result.Err()
+	return fetchRank("rankings:tscore", userId, state)
 }
 
 func GetCountryTotalScoreRank(userId int, country string, state *State) (int, error) {
-	result := state.Redis.ZRevRank(
-		*state.RedisContext,
-		"rankings:tscore:"+strings.ToLower(country), strconv.Itoa(userId),
-	)
-	return int(result.Val()) + 1, result.Err()
+	return fetchRank("rankings:tscore:"+strings.ToLower(country), userId, state)
 }
 
 func GetClearsRank(userId int, state *State) (int, error) {
-	result := state.Redis.ZRevRank(
-		*state.RedisContext,
-		"rankings:clears", strconv.Itoa(userId),
-	)
-	return int(result.Val()) + 1, result.Err()
+	return fetchRank("rankings:clears", userId, state)
 }
 
 func GetCountryClearsRank(userId int, countryCode string, state *State) (int, error) {
-	result := state.Redis.ZRevRank(
+	return fetchRank("rankings:clears:"+strings.ToLower(countryCode), userId, state)
+}
+
+func GetPerformanceRank(userId int, state *State) (int, error) {
+	return fetchRank("rankings:pp", userId, state)
+}
+
+func GetCountryPerformanceRank(userId int, countryCode string, state *State) (int, error) {
+	return fetchRank("rankings:pp:"+strings.ToLower(countryCode), userId, state)
+}
+
+// fetchRank returns the 1-based rank of a user in a rankings set,
+// or RankUnranked if the user has no entry in it.
+func fetchRank(key string, userId int, state *State) (int, error) {
+	rank, err := state.Redis.ZRevRank(
 		*state.RedisContext,
-		"rankings:clears:"+strings.ToLower(countryCode), strconv.Itoa(userId),
-	)
-	return int(result.Val()) + 1, result.Err()
+		key, strconv.Itoa(userId),
+	).Result()
+
+	if err == redis.Nil {
+		return RankUnranked, nil
+	}
+
+	if err != nil {
+		return RankUnranked, err
+	}
+
+	return int(rank) + 1, nil
 }
@@ -0,0 +1,106 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestRankingsRebuild(t *testing.T) {
+	state := NewTestRedisState(t)
+
+	current := &Stats{UserId: 1, RankedScore: 100, XCount: 1}
+	removed := &Stats{UserId: 2, RankedScore: 200, XCount: 1}
+
+	UpdateRankingsEntry(current, "DE", state)
+	UpdateRankingsEntry(removed, "JP", state)
+
+	if err := StartRankingsRebuild(state); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := UpdateRebuiltRankingsEntry(current, "DE", state); err != nil {
+		t.Fatal(err)
+	}
+
+	// Players stay ranked while the rebuild is running
+	if rank, _ := GetScoreRank(removed.UserId, state); rank != 1 {
+		t.Fatalf("expected live rank 1 during rebuild, got %d", rank)
+	}
+
+	// Submissions during the rebuild are written into both sets
+	current.RankedScore = 300
+	UpdateRankingsEntry(current, "DE", state)
+
+	if err := FinishRankingsRebuild(state); err != nil {
+		t.Fatal(err)
+	}
+
+	if rank, _ := GetScoreRank(current.UserId, state); rank != 1 {
+		t.Fatalf("expected rank 1 after rebuild, got %d", rank)
+	}
+
+	if rank, _ := GetScoreRank(removed.UserId, state); rank != RankUnranked {
+		t.Fatalf("expected removed user to be unranked, got %d", rank)
+	}
+
+	if exists, _ := state.Redis.Exists(*state.RedisContext, "rankings:rscore:jp").Result(); exists != 0 {
+		t.Fatal("expected stale country rankings to be removed")
+	}
+
+	entries, _ := FetchRankingsEntries(current.UserId, state)
+	if entries["rankings:rscore"] != 300 {
+		t.Fatalf("expected submission during rebuild to be kept, got %v", entries["rankings:rscore"])
+	}
+}
+
+func TestRankingsRebuildExpired(t *testing.T) {
+	state := NewTestRedisState(t)
+	stats := &Stats{UserId: 1, RankedScore: 100, XCount: 1}
+
+	UpdateRankingsEntry(stats, "DE", state)
+
+	if err := StartRankingsRebuild(state); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RefreshRankingsRebuild(state); err != nil {
+		t.Fatalf("failed to refresh running rebuild: %s", err)
+	}
+
+	// Simulates the marker expiring in the middle of the rebuild
+	state.Redis.Del(*state.RedisContext, RankingsRebuildKey)
+
+	if err := RefreshRankingsRebuild(state); err != ErrRankingsRebuildExpired {
+		t.Fatalf("expected refresh to fail with %v, got %v", ErrRankingsRebuildExpired, err)
+	}
+
+	if err := FinishRankingsRebuild(state); err != ErrRankingsRebuildExpired {
+		t.Fatalf("expected swap to fail with %v, got %v", ErrRankingsRebuildExpired, err)
+	}
+
+	// Live sets must stay untouched by the failed swap
+	if rank, _ := GetScoreRank(stats.UserId, state); rank != 1 {
+		t.Fatalf("expected live rank 1 after failed swap, got %d", rank)
+	}
+}
+
+func TestRankingsEntriesOutdated(t *testing.T) {
+	state := NewTestRedisState(t)
+	stats := &Stats{UserId: 1, RankedScore: 100, TotalScore: 150, PP: 12.5, XCount: 1}
+
+	if outdated, err := RankingsEntriesOutdated(stats, state); err != nil || !outdated {
+		t.Fatalf("expected missing entries to be outdated, got %t (%v)", outdated, err)
+	}
+
+	UpdateRankingsEntry(stats, "DE", state)
+
+	if outdated, err := RankingsEntriesOutdated(stats, state); err != nil || outdated {
+		t.Fatalf("expected written entries to be current, got %t (%v)", outdated, err)
+	}
+
+	// e.g. after Redis was restored from an older snapshot
+	stats.PP = 20
+
+	if outdated, err := RankingsEntriesOutdated(stats, state); err != nil || !outdated {
+		t.Fatalf("expected changed stats to be outdated, got %t (%v)", outdated, err)
+	}
+}
@@ -10,6 +10,9 @@ import (
 	"time"
 )
 
+// Qt::TimeSpec value for UTC timestamps
+const ReplayTimeSpecUTC = 1
+
 type ReplayData struct {
 	Mode            int8
 	ReplayVersion   int
@@ -180,6 +183,11 @@ func (frame *ReplayFrame) String() string {
 	return FormatStruct(frame)
 }
 
+// SongTime returns the frame time in ms, which is negative during the lead-in
+func (frame *ReplayFrame) SongTime() int {
+	return int(int32(frame.Time))
+}
+
 func (frame *ReplayFrame) Serialize(stream *IOStream) {
 	stream.WriteU32(frame.Time)
 	stream.WriteF64(frame.MouseX)
@@ -283,3 +291,54 @@ func ReadFullReplay(stream *IOStream) (*ReplayData, error) {
 	replayData.Frames, err = ReadReplayFrames(NewIOStream(replayBytes, binary.BigEndian))
 	return replayData, err
 }
+
+// NewReplayFromScore assembles a complete replay, using the score and
+// its preloaded beatmap & user to populate the header.
+func NewReplayFromScore(score *Score, frames []*ReplayFrame) *ReplayData {
+	replay := &ReplayData{
+		Mode:            0,
+		ReplayVersion:   score.ClientVersion,
+		BeatmapChecksum: score.Beatmap.Checksum,
+		PlayerName:      score.User.Name,
+		Count300:        uint32(score.Count300),
+		Count100:        uint32(score.Count100),
+		Count50:         uint32(score.Count50),
+		CountGeki:       uint32(score.CountGeki),
+		CountGood:       uint32(score.CountGood),
+		CountMiss:       uint32(score.CountMiss),
+		TotalScore:      float64(score.TotalScore),
+		MaxCombo:        uint32(score.MaxCombo),
+		FullCombo:       score.FullCombo,
+		Time:            score.CreatedAt.UTC(),
+		TimeSpec:        ReplayTimeSpecUTC,
+		Frames:          frames,
+		Mods: &ReplayMods{
+			ArOffset: score.AROffset,
+			OdOffset: score.ODOffset,
+			CsOffset: score.CSOffset,
+			HpOffset: score.HPOffset,
+			PsOffset: score.PSOffset,
+			Hidden:   score.ModHidden,
+			NoFail:   score.ModNoFail,
+		},
+	}
+
+	replay.ScoreChecksum = replay.Checksum()
+	return replay
+}
+
+// LoadScoreReplay reads the stored replay frames of a score
+// and returns them as a complete replay.
+func LoadScoreReplay(score *Score, storage Storage) (*ReplayData, error) {
+	file, err := storage.GetReplayFile(score.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	storedReplay, err := ReadFullReplay(NewIOStream(file, binary.BigEndian))
+	if err != nil {
+		return nil, err
+	}
+
+	return NewReplayFromScore(score, storedReplay.Frames), nil
+}
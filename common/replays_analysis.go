@@ -0,0 +1,205 @@
+package common
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	// Replays with fewer frames than this are not analyzed
+	AnalysisMinimumFrames = 100
+
+	// Frame deltas above this (in ms) are treated as pauses/breaks
+	AnalysisMaximumFrameDelta = 100
+
+	// Real clients produce some jitter in their frame timing,
+	// a standard deviation below this (in ms) is suspicious.
+	AnalysisMinimumFrameDeviation = 0.1
+
+	// The client records frames at ~60fps in real time, so the median
+	// frame delta in song time should scale with the playback rate.
+	AnalysisExpectedFrameInterval = 1000.0 / 60.0
+	AnalysisTimewarpTolerance     = 0.85
+
+	// A snap is a jump of at least this distance within a single frame,
+	// after which the cursor stays (almost) completely still.
+	AnalysisSnapDistance  = 150.0
+	AnalysisSnapStillness = 1.0
+	AnalysisSnapThreshold = 10
+
+	// Tap durations with a standard deviation below this (in ms)
+	// over enough taps are considered machine-generated.
+	AnalysisMinimumTaps          = 50
+	AnalysisMinimumTapDeviation  = 1.0
+	AnalysisButtonStateBitLength = 32
+)
+
+// PlaybackRate converts a play speed offset into a speed multiplier
+func PlaybackRate(psOffset int) float64 {
+	return 1 + float64(psOffset)/100
+}
+
+// AnalyzeReplayFrames inspects the frames of a replay for suspicious
+// patterns and returns a flag for each one found. The returned flags
+// are not yet associated with a score.
+func AnalyzeReplayFrames(frames []*ReplayFrame, playbackRate float64) []*ScoreFlag {
+	flags := []*ScoreFlag{}
+
+	if len(frames) < AnalysisMinimumFrames {
+		return flags
+	}
+
+	deltas := frameDeltas(frames)
+
+	if len(deltas) < AnalysisMinimumFrames {
+		return flags
+	}
+
+	if deviation := standardDeviation(deltas); deviation < AnalysisMinimumFrameDeviation {
+		flags = append(flags, &ScoreFlag{
+			Type:    ScoreFlagFrameTiming,
+			Details: fmt.Sprintf("frame delta deviation of %.4fms over %d frames", deviation, len(deltas)),
+		})
+	}
+
+	expectedInterval := AnalysisExpectedFrameInterval * playbackRate
+	medianInterval := median(deltas)
+
+	if medianInterval < expectedInterval*AnalysisTimewarpTolerance {
+		flags = append(flags, &ScoreFlag{
+			Type: ScoreFlagTimewarp,
+			Details: fmt.Sprintf(
+				"median frame delta of %.2fms, expected %.2fms at %.2fx speed",
+				medianInterval, expectedInterval, playbackRate,
+			),
+		})
+	}
+
+	if snaps := countCursorSnaps(frames); snaps >= AnalysisSnapThreshold {
+		flags = append(flags, &ScoreFlag{
+			Type:    ScoreFlagCursorSnap,
+			Details: fmt.Sprintf("%d cursor snaps", snaps),
+		})
+	}
+
+	taps := tapDurations(frames)
+
+	if len(taps) >= AnalysisMinimumTaps {
+		if deviation := standardDeviation(taps); deviation < AnalysisMinimumTapDeviation {
+			flags = append(flags, &ScoreFlag{
+				Type:    ScoreFlagTapConsistency,
+				Details: fmt.Sprintf("tap duration deviation of %.4fms over %d taps", deviation, len(taps)),
+			})
+		}
+	}
+
+	return flags
+}
+
+// frameDeltas returns the time between consecutive frames,
+// skipping pauses and frames that go back in time.
+func frameDeltas(frames []*ReplayFrame) []float64 {
+	deltas := make([]float64, 0, len(frames))
+
+	for i := 1; i < len(frames); i++ {
+		delta := frames[i].SongTime() - frames[i-1].SongTime()
+
+		if delta <= 0 || delta > AnalysisMaximumFrameDelta {
+			continue
+		}
+
+		deltas = append(deltas, float64(delta))
+	}
+
+	return deltas
+}
+
+func countCursorSnaps(frames []*ReplayFrame) int {
+	snaps := 0
+
+	for i := 2; i < len(frames); i++ {
+		previous, current, next := frames[i-2], frames[i-1], frames[i]
+
+		jump := math.Hypot(current.MouseX-previous.MouseX, current.MouseY-previous.MouseY)
+		if jump < AnalysisSnapDistance {
+			continue
+		}
+
+		movement := math.Hypot(next.MouseX-current.MouseX, next.MouseY-current.MouseY)
+		if movement > AnalysisSnapStillness {
+			continue
+		}
+
+		snaps++
+	}
+
+	return snaps
+}
+
+// tapDurations returns how long every key/button press was held
+func tapDurations(frames []*ReplayFrame) []float64 {
+	durations := []float64{}
+	pressedAt := make(map[int]int, AnalysisButtonStateBitLength)
+
+	for _, frame := range frames {
+		for bit := 0; bit < AnalysisButtonStateBitLength; bit++ {
+			pressed := frame.ButtonState&(1<<bit) != 0
+			start, wasPressed := pressedAt[bit]
+
+			if pressed && !wasPressed {
+				pressedAt[bit] = frame.SongTime()
+				continue
+			}
+
+			if pressed || !wasPressed {
+				continue
+			}
+
+			delete(pressedAt, bit)
+
+			if frame.SongTime() > start {
+				durations = append(durations, float64(frame.SongTime()-start))
+			}
+		}
+	}
+
+	return durations
+}
+
+func standardDeviation(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	mean := 0.0
+	for _, value := range values {
+		mean += value
+	}
+	mean /= float64(len(values))
+
+	variance := 0.0
+	for _, value := range values {
+		variance += (value - mean) * (value - mean)
+	}
+
+	return math.Sqrt(variance / float64(len(values)))
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	middle := len(sorted) / 2
+
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+
+	return sorted[middle]
+}
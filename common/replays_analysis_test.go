@@ -0,0 +1,70 @@
+package common
+
+import "testing"
+
+func hasScoreFlag(flags []*ScoreFlag, flagType ScoreFlagType) bool {
+	for _, flag := range flags {
+		if flag.Type == flagType {
+			return true
+		}
+	}
+	return false
+}
+
+func TestReplayAnalysisLegitimate(t *testing.T) {
+	replay := readTestReplay(t)
+	flags := AnalyzeReplayFrames(replay.Frames, PlaybackRate(replay.Mods.PsOffset))
+
+	for _, flag := range flags {
+		t.Errorf("unexpected flag on legitimate replay: %s (%s)", flag.Type, flag.Details)
+	}
+}
+
+func TestReplayAnalysisTimewarp(t *testing.T) {
+	replay := readTestReplay(t)
+
+	// Claiming a faster play speed than the frames were recorded at
+	flags := AnalyzeReplayFrames(replay.Frames, PlaybackRate(50))
+
+	if !hasScoreFlag(flags, ScoreFlagTimewarp) {
+		t.Fatal("expected timewarp flag")
+	}
+}
+
+func TestReplayAnalysisGenerated(t *testing.T) {
+	frames := []*ReplayFrame{}
+
+	for i := 0; i < 1000; i++ {
+		frame := &ReplayFrame{Time: uint32(i * 16)}
+
+		// Jump across the playfield every 10 frames & hold still
+		if (i/10)%2 == 0 {
+			frame.MouseX, frame.MouseY = 100, 100
+		} else {
+			frame.MouseX, frame.MouseY = 400, 300
+		}
+
+		// Press for exactly 3 frames at the start of every group
+		if i%10 < 3 {
+			frame.ButtonState = 1
+		}
+
+		frames = append(frames, frame)
+	}
+
+	flags := AnalyzeReplayFrames(frames, 1)
+
+	for _, flagType := range []ScoreFlagType{
+		ScoreFlagFrameTiming,
+		ScoreFlagCursorSnap,
+		ScoreFlagTapConsistency,
+	} {
+		if !hasScoreFlag(flags, flagType) {
+			t.Errorf("expected %s flag", flagType)
+		}
+	}
+
+	if hasScoreFlag(flags, ScoreFlagTimewarp) {
+		t.Error("unexpected timewarp flag")
+	}
+}
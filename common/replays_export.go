@@ -0,0 +1,246 @@
+package common
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ReplayExportVersion is bumped whenever the exported layout changes
+const ReplayExportVersion = 1
+
+// The frames csv starts with this header, followed by one row per
+// frame with the same fields & units as ReplayFrameExport
+var replayFramesCSVHeader = []string{"time", "x", "y", "buttons"}
+
+// ReplayExport is the open interchange representation of a replay.
+// Every field maps 1:1 onto ReplayData, so an exported replay can be
+// imported again without losing any information. Counts & combos are
+// plain integers and the mod offsets are kept as sent by the client.
+type ReplayExport struct {
+	// Version of the export layout, see ReplayExportVersion
+	Version int `json:"version"`
+
+	// Mode & version of the client that recorded the replay
+	Mode          int8 `json:"mode"`
+	ReplayVersion int  `json:"replay_version"`
+
+	// MD5 checksum of the beatmap file
+	BeatmapChecksum string `json:"beatmap_checksum"`
+	PlayerName      string `json:"player_name"`
+
+	// Replay checksum, see ReplayData.Checksum
+	ScoreChecksum string `json:"score_checksum"`
+
+	Count300   uint32  `json:"count_300"`
+	Count100   uint32  `json:"count_100"`
+	Count50    uint32  `json:"count_50"`
+	CountGeki  uint32  `json:"count_geki"`
+	CountGood  uint32  `json:"count_good"`
+	CountMiss  uint32  `json:"count_miss"`
+	TotalScore float64 `json:"total_score"`
+	MaxCombo   uint32  `json:"max_combo"`
+	FullCombo  bool    `json:"full_combo"`
+
+	// Time the replay was recorded at (RFC 3339) and its Qt::TimeSpec
+	Time     time.Time `json:"time"`
+	TimeSpec int8      `json:"time_spec"`
+
+	Mods   ReplayModsExport    `json:"mods"`
+	Frames []ReplayFrameExport `json:"frames"`
+}
+
+type ReplayModsExport struct {
+	ArOffset int  `json:"ar_offset"`
+	OdOffset int  `json:"od_offset"`
+	CsOffset int  `json:"cs_offset"`
+	HpOffset int  `json:"hp_offset"`
+	PsOffset int  `json:"ps_offset"`
+	Hidden   bool `json:"hidden"`
+	NoFail   bool `json:"nofail"`
+	Autoplay bool `json:"autoplay"`
+}
+
+// ReplayFrameExport is a single cursor frame:
+//   - time: milliseconds since the beatmap start, as an unsigned 32-bit
+//     integer that wraps around for frames during the lead-in
+//   - x, y: cursor position in playfield coordinates, the same space
+//     as the hit object positions of the beatmap
+//   - buttons: bitmask of the pressed keys & mouse buttons, where a
+//     single key can set multiple bits
+type ReplayFrameExport struct {
+	Time        uint32  `json:"time"`
+	MouseX      float64 `json:"x"`
+	MouseY      float64 `json:"y"`
+	ButtonState uint32  `json:"buttons"`
+}
+
+func NewReplayExport(replay *ReplayData) *ReplayExport {
+	mods := replay.Mods
+	if mods == nil {
+		mods = &ReplayMods{}
+	}
+
+	frames := make([]ReplayFrameExport, 0, len(replay.Frames))
+
+	for _, frame := range replay.Frames {
+		frames = append(frames, ReplayFrameExport{
+			Time:        frame.Time,
+			MouseX:      frame.MouseX,
+			MouseY:      frame.MouseY,
+			ButtonState: frame.ButtonState,
+		})
+	}
+
+	return &ReplayExport{
+		Version:         ReplayExportVersion,
+		Mode:            replay.Mode,
+		ReplayVersion:   replay.ReplayVersion,
+		BeatmapChecksum: replay.BeatmapChecksum,
+		PlayerName:      replay.PlayerName,
+		ScoreChecksum:   replay.ScoreChecksum,
+		Count300:        replay.Count300,
+		Count100:        replay.Count100,
+		Count50:         replay.Count50,
+		CountGeki:       replay.CountGeki,
+		CountGood:       replay.CountGood,
+		CountMiss:       replay.CountMiss,
+		TotalScore:      replay.TotalScore,
+		MaxCombo:        replay.MaxCombo,
+		FullCombo:       replay.FullCombo,
+		Time:            replay.Time,
+		TimeSpec:        replay.TimeSpec,
+		Mods:            ReplayModsExport(*mods),
+		Frames:          frames,
+	}
+}
+
+func (export *ReplayExport) Replay() *ReplayData {
+	mods := ReplayMods(export.Mods)
+	frames := make([]*ReplayFrame, 0, len(export.Frames))
+
+	for _, frame := range export.Frames {
+		frames = append(frames, &ReplayFrame{
+			Time:        frame.Time,
+			MouseX:      frame.MouseX,
+			MouseY:      frame.MouseY,
+			ButtonState: frame.ButtonState,
+		})
+	}
+
+	return &ReplayData{
+		Mode:            export.Mode,
+		ReplayVersion:   export.ReplayVersion,
+		BeatmapChecksum: export.BeatmapChecksum,
+		PlayerName:      export.PlayerName,
+		ScoreChecksum:   export.ScoreChecksum,
+		Count300:        export.Count300,
+		Count100:        export.Count100,
+		Count50:         export.Count50,
+		CountGeki:       export.CountGeki,
+		CountGood:       export.CountGood,
+		CountMiss:       export.CountMiss,
+		TotalScore:      export.TotalScore,
+		MaxCombo:        export.MaxCombo,
+		FullCombo:       export.FullCombo,
+		Time:            export.Time.UTC(),
+		TimeSpec:        export.TimeSpec,
+		Frames:          frames,
+		Mods:            &mods,
+	}
+}
+
+// ExportJSON converts the replay into its JSON interchange representation
+func (replay *ReplayData) ExportJSON() ([]byte, error) {
+	return json.MarshalIndent(NewReplayExport(replay), "", "  ")
+}
+
+// ImportReplayJSON parses a replay from its JSON interchange representation
+func ImportReplayJSON(data []byte) (*ReplayData, error) {
+	export := &ReplayExport{}
+
+	if err := json.Unmarshal(data, export); err != nil {
+		return nil, err
+	}
+
+	if export.Version != ReplayExportVersion {
+		return nil, fmt.Errorf("unsupported replay export version '%d'", export.Version)
+	}
+
+	return export.Replay(), nil
+}
+
+// ExportFramesCSV writes the replay frames as CSV, with one frame per row
+func (replay *ReplayData) ExportFramesCSV() ([]byte, error) {
+	buffer := bytes.Buffer{}
+	writer := csv.NewWriter(&buffer)
+
+	if err := writer.Write(replayFramesCSVHeader); err != nil {
+		return nil, err
+	}
+
+	for _, frame := range replay.Frames {
+		err := writer.Write([]string{
+			strconv.FormatUint(uint64(frame.Time), 10),
+			strconv.FormatFloat(frame.MouseX, 'g', -1, 64),
+			strconv.FormatFloat(frame.MouseY, 'g', -1, 64),
+			strconv.FormatUint(uint64(frame.ButtonState), 10),
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	writer.Flush()
+	return buffer.Bytes(), writer.Error()
+}
+
+// ImportReplayFramesCSV parses replay frames written by ExportFramesCSV
+func ImportReplayFramesCSV(data []byte) ([]*ReplayFrame, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = len(replayFramesCSVHeader)
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, fmt.Errorf("missing csv header")
+	}
+
+	frames := make([]*ReplayFrame, 0, len(records)-1)
+
+	for _, record := range records[1:] {
+		collection := NewErrorCollection()
+
+		time, err := strconv.ParseUint(record[0], 10, 32)
+		collection.Add(err)
+
+		mouseX, err := strconv.ParseFloat(record[1], 64)
+		collection.Add(err)
+
+		mouseY, err := strconv.ParseFloat(record[2], 64)
+		collection.Add(err)
+
+		buttonState, err := strconv.ParseUint(record[3], 10, 32)
+		collection.Add(err)
+
+		if collection.HasErrors() {
+			return nil, collection.Pop(0)
+		}
+
+		frames = append(frames, &ReplayFrame{
+			Time:        uint32(time),
+			MouseX:      mouseX,
+			MouseY:      mouseY,
+			ButtonState: uint32(buttonState),
+		})
+	}
+
+	return frames, nil
+}
@@ -0,0 +1,87 @@
+package common
+
+import (
+	"encoding/binary"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func readTestReplay(t *testing.T) *ReplayData {
+	replayData, err := os.ReadFile("./replays_test.hxrp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replay, err := ReadFullReplay(NewIOStream(replayData, binary.BigEndian))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return replay
+}
+
+func TestReplayExportJSON(t *testing.T) {
+	replay := readTestReplay(t)
+
+	exported, err := replay.ExportJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imported, err := ImportReplayJSON(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(imported, replay) {
+		t.Fatal("imported replay does not match original")
+	}
+
+	// Imported replay must still be readable by the client format
+	stream := NewIOStream([]byte{}, binary.BigEndian)
+	imported.Serialize(stream)
+	stream.Seek(0)
+
+	replaySerialized, err := ReadFullReplay(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(replaySerialized, replay) {
+		t.Fatal("re-serialized replay does not match original")
+	}
+
+	if replaySerialized.Checksum() != replay.ScoreChecksum {
+		t.Fatalf(
+			"re-serialized replay checksum mismatch: got %s want %s",
+			replaySerialized.Checksum(),
+			replay.ScoreChecksum,
+		)
+	}
+}
+
+func TestReplayExportFramesCSV(t *testing.T) {
+	replay := readTestReplay(t)
+
+	exported, err := replay.ExportFramesCSV()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frames, err := ImportReplayFramesCSV(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(frames, replay.Frames) {
+		t.Fatal("imported frames do not match original")
+	}
+}
+
+func TestReplayImportVersion(t *testing.T) {
+	_, err := ImportReplayJSON([]byte(`{"version": 0}`))
+	if err == nil {
+		t.Fatal("expected error for unsupported export version")
+	}
+}
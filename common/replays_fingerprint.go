@@ -0,0 +1,225 @@
+package common
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+const (
+	// Cursor positions are sampled every interval (in ms of song time),
+	// up to a maximum amount of samples per fingerprint.
+	FingerprintInterval   = 100
+	FingerprintMaxSamples = 8192
+
+	// Positions are stored in half-pixel precision
+	FingerprintPrecision = 2
+
+	// Fingerprints are compared with a small time shift in
+	// both directions, to account for differing frame timings.
+	FingerprintMaxLag = 2
+
+	// Minimum amount of overlapping samples needed for a comparison
+	FingerprintMinOverlap = 50
+
+	// Replays with a mean cursor distance below this (in pixels)
+	// are considered to be copies of each other.
+	FingerprintSimilarityThreshold = 2.5
+
+	// Copies have a similar mean cursor position, so only fingerprints
+	// whose centroids lie within this distance (in pixels) are compared.
+	// It is larger than the similarity threshold, since the compared
+	// time range of two fingerprints can differ slightly.
+	FingerprintCentroidTolerance = 10
+
+	// Maximum amount of fingerprints compared per submission
+	FingerprintMaxCandidates = 100
+)
+
+// ReplayFingerprint is a compact representation of a replay's cursor path.
+// Samples are aligned to a fixed song time grid, so that fingerprints of
+// the same beatmap can be compared directly.
+type ReplayFingerprint struct {
+	// Index of the first sample on the time grid
+	Start   int
+	Samples [][2]int16
+}
+
+func NewReplayFingerprint(frames []*ReplayFrame) *ReplayFingerprint {
+	fingerprint := &ReplayFingerprint{Samples: [][2]int16{}}
+	frames = monotonicFrames(frames)
+
+	if len(frames) < 2 {
+		return fingerprint
+	}
+
+	firstTime := float64(frames[0].SongTime())
+	lastTime := float64(frames[len(frames)-1].SongTime())
+	fingerprint.Start = int(math.Ceil(firstTime / FingerprintInterval))
+
+	frameIndex := 0
+
+	for sample := fingerprint.Start; len(fingerprint.Samples) < FingerprintMaxSamples; sample++ {
+		time := float64(sample * FingerprintInterval)
+
+		if time > lastTime {
+			break
+		}
+
+		for frameIndex < len(frames)-2 && float64(frames[frameIndex+1].SongTime()) < time {
+			frameIndex++
+		}
+
+		x, y := interpolateCursor(frames[frameIndex], frames[frameIndex+1], time)
+		fingerprint.Samples = append(fingerprint.Samples, [2]int16{
+			quantizePosition(x),
+			quantizePosition(y),
+		})
+	}
+
+	return fingerprint
+}
+
+// MeanDistance returns the mean cursor distance (in pixels) between two
+// fingerprints over their overlapping time range, using the best of a
+// few small time shifts. It returns false if they barely overlap.
+func (fingerprint *ReplayFingerprint) MeanDistance(other *ReplayFingerprint) (float64, bool) {
+	bestDistance := math.Inf(1)
+
+	for lag := -FingerprintMaxLag; lag <= FingerprintMaxLag; lag++ {
+		distance, ok := fingerprint.meanDistanceAt(other, lag)
+
+		if ok {
+			bestDistance = math.Min(bestDistance, distance)
+		}
+	}
+
+	if math.IsInf(bestDistance, 1) {
+		return 0, false
+	}
+
+	return bestDistance, true
+}
+
+// Centroid returns the mean cursor position of the fingerprint in pixels
+func (fingerprint *ReplayFingerprint) Centroid() (float64, float64) {
+	if len(fingerprint.Samples) == 0 {
+		return 0, 0
+	}
+
+	sumX, sumY := 0.0, 0.0
+
+	for _, sample := range fingerprint.Samples {
+		sumX += float64(sample[0])
+		sumY += float64(sample[1])
+	}
+
+	amount := float64(len(fingerprint.Samples)) * FingerprintPrecision
+	return sumX / amount, sumY / amount
+}
+
+// IsSimilar checks if two fingerprints are near-duplicates
+func (fingerprint *ReplayFingerprint) IsSimilar(other *ReplayFingerprint) bool {
+	distance, ok := fingerprint.MeanDistance(other)
+	return ok && distance < FingerprintSimilarityThreshold
+}
+
+func (fingerprint *ReplayFingerprint) meanDistanceAt(other *ReplayFingerprint, lag int) (float64, bool) {
+	start := max(fingerprint.Start, other.Start+lag)
+	end := min(
+		fingerprint.Start+len(fingerprint.Samples),
+		other.Start+lag+len(other.Samples),
+	)
+
+	if end-start < FingerprintMinOverlap {
+		return 0, false
+	}
+
+	total := 0.0
+
+	for sample := start; sample < end; sample++ {
+		a := fingerprint.Samples[sample-fingerprint.Start]
+		b := other.Samples[sample-other.Start-lag]
+
+		total += math.Hypot(
+			float64(a[0])-float64(b[0]),
+			float64(a[1])-float64(b[1]),
+		)
+	}
+
+	return total / float64(end-start) / FingerprintPrecision, true
+}
+
+func (fingerprint *ReplayFingerprint) Serialize() []byte {
+	stream := NewIOStream([]byte{}, binary.BigEndian)
+	stream.WriteI32(int32(fingerprint.Start))
+	stream.WriteU32(uint32(len(fingerprint.Samples)))
+
+	for _, sample := range fingerprint.Samples {
+		stream.WriteI16(sample[0])
+		stream.WriteI16(sample[1])
+	}
+
+	return stream.Get()
+}
+
+func ReadReplayFingerprint(data []byte) (fingerprint *ReplayFingerprint, err error) {
+	defer HandlePanic(&err)
+
+	stream := NewIOStream(data, binary.BigEndian)
+
+	if stream.Available() < 8 {
+		return nil, fmt.Errorf("fingerprint is too short")
+	}
+
+	start := int(stream.ReadI32())
+	amount := int(stream.ReadU32())
+
+	if stream.Available() != amount*4 {
+		return nil, fmt.Errorf("invalid fingerprint size for %d samples", amount)
+	}
+
+	fingerprint = &ReplayFingerprint{
+		Start:   start,
+		Samples: make([][2]int16, amount),
+	}
+
+	for i := range amount {
+		fingerprint.Samples[i] = [2]int16{stream.ReadI16(), stream.ReadI16()}
+	}
+
+	return fingerprint, nil
+}
+
+// monotonicFrames drops all frames that go back in time
+func monotonicFrames(frames []*ReplayFrame) []*ReplayFrame {
+	result := make([]*ReplayFrame, 0, len(frames))
+
+	for _, frame := range frames {
+		if len(result) > 0 && frame.SongTime() < result[len(result)-1].SongTime() {
+			continue
+		}
+
+		result = append(result, frame)
+	}
+
+	return result
+}
+
+func interpolateCursor(a *ReplayFrame, b *ReplayFrame, time float64) (float64, float64) {
+	duration := float64(b.SongTime() - a.SongTime())
+
+	if duration <= 0 {
+		return b.MouseX, b.MouseY
+	}
+
+	progress := math.Max(0, math.Min(1, (time-float64(a.SongTime()))/duration))
+
+	return a.MouseX + (b.MouseX-a.MouseX)*progress,
+		a.MouseY + (b.MouseY-a.MouseY)*progress
+}
+
+func quantizePosition(value float64) int16 {
+	value = math.Round(value * FingerprintPrecision)
+	return int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, value)))
+}
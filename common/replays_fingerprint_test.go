@@ -0,0 +1,98 @@
+package common
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func copyFrames(frames []*ReplayFrame, modify func(frame *ReplayFrame)) []*ReplayFrame {
+	result := make([]*ReplayFrame, 0, len(frames))
+
+	for _, frame := range frames {
+		copied := *frame
+		modify(&copied)
+		result = append(result, &copied)
+	}
+
+	return result
+}
+
+func TestReplayFingerprintSerialization(t *testing.T) {
+	replay := readTestReplay(t)
+	fingerprint := NewReplayFingerprint(replay.Frames)
+
+	if len(fingerprint.Samples) == 0 {
+		t.Fatal("fingerprint has no samples")
+	}
+
+	deserialized, err := ReadReplayFingerprint(fingerprint.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(deserialized, fingerprint) {
+		t.Fatal("deserialized fingerprint does not match original")
+	}
+
+	if _, err := ReadReplayFingerprint([]byte{0, 0}); err == nil {
+		t.Fatal("expected error for truncated fingerprint")
+	}
+}
+
+func TestReplayFingerprintSimilarity(t *testing.T) {
+	replay := readTestReplay(t)
+	original := NewReplayFingerprint(replay.Frames)
+	random := rand.New(rand.NewSource(1))
+
+	// Same cursor path with slight jitter & timing changes
+	tweaked := NewReplayFingerprint(copyFrames(replay.Frames, func(frame *ReplayFrame) {
+		frame.MouseX += random.Float64() - 0.5
+		frame.MouseY += random.Float64() - 0.5
+		frame.Time += 3
+	}))
+
+	if !original.IsSimilar(tweaked) {
+		t.Fatal("expected tweaked replay to be similar")
+	}
+
+	// Same cursor path, played at a different point in time
+	shifted := NewReplayFingerprint(copyFrames(replay.Frames, func(frame *ReplayFrame) {
+		frame.Time += 1500
+	}))
+
+	if original.IsSimilar(shifted) {
+		t.Fatal("expected shifted replay to not be similar")
+	}
+
+	// Same timing, but a different cursor path
+	mirrored := NewReplayFingerprint(copyFrames(replay.Frames, func(frame *ReplayFrame) {
+		frame.MouseX = 640 - frame.MouseX
+	}))
+
+	if original.IsSimilar(mirrored) {
+		t.Fatal("expected mirrored replay to not be similar")
+	}
+}
+
+func TestReplayFingerprintCentroid(t *testing.T) {
+	replay := readTestReplay(t)
+	original := NewReplayFingerprint(replay.Frames)
+	originalX, originalY := original.Centroid()
+
+	// Copies must pass the centroid prefilter, before being compared
+	tweaked := NewReplayFingerprint(copyFrames(replay.Frames, func(frame *ReplayFrame) {
+		frame.MouseX += 1.5
+		frame.Time += 40
+	}))
+	tweakedX, tweakedY := tweaked.Centroid()
+
+	if math.Abs(originalX-tweakedX) > FingerprintCentroidTolerance || math.Abs(originalY-tweakedY) > FingerprintCentroidTolerance {
+		t.Fatalf("expected close centroids, got (%.1f, %.1f) and (%.1f, %.1f)", originalX, originalY, tweakedX, tweakedY)
+	}
+
+	if x, y := (&ReplayFingerprint{}).Centroid(); x != 0 || y != 0 {
+		t.Fatal("expected empty fingerprint to have a zero centroid")
+	}
+}
@@ -0,0 +1,316 @@
+package common
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	BeatmapSearchDefaultLimit = 50
+	BeatmapSearchMaxLimit     = 100
+)
+
+var (
+	ErrInvalidSearchSort   = errors.New("invalid search sort")
+	ErrInvalidSearchCursor = errors.New("invalid search cursor")
+)
+
+type BeatmapSearchSort string
+
+const (
+	BeatmapSortRelevance BeatmapSearchSort = "relevance"
+	BeatmapSortCreated   BeatmapSearchSort = "created"
+	BeatmapSortUpdated   BeatmapSearchSort = "updated"
+	BeatmapSortDownloads BeatmapSearchSort = "downloads"
+	BeatmapSortTitle     BeatmapSearchSort = "title"
+	BeatmapSortArtist    BeatmapSearchSort = "artist"
+)
+
+// The expression every sort orders by, and the type its cursor value
+// is cast back to when comparing. Relevance cursors hold the rank itself.
+var beatmapSearchSorts = map[BeatmapSearchSort][2]string{
+	BeatmapSortRelevance: {"ts_rank(beatmapsets.search_vector, websearch_to_tsquery('simple', ?))", ""},
+	BeatmapSortCreated:   {"beatmapsets.created_at", "timestamptz"},
+	BeatmapSortUpdated:   {"beatmapsets.last_updated", "timestamptz"},
+	BeatmapSortDownloads: {"beatmapsets.downloads", "bigint"},
+	BeatmapSortTitle:     {"LOWER(beatmapsets.title)", "text"},
+	BeatmapSortArtist:    {"LOWER(beatmapsets.artist)", "text"},
+}
+
+// SearchRange is an optional, inclusive range filter
+type SearchRange struct {
+	Min *float64
+	Max *float64
+}
+
+func (r SearchRange) apply(query *gorm.DB, column string) *gorm.DB {
+	if r.Min != nil {
+		query = query.Where(column+" >= ?", *r.Min)
+	}
+	if r.Max != nil {
+		query = query.Where(column+" <= ?", *r.Max)
+	}
+	return query
+}
+
+func (r SearchRange) empty() bool {
+	return r.Min == nil && r.Max == nil
+}
+
+// BeatmapSearch describes a beatmapset search. Difficulty filters match
+// a beatmapset, if any of its beatmaps lies within all given ranges.
+type BeatmapSearch struct {
+	Query      string
+	Status     *BeatmapStatus
+	Creator    string
+	SR         SearchRange
+	Bpm        SearchRange
+	Length     SearchRange
+	CS         SearchRange
+	AR         SearchRange
+	OD         SearchRange
+	Sort       BeatmapSearchSort
+	Descending bool
+	Cursor     string
+	Limit      int
+}
+
+// BeatmapSearchCursor points behind the last result of a search page
+type BeatmapSearchCursor struct {
+	Sort       BeatmapSearchSort `json:"s"`
+	Descending bool              `json:"d"`
+	Value      string            `json:"v,omitempty"`
+	Rank       float64           `json:"r,omitempty"`
+	Id         int               `json:"i"`
+}
+
+func (cursor *BeatmapSearchCursor) Encode() string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeBeatmapSearchCursor(value string) (*BeatmapSearchCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidSearchCursor
+	}
+
+	cursor := &BeatmapSearchCursor{}
+	if err = json.Unmarshal(data, cursor); err != nil {
+		return nil, ErrInvalidSearchCursor
+	}
+
+	return cursor, nil
+}
+
+// Normalize applies the default sort & limit, and validates the search
+func (search *BeatmapSearch) Normalize() error {
+	search.Query = strings.TrimSpace(search.Query)
+
+	if search.Sort == "" {
+		search.Sort = BeatmapSortUpdated
+		search.Descending = true
+
+		if search.Query != "" {
+			search.Sort = BeatmapSortRelevance
+		}
+	}
+
+	if _, ok := beatmapSearchSorts[search.Sort]; !ok {
+		return ErrInvalidSearchSort
+	}
+
+	if search.Sort == BeatmapSortRelevance && search.Query == "" {
+		return ErrInvalidSearchSort
+	}
+
+	if search.Limit <= 0 {
+		search.Limit = BeatmapSearchDefaultLimit
+	}
+
+	if search.Limit > BeatmapSearchMaxLimit {
+		search.Limit = BeatmapSearchMaxLimit
+	}
+
+	return nil
+}
+
+// SearchBeatmapsets returns a page of beatmapsets matching the search,
+// together with the cursor to the next page, which is empty on the last one
+func SearchBeatmapsets(search *BeatmapSearch, state *State, preload ...string) ([]*Beatmapset, string, error) {
+	query, err := search.query(state.Database)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Fetch one more result to know if there is a next page
+	results := []struct {
+		Id        int
+		SortValue string
+		SortRank  float64
+	}{}
+
+	result := query.Limit(search.Limit + 1).Scan(&results)
+	if result.Error != nil {
+		return nil, "", result.Error
+	}
+
+	nextCursor := ""
+
+	if len(results) > search.Limit {
+		results = results[:search.Limit]
+		last := results[len(results)-1]
+
+		cursor := &BeatmapSearchCursor{
+			Sort:       search.Sort,
+			Descending: search.Descending,
+			Value:      last.SortValue,
+			Rank:       last.SortRank,
+			Id:         last.Id,
+		}
+		nextCursor = cursor.Encode()
+	}
+
+	ids := make([]int, len(results))
+	for i, entry := range results {
+		ids[i] = entry.Id
+	}
+
+	beatmapsets, err := FetchBeatmapsetsByIds(ids, state, preload...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return beatmapsets, nextCursor, nil
+}
+
+// query builds the query for the ids & sort values of the search results
+func (search *BeatmapSearch) query(db *gorm.DB) (*gorm.DB, error) {
+	if err := search.Normalize(); err != nil {
+		return nil, err
+	}
+
+	sorting := beatmapSearchSorts[search.Sort]
+	sortVars := []interface{}{}
+	selection := fmt.Sprintf("beatmapsets.id, CAST(%s AS text) AS sort_value", sorting[0])
+
+	if search.Sort == BeatmapSortRelevance {
+		// Ranks are compared as they are, since casting them
+		// to text and back may not return the same value
+		sortVars = append(sortVars, search.Query)
+		selection = fmt.Sprintf("beatmapsets.id, %s AS sort_rank", sorting[0])
+	}
+
+	query := db.Model(&Beatmapset{}).
+		Select(selection, sortVars...).
+		Where("beatmapsets.status != ?", BeatmapStatusNotSubmitted)
+
+	if search.Query != "" {
+		query = query.Where(
+			"beatmapsets.search_vector @@ websearch_to_tsquery('simple', ?)",
+			search.Query,
+		)
+	}
+
+	if search.Status != nil {
+		query = query.Where("beatmapsets.status = ?", *search.Status)
+	}
+
+	if search.Creator != "" {
+		query = query.Where(
+			"beatmapsets.creator_id IN (SELECT id FROM users WHERE LOWER(name) = ?)",
+			strings.ToLower(search.Creator),
+		)
+	}
+
+	if beatmaps := search.beatmapFilter(db); beatmaps != nil {
+		query = query.Where("EXISTS (?)", beatmaps)
+	}
+
+	comparison := ">"
+	direction := "ASC"
+
+	if search.Descending {
+		comparison = "<"
+		direction = "DESC"
+	}
+
+	if search.Cursor != "" {
+		cursor, err := DecodeBeatmapSearchCursor(search.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		if cursor.Sort != search.Sort || cursor.Descending != search.Descending {
+			return nil, ErrInvalidSearchCursor
+		}
+
+		vars := append([]interface{}{}, sortVars...)
+		value := "?"
+
+		if search.Sort == BeatmapSortRelevance {
+			vars = append(vars, cursor.Rank, cursor.Id)
+		} else {
+			value = fmt.Sprintf("CAST(? AS %s)", sorting[1])
+			vars = append(vars, cursor.Value, cursor.Id)
+		}
+
+		query = query.Where(
+			fmt.Sprintf("(%s, beatmapsets.id) %s (%s, ?)", sorting[0], comparison, value),
+			vars...,
+		)
+	}
+
+	query = query.Order(clause.OrderBy{
+		Expression: clause.Expr{
+			SQL:                fmt.Sprintf("%s %s, beatmapsets.id %s", sorting[0], direction, direction),
+			Vars:               sortVars,
+			WithoutParentheses: true,
+		},
+	})
+
+	return query, nil
+}
+
+// beatmapFilter returns a subquery for beatmaps of the current set, that
+// match the difficulty filters, or nil if there are none
+func (search *BeatmapSearch) beatmapFilter(db *gorm.DB) *gorm.DB {
+	ranges := []struct {
+		Column string
+		Range  SearchRange
+	}{
+		{"beatmaps.sr", search.SR},
+		{"beatmaps.median_bpm", search.Bpm},
+		{"beatmaps.total_length", search.Length},
+		{"beatmaps.cs", search.CS},
+		{"beatmaps.ar", search.AR},
+		{"beatmaps.od", search.OD},
+	}
+
+	query := db.Session(&gorm.Session{NewDB: true}).Table("beatmaps").
+		Select("1").
+		Where("beatmaps.set_id = beatmapsets.id")
+
+	filtered := false
+
+	for _, r := range ranges {
+		if r.Range.empty() {
+			continue
+		}
+
+		query = r.Range.apply(query, r.Column)
+		filtered = true
+	}
+
+	if !filtered {
+		return nil
+	}
+
+	return query
+}
@@ -0,0 +1,172 @@
+package common
+
+import (
+	"strings"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestBeatmapSearchCursor(t *testing.T) {
+	cursor := &BeatmapSearchCursor{
+		Sort:       BeatmapSortUpdated,
+		Descending: true,
+		Value:      "2024-10-01 12:00:00.123456+00",
+		Id:         42,
+	}
+
+	decoded, err := DecodeBeatmapSearchCursor(cursor.Encode())
+	if err != nil {
+		t.Fatalf("failed to decode cursor: %s", err)
+	}
+
+	if *decoded != *cursor {
+		t.Fatalf("expected %v, got %v", cursor, decoded)
+	}
+
+	if _, err = DecodeBeatmapSearchCursor("not a cursor"); err != ErrInvalidSearchCursor {
+		t.Fatalf("expected invalid cursor error, got %v", err)
+	}
+}
+
+func TestBeatmapSearchNormalize(t *testing.T) {
+	search := &BeatmapSearch{Limit: 1000}
+
+	if err := search.Normalize(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if search.Sort != BeatmapSortUpdated || !search.Descending {
+		t.Fatalf("expected newest updated first, got %s", search.Sort)
+	}
+
+	if search.Limit != BeatmapSearchMaxLimit {
+		t.Fatalf("expected limit %d, got %d", BeatmapSearchMaxLimit, search.Limit)
+	}
+
+	search = &BeatmapSearch{Query: " camellia "}
+
+	if err := search.Normalize(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if search.Sort != BeatmapSortRelevance || search.Query != "camellia" {
+		t.Fatalf("expected relevance sort, got %s", search.Sort)
+	}
+
+	search = &BeatmapSearch{Sort: BeatmapSortRelevance}
+
+	if err := search.Normalize(); err != ErrInvalidSearchSort {
+		t.Fatalf("expected invalid sort error without query, got %v", err)
+	}
+}
+
+func newDryRunDatabase(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(postgres.Open("host=localhost"), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+
+	return db
+}
+
+func searchSQL(t *testing.T, search *BeatmapSearch) string {
+	db := newDryRunDatabase(t)
+
+	return db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+		query, err := search.query(tx)
+		if err != nil {
+			t.Fatalf("failed to build query: %s", err)
+		}
+
+		return query.Limit(search.Limit + 1).Scan(&[]struct{}{})
+	})
+}
+
+func TestBeatmapSearchQuery(t *testing.T) {
+	cursor := &BeatmapSearchCursor{
+		Sort:       BeatmapSortRelevance,
+		Descending: true,
+		Rank:       0.0607927,
+		Id:         42,
+	}
+
+	sql := searchSQL(t, &BeatmapSearch{
+		Query:      "camellia",
+		Sort:       BeatmapSortRelevance,
+		Descending: true,
+		Cursor:     cursor.Encode(),
+	})
+
+	rank := "ts_rank(beatmapsets.search_vector, websearch_to_tsquery('simple', 'camellia'))"
+	expected := []string{
+		"SELECT beatmapsets.id, " + rank + " AS sort_rank",
+		"beatmapsets.search_vector @@ websearch_to_tsquery('simple', 'camellia')",
+		"(" + rank + ", beatmapsets.id) < (0.0607927, 42)",
+		"ORDER BY " + rank + " DESC, beatmapsets.id DESC",
+		"LIMIT 51",
+	}
+
+	for _, part := range expected {
+		if !strings.Contains(sql, part) {
+			t.Errorf("expected query to contain %q, got %s", part, sql)
+		}
+	}
+
+	if strings.Contains(sql, "AS text") || strings.Contains(sql, "AS real") {
+		t.Errorf("expected relevance not to be cast, got %s", sql)
+	}
+}
+
+func TestBeatmapSearchQueryFilters(t *testing.T) {
+	minSR := 4.5
+	status := BeatmapStatusRanked
+	cursor := &BeatmapSearchCursor{
+		Sort:  BeatmapSortUpdated,
+		Value: "2024-10-01 12:00:00.123456+00",
+		Id:    42,
+	}
+
+	sql := searchSQL(t, &BeatmapSearch{
+		Status:  &status,
+		Creator: "Peppy",
+		SR:      SearchRange{Min: &minSR},
+		Sort:    BeatmapSortUpdated,
+		Cursor:  cursor.Encode(),
+		Limit:   10,
+	})
+
+	expected := []string{
+		"SELECT beatmapsets.id, CAST(beatmapsets.last_updated AS text) AS sort_value",
+		"beatmapsets.status = 3",
+		"LOWER(name) = 'peppy'",
+		"EXISTS (SELECT 1 FROM \"beatmaps\" WHERE beatmaps.set_id = beatmapsets.id AND beatmaps.sr >= 4.5)",
+		"(beatmapsets.last_updated, beatmapsets.id) > (CAST('2024-10-01 12:00:00.123456+00' AS timestamptz), 42)",
+		"ORDER BY beatmapsets.last_updated ASC, beatmapsets.id ASC",
+		"LIMIT 11",
+	}
+
+	for _, part := range expected {
+		if !strings.Contains(sql, part) {
+			t.Errorf("expected query to contain %q, got %s", part, sql)
+		}
+	}
+
+	if strings.Contains(sql, "search_vector") {
+		t.Errorf("expected no text search without query, got %s", sql)
+	}
+}
+
+func TestBeatmapSearchQueryCursorMismatch(t *testing.T) {
+	cursor := &BeatmapSearchCursor{Sort: BeatmapSortCreated, Id: 1}
+	search := &BeatmapSearch{Sort: BeatmapSortTitle, Cursor: cursor.Encode()}
+
+	if _, err := search.query(newDryRunDatabase(t)); err != ErrInvalidSearchCursor {
+		t.Fatalf("expected invalid cursor error, got %v", err)
+	}
+}
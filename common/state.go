@@ -2,22 +2,67 @@ package common
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 )
 
+const (
+	StorageBackendFile = "file"
+	StorageBackendS3   = "s3"
+)
+
+const (
+	AudioProcessorFFmpeg = "ffmpeg"
+	AudioProcessorNone   = "none"
+)
+
 type StateConfiguration struct {
 	Database *DatabaseConfiguration
 	Redis    *RedisConfiguration
+	S3       *S3Configuration
 	DataPath string
+	// Either "file" or "s3". Temporary files are always kept in the data path.
+	StorageBackend string
+	// Either "ffmpeg" or "none", which disables audio previews
+	AudioProcessor string
 }
 
 func NewStateConfiguration() *StateConfiguration {
 	return &StateConfiguration{
-		Database: &DatabaseConfiguration{},
-		Redis:    &RedisConfiguration{},
-		DataPath: ".data",
+		Database:       &DatabaseConfiguration{},
+		Redis:          &RedisConfiguration{},
+		S3:             &S3Configuration{},
+		DataPath:       ".data",
+		StorageBackend: StorageBackendFile,
+		AudioProcessor: AudioProcessorFFmpeg,
+	}
+}
+
+func CreateStorage(config *StateConfiguration) (Storage, error) {
+	switch config.StorageBackend {
+	case StorageBackendFile:
+		return NewFileStorage(config.DataPath), nil
+	case StorageBackendS3:
+		backend, err := NewS3Storage(config.S3)
+		if err != nil {
+			return nil, err
+		}
+		return NewObjectStorage(backend, config.DataPath), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend '%s'", config.StorageBackend)
+	}
+}
+
+func CreateAudioProcessor(config *StateConfiguration, storage Storage) (AudioProcessor, error) {
+	switch config.AudioProcessor {
+	case AudioProcessorFFmpeg:
+		return NewFFmpegAudioProcessor(storage), nil
+	case AudioProcessorNone:
+		return &DisabledAudioProcessor{}, nil
+	default:
+		return nil, fmt.Errorf("unknown audio processor '%s'", config.AudioProcessor)
 	}
 }
 
@@ -26,6 +71,7 @@ type State struct {
 	Redis        *redis.Client
 	RedisContext *context.Context
 	Storage      Storage
+	Audio        AudioProcessor
 }
 
 func NewState(config *StateConfiguration) (*State, error) {
@@ -40,16 +86,37 @@ func NewState(config *StateConfiguration) (*State, error) {
 		return nil, err
 	}
 
-	storage := NewFileStorage(config.DataPath)
+	storage, err := CreateStorage(config)
+	if err != nil {
+		return nil, err
+	}
+
 	err = storage.EnsureDefaultAvatar()
 	if err != nil {
 		return nil, err
 	}
 
+	audio, err := CreateAudioProcessor(config, storage)
+	if err != nil {
+		return nil, err
+	}
+
 	return &State{
 		Database:     db,
 		Storage:      storage,
+		Audio:        audio,
 		Redis:        rdb,
 		RedisContext: &ctx,
 	}, nil
 }
+
+// Transaction runs the callback inside a database transaction. The callback
+// receives a copy of the state, whose queries go through the transaction.
+// Redis & storage writes are not part of the transaction.
+func (state *State) Transaction(callback func(tx *State) error) error {
+	return state.Database.Transaction(func(db *gorm.DB) error {
+		tx := *state
+		tx.Database = db
+		return callback(&tx)
+	})
+}
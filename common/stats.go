@@ -0,0 +1,96 @@
+package common
+
+import (
+	"fmt"
+	"math"
+)
+
+// Accuracy is stored with 6 decimals, so small differences are rounding
+const statsAccuracyTolerance = 1e-4
+
+// ApplyPersonalBest incrementally updates the score-derived stats, when
+// a new personal best replaces the previous one, which may be nil.
+// Max combo can only grow here, so it needs to be fetched again if the
+// previous personal best held it. Accuracy is not updated, since the
+// stored, rounded average would drift with every personal best.
+func (stats *Stats) ApplyPersonalBest(previous *Score, best *Score, beatmapStatus BeatmapStatus) {
+	if previous != nil {
+		stats.AddGrade(previous.Grade, -1)
+
+		if beatmapStatus == BeatmapStatusRanked {
+			stats.RankedScore -= previous.TotalScore
+		}
+	}
+
+	stats.AddGrade(best.Grade, 1)
+
+	if beatmapStatus == BeatmapStatusRanked {
+		stats.RankedScore += best.TotalScore
+	}
+
+	if best.MaxCombo > stats.MaxCombo {
+		stats.MaxCombo = best.MaxCombo
+	}
+}
+
+// RoundAccuracy rounds an accuracy to the 6 decimals it is stored with
+func RoundAccuracy(accuracy float64) float64 {
+	return math.Round(accuracy*1e6) / 1e6
+}
+
+func (stats *Stats) AddGrade(grade Grade, amount int) {
+	switch grade {
+	case GradeXH:
+		stats.XHCount += amount
+	case GradeX:
+		stats.XCount += amount
+	case GradeSH:
+		stats.SHCount += amount
+	case GradeS:
+		stats.SCount += amount
+	case GradeA:
+		stats.ACount += amount
+	case GradeB:
+		stats.BCount += amount
+	case GradeC:
+		stats.CCount += amount
+	case GradeD:
+		stats.DCount += amount
+	}
+}
+
+// CompareStats returns a description of every field derived from
+// scores, in which the actual stats differ from the expected ones.
+func CompareStats(expected *Stats, actual *Stats) []string {
+	differences := []string{}
+
+	compare := func(field string, expected any, actual any) {
+		if expected != actual {
+			differences = append(differences, fmt.Sprintf("%s: %v != %v", field, actual, expected))
+		}
+	}
+
+	compare("TotalScore", expected.TotalScore, actual.TotalScore)
+	compare("TotalHits", expected.TotalHits, actual.TotalHits)
+	compare("Playcount", expected.Playcount, actual.Playcount)
+	compare("RankedScore", expected.RankedScore, actual.RankedScore)
+	compare("MaxCombo", expected.MaxCombo, actual.MaxCombo)
+	compare("XHCount", expected.XHCount, actual.XHCount)
+	compare("XCount", expected.XCount, actual.XCount)
+	compare("SHCount", expected.SHCount, actual.SHCount)
+	compare("SCount", expected.SCount, actual.SCount)
+	compare("ACount", expected.ACount, actual.ACount)
+	compare("BCount", expected.BCount, actual.BCount)
+	compare("CCount", expected.CCount, actual.CCount)
+	compare("DCount", expected.DCount, actual.DCount)
+
+	if math.Abs(expected.Accuracy-actual.Accuracy) > statsAccuracyTolerance {
+		compare("Accuracy", expected.Accuracy, actual.Accuracy)
+	}
+
+	if math.Abs(expected.PP-actual.PP) > statsAccuracyTolerance {
+		compare("PP", expected.PP, actual.PP)
+	}
+
+	return differences
+}
@@ -0,0 +1,34 @@
+package common
+
+import "testing"
+
+func TestApplyPersonalBest(t *testing.T) {
+	stats := &Stats{}
+
+	first := &Score{TotalScore: 1000, Accuracy: 0.9, Grade: GradeA, MaxCombo: 100}
+	second := &Score{TotalScore: 3000, Accuracy: 0.8, Grade: GradeB, MaxCombo: 50}
+	improved := &Score{TotalScore: 5000, Accuracy: 1.0, Grade: GradeX, MaxCombo: 120}
+
+	stats.ApplyPersonalBest(nil, first, BeatmapStatusRanked)
+	stats.ApplyPersonalBest(nil, second, BeatmapStatusApproved)
+	stats.ApplyPersonalBest(first, improved, BeatmapStatusRanked)
+
+	// Same stats, as if they were calculated from the current personal
+	// bests, except for accuracy, which is fetched from the scores
+	expected := &Stats{
+		RankedScore: 5000,
+		MaxCombo:    120,
+		XCount:      1,
+		BCount:      1,
+	}
+
+	if differences := CompareStats(expected, stats); len(differences) > 0 {
+		t.Fatalf("unexpected stats: %v", differences)
+	}
+}
+
+func TestRoundAccuracy(t *testing.T) {
+	if accuracy := RoundAccuracy(0.98765432); accuracy != 0.987654 {
+		t.Fatalf("expected 0.987654, got %f", accuracy)
+	}
+}
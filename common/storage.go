@@ -1,20 +1,119 @@
 package common
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
-type Storage interface {
-	// Base
+// ErrPresignNotSupported is returned by backends that can not
+// generate urls to their objects, e.g. local file storage
+var ErrPresignNotSupported = errors.New("presigned urls are not supported")
+
+var (
+	ErrChecksumMissing  = errors.New("object has no checksum")
+	ErrChecksumMismatch = errors.New("object does not match its checksum")
+	// The object was replaced, but saving was interrupted before its
+	// checksum was, so the stored checksum belongs to the previous object
+	ErrChecksumPending = errors.New("checksum of object is pending")
+)
+
+// Key of the avatar that is shown for users without one
+const DefaultAvatarKey = "unknown"
+
+// Buckets of all objects, which are managed by the storage
+var StorageBuckets = []string{
+	"replays", "avatars", "beatmaps", "packages", "thumbnails", "previews",
+}
+
+// StorageBackend stores raw objects, addressed by bucket & key
+type StorageBackend interface {
+	// Save stores the object together with its checksum
 	Save(key string, bucket string, data []byte) error
 	Read(key string, bucket string) ([]byte, error)
+	// Open returns a reader of the object, that loads it as it is read
+	Open(key string, bucket string) (io.ReadSeekCloser, error)
 	Remove(key string, bucket string) error
+	// List returns the keys of all objects in a bucket
+	List(bucket string) ([]string, error)
+	// ModTime returns when the object was last saved
+	ModTime(key string, bucket string) (time.Time, error)
+	// Checksum returns the sha256 checksum stored for an object
+	Checksum(key string, bucket string) (string, error)
+	// Quarantine moves an object out of its bucket, to be inspected manually
+	Quarantine(key string, bucket string) error
+	// PresignURL returns a temporary url to download an object from,
+	// which is served under the given filename
+	PresignURL(key string, bucket string, filename string, expiry time.Duration) (string, error)
+}
+
+// FormatContentDisposition returns the header to download a file under the
+// given name. Clients that don't support the encoded name (RFC 5987) fall
+// back to the plain one, where all unsafe characters are replaced.
+func FormatContentDisposition(filename string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r < 32 || r > 126 || r == '"' || r == '\\' || r == '%' {
+			return '_'
+		}
+		return r
+	}, filename)
+
+	encoded := strings.Builder{}
+
+	for _, b := range []byte(filename) {
+		isAttrChar := (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') ||
+			(b >= '0' && b <= '9') || strings.IndexByte("!#$&+-.^_`|~", b) >= 0
+
+		if isAttrChar {
+			encoded.WriteByte(b)
+			continue
+		}
+
+		fmt.Fprintf(&encoded, "%%%02X", b)
+	}
+
+	return fmt.Sprintf("attachment; filename=\"%s\"; filename*=UTF-8''%s", fallback, encoded.String())
+}
+
+func ObjectChecksum(data []byte) string {
+	checksum := sha256.Sum256(data)
+	return hex.EncodeToString(checksum[:])
+}
+
+// VerifyObject compares an object against its stored checksum
+func VerifyObject(backend StorageBackend, key string, bucket string) error {
+	checksum, err := backend.Checksum(key, bucket)
+	if err != nil {
+		return err
+	}
+
+	if checksum == "" {
+		return ErrChecksumMissing
+	}
+
+	data, err := backend.Read(key, bucket)
+	if err != nil {
+		return err
+	}
+
+	if ObjectChecksum(data) != checksum {
+		return ErrChecksumMismatch
+	}
+
+	return nil
+}
+
+type Storage interface {
+	// Base
+	StorageBackend
 	Download(url string, key string, bucket string) error
 	CreateTempFile() (*os.File, error)
 
@@ -32,49 +131,37 @@ type Storage interface {
 	// Beatmaps
 	GetBeatmapFile(beatmapId int) ([]byte, error)
 	GetBeatmapPackage(beatmapsetId int) ([]byte, error)
-	GetBeatmapThumbnail(beatmapId int, large bool) ([]byte, error)
-	GetBeatmapPreview(beatmapId int) ([]byte, error)
+	GetBeatmapPackageNoVideo(beatmapsetId int) ([]byte, error)
+	OpenBeatmapPackage(beatmapsetId int, noVideo bool) (io.ReadSeekCloser, error)
+	BeatmapPackageChecksum(beatmapsetId int, noVideo bool) (string, error)
+	GetBeatmapsetThumbnail(beatmapsetId int, large bool) ([]byte, error)
+	GetBeatmapsetPreview(beatmapsetId int) ([]byte, error)
+	BeatmapsetThumbnailChecksum(beatmapsetId int, large bool) (string, error)
+	BeatmapsetPreviewChecksum(beatmapsetId int) (string, error)
+	GetBeatmapPackageURL(beatmapsetId int, noVideo bool, filename string, expiry time.Duration) (string, error)
 	SaveBeatmapFile(beatmapId int, data []byte) error
 	SaveBeatmapPackage(beatmapsetId int, data []byte) error
-	SaveBeatmapThumbnail(beatmapId int, data []byte, large bool) error
-	SaveBeatmapPreview(beatmapId int, data []byte) error
+	SaveBeatmapPackageNoVideo(beatmapsetId int, data []byte) error
+	SaveBeatmapsetThumbnail(beatmapsetId int, data []byte, large bool) error
+	SaveBeatmapsetPreview(beatmapsetId int, data []byte) error
 	RemoveBeatmapFile(beatmapId int) error
 	RemoveBeatmapPackage(beatmapsetId int) error
-	RemoveBeatmapThumbnail(beatmapId int) error
-	RemoveBeatmapPreview(beatmapId int) error
+	RemoveBeatmapsetThumbnail(beatmapsetId int) error
+	RemoveBeatmapsetPreview(beatmapsetId int) error
 }
 
-type FileStorage struct {
-	dataPath string
+// ObjectStorage implements the object layout of the storage
+// on top of any backend
+type ObjectStorage struct {
+	StorageBackend
+	tempPath string
 }
 
-func NewFileStorage(dataPath string) Storage {
-	return &FileStorage{dataPath: dataPath}
+func NewObjectStorage(backend StorageBackend, tempPath string) Storage {
+	return &ObjectStorage{StorageBackend: backend, tempPath: tempPath}
 }
 
-func (storage *FileStorage) Read(key string, folder string) ([]byte, error) {
-	path := fmt.Sprintf("%s/%s/%s", storage.dataPath, folder, key)
-	return os.ReadFile(path)
-}
-
-func (storage *FileStorage) Save(key string, folder string, data []byte) error {
-	path := fmt.Sprintf("%s/%s", storage.dataPath, folder)
-	err := os.MkdirAll(path, 0755)
-
-	if err != nil {
-		return err
-	}
-
-	os.WriteFile(fmt.Sprintf("%s/%s", path, key), data, os.ModePerm)
-	return nil
-}
-
-func (storage *FileStorage) Remove(key string, folder string) error {
-	path := fmt.Sprintf("%s/%s/%s", storage.dataPath, folder, key)
-	return os.Remove(path)
-}
-
-func (storage *FileStorage) Download(url string, key string, folder string) error {
+func (storage *ObjectStorage) Download(url string, key string, folder string) error {
 	resp, err := http.Get(url)
 	if err != nil {
 		return err
@@ -93,23 +180,26 @@ func (storage *FileStorage) Download(url string, key string, folder string) erro
 	return storage.Save(key, folder, data)
 }
 
-func (storage *FileStorage) CreateTempFile() (*os.File, error) {
-	return os.CreateTemp(storage.dataPath, "temp")
+func (storage *ObjectStorage) CreateTempFile() (*os.File, error) {
+	if err := os.MkdirAll(storage.tempPath, 0755); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(storage.tempPath, "temp")
 }
 
-func (storage *FileStorage) GetReplayFile(scoreId int) ([]byte, error) {
+func (storage *ObjectStorage) GetReplayFile(scoreId int) ([]byte, error) {
 	return storage.Read(fmt.Sprintf("%d", scoreId), "replays")
 }
 
-func (storage *FileStorage) SaveReplayFile(scoreId int, data []byte) error {
+func (storage *ObjectStorage) SaveReplayFile(scoreId int, data []byte) error {
 	return storage.Save(strconv.Itoa(scoreId), "replays", data)
 }
 
-func (storage *FileStorage) RemoveReplayFile(scoreId int) error {
+func (storage *ObjectStorage) RemoveReplayFile(scoreId int) error {
 	return storage.Remove(strconv.Itoa(scoreId), "replays")
 }
 
-func (storage *FileStorage) GetAvatar(userId int) ([]byte, error) {
+func (storage *ObjectStorage) GetAvatar(userId int) ([]byte, error) {
 	avatar, err := storage.Read(fmt.Sprintf("%d", userId), "avatars")
 	if err != nil {
 		return storage.DefaultAvatar()
@@ -117,15 +207,15 @@ func (storage *FileStorage) GetAvatar(userId int) ([]byte, error) {
 	return avatar, nil
 }
 
-func (storage *FileStorage) SaveAvatar(userId int, data []byte) error {
+func (storage *ObjectStorage) SaveAvatar(userId int, data []byte) error {
 	return storage.Save(strconv.Itoa(userId), "avatars", data)
 }
 
-func (storage *FileStorage) DefaultAvatar() ([]byte, error) {
-	return storage.Read("unknown", "avatars")
+func (storage *ObjectStorage) DefaultAvatar() ([]byte, error) {
+	return storage.Read(DefaultAvatarKey, "avatars")
 }
 
-func (storage *FileStorage) EnsureDefaultAvatar() error {
+func (storage *ObjectStorage) EnsureDefaultAvatar() error {
 	_, err := storage.DefaultAvatar()
 	if err == nil {
 		return nil
@@ -134,7 +224,7 @@ func (storage *FileStorage) EnsureDefaultAvatar() error {
 	// Download the default avatar
 	err = storage.Download(
 		"https://raw.githubusercontent.com/hexis-revival/hexagon/refs/heads/main/.github/images/unknown.png",
-		"unknown", "avatars",
+		DefaultAvatarKey, "avatars",
 	)
 
 	if err != nil {
@@ -144,60 +234,99 @@ func (storage *FileStorage) EnsureDefaultAvatar() error {
 	return nil
 }
 
-func (storage *FileStorage) GetBeatmapFile(beatmapId int) ([]byte, error) {
+func (storage *ObjectStorage) GetBeatmapFile(beatmapId int) ([]byte, error) {
 	return storage.Read(fmt.Sprintf("%d", beatmapId), "beatmaps")
 }
 
-func (storage *FileStorage) GetBeatmapPackage(beatmapsetId int) ([]byte, error) {
+func (storage *ObjectStorage) GetBeatmapPackage(beatmapsetId int) ([]byte, error) {
 	return storage.Read(fmt.Sprintf("%d", beatmapsetId), "packages")
 }
 
-func (storage *FileStorage) GetBeatmapThumbnail(beatmapId int, large bool) ([]byte, error) {
-	return storage.Read(formatThumbnailName(beatmapId, large), "thumbnails")
+func (storage *ObjectStorage) GetBeatmapPackageNoVideo(beatmapsetId int) ([]byte, error) {
+	return storage.Read(fmt.Sprintf("%d_novideo", beatmapsetId), "packages")
+}
+
+func (storage *ObjectStorage) OpenBeatmapPackage(beatmapsetId int, noVideo bool) (io.ReadSeekCloser, error) {
+	return storage.Open(formatPackageName(beatmapsetId, noVideo), "packages")
+}
+
+func (storage *ObjectStorage) BeatmapPackageChecksum(beatmapsetId int, noVideo bool) (string, error) {
+	return storage.Checksum(formatPackageName(beatmapsetId, noVideo), "packages")
 }
 
-func (storage *FileStorage) GetBeatmapPreview(beatmapId int) ([]byte, error) {
-	return storage.Read(fmt.Sprintf("%d", beatmapId), "previews")
+func (storage *ObjectStorage) GetBeatmapPackageURL(beatmapsetId int, noVideo bool, filename string, expiry time.Duration) (string, error) {
+	return storage.PresignURL(formatPackageName(beatmapsetId, noVideo), "packages", filename, expiry)
 }
 
-func (storage *FileStorage) SaveBeatmapFile(beatmapId int, data []byte) error {
+func (storage *ObjectStorage) GetBeatmapsetThumbnail(beatmapsetId int, large bool) ([]byte, error) {
+	return storage.Read(formatThumbnailName(beatmapsetId, large), "thumbnails")
+}
+
+func (storage *ObjectStorage) GetBeatmapsetPreview(beatmapsetId int) ([]byte, error) {
+	return storage.Read(fmt.Sprintf("%d", beatmapsetId), "previews")
+}
+
+func (storage *ObjectStorage) BeatmapsetThumbnailChecksum(beatmapsetId int, large bool) (string, error) {
+	return storage.Checksum(formatThumbnailName(beatmapsetId, large), "thumbnails")
+}
+
+func (storage *ObjectStorage) BeatmapsetPreviewChecksum(beatmapsetId int) (string, error) {
+	return storage.Checksum(strconv.Itoa(beatmapsetId), "previews")
+}
+
+func (storage *ObjectStorage) SaveBeatmapFile(beatmapId int, data []byte) error {
 	return storage.Save(strconv.Itoa(beatmapId), "beatmaps", data)
 }
 
-func (storage *FileStorage) SaveBeatmapPackage(beatmapsetId int, data []byte) error {
+func (storage *ObjectStorage) SaveBeatmapPackage(beatmapsetId int, data []byte) error {
 	return storage.Save(strconv.Itoa(beatmapsetId), "packages", data)
 }
 
-func (storage *FileStorage) SaveBeatmapThumbnail(beatmapId int, data []byte, large bool) error {
-	return storage.Save(formatThumbnailName(beatmapId, large), "thumbnails", data)
+func (storage *ObjectStorage) SaveBeatmapPackageNoVideo(beatmapsetId int, data []byte) error {
+	return storage.Save(fmt.Sprintf("%d_novideo", beatmapsetId), "packages", data)
 }
 
-func (storage *FileStorage) SaveBeatmapPreview(beatmapId int, data []byte) error {
-	return storage.Save(strconv.Itoa(beatmapId), "previews", data)
+func (storage *ObjectStorage) SaveBeatmapsetThumbnail(beatmapsetId int, data []byte, large bool) error {
+	return storage.Save(formatThumbnailName(beatmapsetId, large), "thumbnails", data)
 }
 
-func (storage *FileStorage) RemoveBeatmapFile(beatmapId int) error {
+func (storage *ObjectStorage) SaveBeatmapsetPreview(beatmapsetId int, data []byte) error {
+	return storage.Save(strconv.Itoa(beatmapsetId), "previews", data)
+}
+
+func (storage *ObjectStorage) RemoveBeatmapFile(beatmapId int) error {
 	return storage.Remove(strconv.Itoa(beatmapId), "beatmaps")
 }
 
-func (storage *FileStorage) RemoveBeatmapPackage(beatmapsetId int) error {
+func (storage *ObjectStorage) RemoveBeatmapPackage(beatmapsetId int) error {
+	err := storage.Remove(fmt.Sprintf("%d_novideo", beatmapsetId), "packages")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	return storage.Remove(strconv.Itoa(beatmapsetId), "packages")
 }
 
-func (storage *FileStorage) RemoveBeatmapThumbnail(beatmapId int) error {
-	err := storage.Remove(formatThumbnailName(beatmapId, true), "thumbnails")
-	if err != nil {
+func (storage *ObjectStorage) RemoveBeatmapsetThumbnail(beatmapsetId int) error {
+	err := storage.Remove(formatThumbnailName(beatmapsetId, true), "thumbnails")
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	return storage.Remove(formatThumbnailName(beatmapId, false), "thumbnails")
+	return storage.Remove(formatThumbnailName(beatmapsetId, false), "thumbnails")
+}
+
+func (storage *ObjectStorage) RemoveBeatmapsetPreview(beatmapsetId int) error {
+	return storage.Remove(strconv.Itoa(beatmapsetId), "previews")
 }
 
-func (storage *FileStorage) RemoveBeatmapPreview(beatmapId int) error {
-	return storage.Remove(strconv.Itoa(beatmapId), "previews")
+func formatPackageName(beatmapsetId int, noVideo bool) string {
+	if noVideo {
+		return fmt.Sprintf("%d_novideo", beatmapsetId)
+	}
+	return strconv.Itoa(beatmapsetId)
 }
 
-func formatThumbnailName(beatmapId int, large bool) string {
-	return fmt.Sprintf("%d%s", beatmapId, getThumbnailSuffix(large))
+func formatThumbnailName(beatmapsetId int, large bool) string {
+	return fmt.Sprintf("%d%s", beatmapsetId, getThumbnailSuffix(large))
 }
 
 func getThumbnailSuffix(large bool) string {
@@ -0,0 +1,220 @@
+package common
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	fileStorageDirectoryMode = 0755
+	fileStorageFileMode      = 0644
+	// Checksums are stored next to their object, with this suffix
+	fileStorageChecksumSuffix = ".sha256"
+	// Prefix of files that are being written
+	fileStorageTempPrefix = ".tmp-"
+)
+
+type FileStorage struct {
+	dataPath string
+}
+
+func NewFileStorage(dataPath string) Storage {
+	return NewObjectStorage(&FileStorage{dataPath: dataPath}, dataPath)
+}
+
+func (storage *FileStorage) Read(key string, folder string) ([]byte, error) {
+	return os.ReadFile(storage.path(key, folder))
+}
+
+func (storage *FileStorage) Open(key string, folder string) (io.ReadSeekCloser, error) {
+	return os.Open(storage.path(key, folder))
+}
+
+func (storage *FileStorage) Save(key string, folder string, data []byte) error {
+	path := storage.path(key, folder)
+	err := os.MkdirAll(filepath.Dir(path), fileStorageDirectoryMode)
+
+	if err != nil {
+		return err
+	}
+
+	object, err := writeTempFile(path, data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(object)
+
+	// The checksum is written after the object, and is never older than it,
+	// unless saving was interrupted before the checksum was renamed as well
+	checksum, err := writeTempFile(path, []byte(ObjectChecksum(data)))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(checksum)
+
+	if err = os.Rename(object, path); err != nil {
+		return err
+	}
+
+	if err = os.Rename(checksum, path+fileStorageChecksumSuffix); err != nil {
+		return err
+	}
+
+	return syncDirectory(filepath.Dir(path))
+}
+
+func (storage *FileStorage) Remove(key string, folder string) error {
+	path := storage.path(key, folder)
+
+	err := os.Remove(path + fileStorageChecksumSuffix)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+func (storage *FileStorage) List(folder string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(storage.dataPath, folder))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(entries))
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		if entry.IsDir() ||
+			strings.HasSuffix(name, fileStorageChecksumSuffix) ||
+			strings.HasPrefix(name, fileStorageTempPrefix) {
+			continue
+		}
+
+		keys = append(keys, name)
+	}
+
+	return keys, nil
+}
+
+func (storage *FileStorage) ModTime(key string, folder string) (time.Time, error) {
+	info, err := os.Stat(storage.path(key, folder))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return info.ModTime(), nil
+}
+
+func (storage *FileStorage) Checksum(key string, folder string) (string, error) {
+	path := storage.path(key, folder)
+
+	checksumInfo, err := os.Stat(path + fileStorageChecksumSuffix)
+	if os.IsNotExist(err) {
+		// Objects written before checksums were introduced
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	objectInfo, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	if objectInfo.ModTime().After(checksumInfo.ModTime()) {
+		return "", ErrChecksumPending
+	}
+
+	checksum, err := os.ReadFile(path + fileStorageChecksumSuffix)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(checksum)), nil
+}
+
+func (storage *FileStorage) Quarantine(key string, folder string) error {
+	path := storage.path(key, folder)
+	target := filepath.Join(storage.dataPath, "quarantine", folder, key)
+
+	err := os.MkdirAll(filepath.Dir(target), fileStorageDirectoryMode)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(path, target)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(path+fileStorageChecksumSuffix, target+fileStorageChecksumSuffix)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (storage *FileStorage) PresignURL(key string, folder string, filename string, expiry time.Duration) (string, error) {
+	return "", ErrPresignNotSupported
+}
+
+func (storage *FileStorage) path(key string, folder string) string {
+	return filepath.Join(storage.dataPath, folder, key)
+}
+
+// writeTempFile writes the data into a temporary file next to the target,
+// which is synced to disk, so that renaming it replaces the target at once.
+// Readers will either see the previous or the new file, but never a
+// partially written one. Removing the file is up to the caller.
+func writeTempFile(path string, data []byte) (string, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), fileStorageTempPrefix+"*")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	if err = file.Sync(); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	if err = file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	if err = os.Chmod(file.Name(), fileStorageFileMode); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
+
+// syncDirectory persists renames inside of a directory
+func syncDirectory(path string) error {
+	directory, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer directory.Close()
+
+	return directory.Sync()
+}
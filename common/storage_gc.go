@@ -0,0 +1,116 @@
+package common
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Objects are saved before the database rows that reference them are
+// committed, so recent objects are never considered to be orphaned
+const StorageGracePeriod = 24 * time.Hour
+
+// StorageObject identifies a single object in the storage
+type StorageObject struct {
+	Bucket string
+	Key    string
+}
+
+func (object StorageObject) String() string {
+	return object.Bucket + "/" + object.Key
+}
+
+// StorageReferences contains the ids that objects of each bucket may be
+// keyed by, as they exist in the database
+type StorageReferences map[string]map[int]bool
+
+// ParseObjectId returns the id an object key belongs to. Keys consist of
+// the id, optionally followed by "_" and a variant, e.g. "12_large".
+func ParseObjectId(key string) (int, bool) {
+	id, _, _ := strings.Cut(key, "_")
+
+	value, err := strconv.Atoi(id)
+	if err != nil || value <= 0 {
+		return 0, false
+	}
+
+	return value, true
+}
+
+// FetchStorageReferences loads the ids of all objects that may have
+// stored files, keyed by the bucket of those files
+func FetchStorageReferences(state *State) (StorageReferences, error) {
+	scoreIds, err := FetchIds(&Score{}, state)
+	if err != nil {
+		return nil, err
+	}
+
+	userIds, err := FetchIds(&User{}, state)
+	if err != nil {
+		return nil, err
+	}
+
+	beatmapIds, err := FetchIds(&Beatmap{}, state)
+	if err != nil {
+		return nil, err
+	}
+
+	beatmapsetIds, err := FetchIds(&Beatmapset{}, state)
+	if err != nil {
+		return nil, err
+	}
+
+	return StorageReferences{
+		"replays":    idSet(scoreIds),
+		"avatars":    idSet(userIds),
+		"beatmaps":   idSet(beatmapIds),
+		"packages":   idSet(beatmapsetIds),
+		"thumbnails": idSet(beatmapsetIds),
+		"previews":   idSet(beatmapsetIds),
+	}, nil
+}
+
+// FindOrphanedObjects lists all objects, whose id is not referenced anymore
+// and that were last saved before the cutoff
+func FindOrphanedObjects(backend StorageBackend, references StorageReferences, cutoff time.Time) ([]StorageObject, error) {
+	orphans := []StorageObject{}
+
+	for _, bucket := range StorageBuckets {
+		keys, err := backend.List(bucket)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range keys {
+			if bucket == "avatars" && key == DefaultAvatarKey {
+				continue
+			}
+
+			id, ok := ParseObjectId(key)
+			if ok && references[bucket][id] {
+				continue
+			}
+
+			modTime, err := backend.ModTime(key, bucket)
+			if err != nil {
+				return nil, err
+			}
+
+			if modTime.After(cutoff) {
+				continue
+			}
+
+			orphans = append(orphans, StorageObject{Bucket: bucket, Key: key})
+		}
+	}
+
+	return orphans, nil
+}
+
+func idSet(ids []int) map[int]bool {
+	set := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
@@ -0,0 +1,84 @@
+package common
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseObjectId(t *testing.T) {
+	cases := map[string]int{
+		"12":         12,
+		"12_large":   12,
+		"7_novideo":  7,
+		"unknown":    0,
+		"-1":         0,
+		"temp123456": 0,
+	}
+
+	for key, expected := range cases {
+		id, _ := ParseObjectId(key)
+		if id != expected {
+			t.Errorf("expected id %d for '%s', got %d", expected, key, id)
+		}
+	}
+}
+
+func TestFindOrphanedObjects(t *testing.T) {
+	backend := &FileStorage{dataPath: t.TempDir()}
+
+	objects := []StorageObject{
+		{"avatars", DefaultAvatarKey},
+		{"avatars", "1"},
+		{"replays", "5"},
+		{"replays", "6"},
+		{"thumbnails", "2_large"},
+		{"thumbnails", "3_small"},
+		{"packages", "invalid"},
+	}
+
+	saved := time.Now().Add(-time.Hour)
+
+	for _, object := range objects {
+		if err := backend.Save(object.Key, object.Bucket, []byte{}); err != nil {
+			t.Fatalf("failed to save %s: %s", object, err)
+		}
+
+		path := backend.path(object.Key, object.Bucket)
+		if err := os.Chtimes(path, saved, saved); err != nil {
+			t.Fatalf("failed to update mtime of %s: %s", object, err)
+		}
+	}
+
+	// Saved within the grace period, e.g. by a pending upload
+	if err := backend.Save("7", "replays", []byte{}); err != nil {
+		t.Fatalf("failed to save recent replay: %s", err)
+	}
+
+	references := StorageReferences{
+		"avatars":    {1: true},
+		"replays":    {5: true},
+		"thumbnails": {2: true},
+	}
+
+	orphans, err := FindOrphanedObjects(backend, references, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("failed to find orphans: %s", err)
+	}
+
+	expected := map[string]bool{
+		"replays/6":          true,
+		"thumbnails/3_small": true,
+		"packages/invalid":   true,
+	}
+
+	if len(orphans) != len(expected) {
+		t.Fatalf("expected %d orphans, got %v", len(expected), orphans)
+	}
+
+	for _, orphan := range orphans {
+		if !expected[orphan.String()] {
+			t.Errorf("unexpected orphan %s", orphan)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Metadata key of object checksums, the sdk returns metadata keys in lowercase
+const s3ChecksumMetadata = "sha256"
+
+type S3Configuration struct {
+	// Endpoint of an s3-compatible service, e.g. minio. Uses aws if empty.
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+}
+
+// S3Storage stores objects in a single s3 bucket, where the
+// storage bucket of an object is used as its key prefix
+type S3Storage struct {
+	client    *s3.Client
+	presigner *s3.PresignClient
+	bucket    string
+}
+
+func NewS3Storage(configuration *S3Configuration) (*S3Storage, error) {
+	awsConfig, err := config.LoadDefaultConfig(
+		context.Background(),
+		config.WithRegion(configuration.Region),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
+			configuration.AccessKey,
+			configuration.SecretKey,
+			"",
+		)),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	client := s3.NewFromConfig(awsConfig, func(options *s3.Options) {
+		// Ranged reads would fail the validation against the checksum of the
+		// whole object, and not every s3-compatible service supports them
+		options.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+		options.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+
+		if configuration.Endpoint != "" {
+			// Self-hosted services usually don't support bucket subdomains
+			options.BaseEndpoint = aws.String(configuration.Endpoint)
+			options.UsePathStyle = true
+		}
+	})
+
+	return &S3Storage{
+		client:    client,
+		presigner: s3.NewPresignClient(client),
+		bucket:    configuration.Bucket,
+	}, nil
+}
+
+func (storage *S3Storage) Read(key string, bucket string) ([]byte, error) {
+	output, err := storage.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(storage.bucket),
+		Key:    aws.String(storage.objectKey(key, bucket)),
+	})
+
+	if err != nil {
+		return nil, storage.wrapError("read", key, bucket, err)
+	}
+	defer output.Body.Close()
+
+	return io.ReadAll(output.Body)
+}
+
+// Open returns a reader of the object, that fetches it as it is read
+func (storage *S3Storage) Open(key string, bucket string) (io.ReadSeekCloser, error) {
+	output, err := storage.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(storage.bucket),
+		Key:    aws.String(storage.objectKey(key, bucket)),
+	})
+
+	if err != nil {
+		return nil, storage.wrapError("open", key, bucket, err)
+	}
+
+	return &s3ObjectReader{
+		storage: storage,
+		key:     key,
+		bucket:  bucket,
+		size:    aws.ToInt64(output.ContentLength),
+	}, nil
+}
+
+func (storage *S3Storage) Save(key string, bucket string, data []byte) error {
+	_, err := storage.client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket:        aws.String(storage.bucket),
+		Key:           aws.String(storage.objectKey(key, bucket)),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+		Metadata: map[string]string{
+			s3ChecksumMetadata: ObjectChecksum(data),
+		},
+	})
+
+	return storage.wrapError("save", key, bucket, err)
+}
+
+func (storage *S3Storage) Remove(key string, bucket string) error {
+	_, err := storage.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(storage.bucket),
+		Key:    aws.String(storage.objectKey(key, bucket)),
+	})
+
+	return storage.wrapError("remove", key, bucket, err)
+}
+
+func (storage *S3Storage) List(bucket string) ([]string, error) {
+	keys := []string{}
+	prefix := bucket + "/"
+
+	paginator := s3.NewListObjectsV2Paginator(storage.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(storage.bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range page.Contents {
+			keys = append(keys, strings.TrimPrefix(aws.ToString(object.Key), prefix))
+		}
+	}
+
+	return keys, nil
+}
+
+func (storage *S3Storage) ModTime(key string, bucket string) (time.Time, error) {
+	output, err := storage.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(storage.bucket),
+		Key:    aws.String(storage.objectKey(key, bucket)),
+	})
+
+	if err != nil {
+		return time.Time{}, storage.wrapError("modtime", key, bucket, err)
+	}
+
+	return aws.ToTime(output.LastModified), nil
+}
+
+func (storage *S3Storage) Checksum(key string, bucket string) (string, error) {
+	output, err := storage.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(storage.bucket),
+		Key:    aws.String(storage.objectKey(key, bucket)),
+	})
+
+	if err != nil {
+		return "", storage.wrapError("checksum", key, bucket, err)
+	}
+
+	return output.Metadata[s3ChecksumMetadata], nil
+}
+
+func (storage *S3Storage) Quarantine(key string, bucket string) error {
+	_, err := storage.client.CopyObject(context.Background(), &s3.CopyObjectInput{
+		Bucket:     aws.String(storage.bucket),
+		CopySource: aws.String(storage.bucket + "/" + storage.objectKey(key, bucket)),
+		Key:        aws.String("quarantine/" + storage.objectKey(key, bucket)),
+	})
+
+	if err != nil {
+		return storage.wrapError("quarantine", key, bucket, err)
+	}
+
+	return storage.Remove(key, bucket)
+}
+
+func (storage *S3Storage) PresignURL(key string, bucket string, filename string, expiry time.Duration) (string, error) {
+	// The url would only lead to an error page of the storage otherwise
+	_, err := storage.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(storage.bucket),
+		Key:    aws.String(storage.objectKey(key, bucket)),
+	})
+
+	if err != nil {
+		return "", storage.wrapError("presign", key, bucket, err)
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(storage.bucket),
+		Key:    aws.String(storage.objectKey(key, bucket)),
+	}
+
+	if filename != "" {
+		input.ResponseContentDisposition = aws.String(FormatContentDisposition(filename))
+	}
+
+	request, err := storage.presigner.PresignGetObject(
+		context.Background(),
+		input,
+		s3.WithPresignExpires(expiry),
+	)
+
+	if err != nil {
+		return "", storage.wrapError("presign", key, bucket, err)
+	}
+
+	return request.URL, nil
+}
+
+func (storage *S3Storage) objectKey(key string, bucket string) string {
+	return bucket + "/" + key
+}
+
+// wrapError converts missing objects into not-exist errors, so that
+// callers can handle them the same way as for file storage
+func (storage *S3Storage) wrapError(operation string, key string, bucket string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	// Head requests don't have a body, so they report "NotFound" instead
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+
+	if errors.As(err, &noSuchKey) || errors.As(err, &notFound) {
+		err = os.ErrNotExist
+	}
+
+	return &os.PathError{
+		Op:   operation,
+		Path: storage.objectKey(key, bucket),
+		Err:  err,
+	}
+}
+
+// s3ObjectReader reads an object in ranges, starting a new
+// request from the current offset whenever it was seeked
+type s3ObjectReader struct {
+	storage *S3Storage
+	key     string
+	bucket  string
+	size    int64
+	offset  int64
+	body    io.ReadCloser
+}
+
+func (reader *s3ObjectReader) Read(p []byte) (int, error) {
+	if reader.offset >= reader.size {
+		return 0, io.EOF
+	}
+
+	if reader.body == nil {
+		output, err := reader.storage.client.GetObject(context.Background(), &s3.GetObjectInput{
+			Bucket: aws.String(reader.storage.bucket),
+			Key:    aws.String(reader.storage.objectKey(reader.key, reader.bucket)),
+			Range:  aws.String(fmt.Sprintf("bytes=%d-", reader.offset)),
+		})
+
+		if err != nil {
+			return 0, reader.storage.wrapError("read", reader.key, reader.bucket, err)
+		}
+
+		reader.body = output.Body
+	}
+
+	n, err := reader.body.Read(p)
+	reader.offset += int64(n)
+	return n, err
+}
+
+func (reader *s3ObjectReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += reader.offset
+	case io.SeekEnd:
+		offset += reader.size
+	}
+
+	if offset < 0 {
+		return 0, fmt.Errorf("seek to negative offset %d", offset)
+	}
+
+	if offset != reader.offset {
+		reader.Close()
+		reader.offset = offset
+	}
+
+	return offset, nil
+}
+
+func (reader *s3ObjectReader) Close() error {
+	if reader.body == nil {
+		return nil
+	}
+
+	err := reader.body.Close()
+	reader.body = nil
+	return err
+}
@@ -0,0 +1,208 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testStorage(t *testing.T, storage Storage) {
+	data := []byte("package")
+
+	if err := storage.SaveBeatmapPackage(1, data); err != nil {
+		t.Fatalf("failed to save package: %s", err)
+	}
+
+	stored, err := storage.GetBeatmapPackage(1)
+	if err != nil {
+		t.Fatalf("failed to read package: %s", err)
+	}
+
+	if !bytes.Equal(stored, data) {
+		t.Fatalf("expected %q, got %q", data, stored)
+	}
+
+	reader, err := storage.OpenBeatmapPackage(1, false)
+	if err != nil {
+		t.Fatalf("failed to open package: %s", err)
+	}
+
+	if _, err = reader.Seek(3, io.SeekStart); err != nil {
+		t.Fatalf("failed to seek package: %s", err)
+	}
+
+	stored, err = io.ReadAll(reader)
+	reader.Close()
+
+	if err != nil {
+		t.Fatalf("failed to read opened package: %s", err)
+	}
+
+	if !bytes.Equal(stored, data[3:]) {
+		t.Fatalf("expected %q, got %q", data[3:], stored)
+	}
+
+	// The no-video package is optional
+	if err = storage.RemoveBeatmapPackage(1); err != nil {
+		t.Fatalf("failed to remove package: %s", err)
+	}
+
+	if _, err = storage.GetBeatmapPackage(1); !os.IsNotExist(err) {
+		t.Fatalf("expected not-exist error, got %v", err)
+	}
+}
+
+func TestFileStorage(t *testing.T) {
+	storage := NewFileStorage(t.TempDir())
+	testStorage(t, storage)
+
+	_, err := storage.GetBeatmapPackageURL(1, false, "", time.Minute)
+	if !errors.Is(err, ErrPresignNotSupported) {
+		t.Fatalf("expected presigning to be unsupported, got %v", err)
+	}
+}
+
+func TestFileStorageVerify(t *testing.T) {
+	dataPath := t.TempDir()
+	backend := &FileStorage{dataPath: dataPath}
+
+	if err := backend.Save("1", "replays", []byte("replay")); err != nil {
+		t.Fatalf("failed to save replay: %s", err)
+	}
+
+	if err := VerifyObject(backend, "1", "replays"); err != nil {
+		t.Fatalf("expected replay to be valid, got %v", err)
+	}
+
+	// Only the object itself is listed
+	keys, err := backend.List("replays")
+	if err != nil || len(keys) != 1 || keys[0] != "1" {
+		t.Fatalf("unexpected keys %v: %v", keys, err)
+	}
+
+	info, err := os.Stat(filepath.Join(dataPath, "replays", "1"))
+	if err != nil || info.Mode().Perm() != fileStorageFileMode {
+		t.Fatalf("unexpected file mode: %v", err)
+	}
+
+	// Simulate a save, that was interrupted before the checksum was renamed
+	objectPath := filepath.Join(dataPath, "replays", "1")
+	err = os.WriteFile(objectPath, []byte("new replay"), fileStorageFileMode)
+	if err != nil {
+		t.Fatalf("failed to replace replay: %s", err)
+	}
+
+	checksumInfo, err := os.Stat(objectPath + fileStorageChecksumSuffix)
+	if err != nil {
+		t.Fatalf("failed to stat checksum: %s", err)
+	}
+
+	later := checksumInfo.ModTime().Add(time.Second)
+	if err = os.Chtimes(objectPath, later, later); err != nil {
+		t.Fatalf("failed to update replay mtime: %s", err)
+	}
+
+	if err = VerifyObject(backend, "1", "replays"); !errors.Is(err, ErrChecksumPending) {
+		t.Fatalf("expected pending checksum, got %v", err)
+	}
+
+	// Simulate a truncated write, that left the mtime as it was
+	err = os.WriteFile(objectPath, []byte("rep"), fileStorageFileMode)
+	if err != nil {
+		t.Fatalf("failed to corrupt replay: %s", err)
+	}
+
+	if err = os.Chtimes(objectPath, checksumInfo.ModTime(), checksumInfo.ModTime()); err != nil {
+		t.Fatalf("failed to reset replay mtime: %s", err)
+	}
+
+	if err = VerifyObject(backend, "1", "replays"); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+
+	if err = backend.Quarantine("1", "replays"); err != nil {
+		t.Fatalf("failed to quarantine replay: %s", err)
+	}
+
+	if _, err = backend.Read("1", "replays"); !os.IsNotExist(err) {
+		t.Fatalf("expected replay to be moved, got %v", err)
+	}
+
+	if _, err = os.Stat(filepath.Join(dataPath, "quarantine", "replays", "1")); err != nil {
+		t.Fatalf("expected replay in quarantine: %s", err)
+	}
+}
+
+// Runs against an s3-compatible service like minio, if configured through
+// HEXAGON_TEST_S3_ENDPOINT, _BUCKET, _ACCESS_KEY & _SECRET_KEY
+func TestS3Storage(t *testing.T) {
+	endpoint := os.Getenv("HEXAGON_TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("HEXAGON_TEST_S3_ENDPOINT is not set")
+	}
+
+	backend, err := NewS3Storage(&S3Configuration{
+		Endpoint:  endpoint,
+		Region:    "us-east-1",
+		Bucket:    os.Getenv("HEXAGON_TEST_S3_BUCKET"),
+		AccessKey: os.Getenv("HEXAGON_TEST_S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("HEXAGON_TEST_S3_SECRET_KEY"),
+	})
+
+	if err != nil {
+		t.Fatalf("failed to create s3 storage: %s", err)
+	}
+
+	storage := NewObjectStorage(backend, t.TempDir())
+	testStorage(t, storage)
+
+	// Missing packages are served by the server, which responds with 404
+	_, err = storage.GetBeatmapPackageURL(1, false, "1.zip", time.Minute)
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected not-exist error for removed package, got %v", err)
+	}
+
+	if err = storage.SaveBeatmapPackage(1, []byte("package")); err != nil {
+		t.Fatalf("failed to save package: %s", err)
+	}
+	defer storage.RemoveBeatmapPackage(1)
+
+	url, err := storage.GetBeatmapPackageURL(1, false, "1.zip", time.Minute)
+	if err != nil || url == "" {
+		t.Fatalf("failed to presign package url: %v", err)
+	}
+}
+
+func TestFormatContentDisposition(t *testing.T) {
+	tests := []struct {
+		Filename string
+		Expected string
+	}{
+		{
+			"1 Artist - Title.zip",
+			"attachment; filename=\"1 Artist - Title.zip\"; filename*=UTF-8''1%20Artist%20-%20Title.zip",
+		},
+		{
+			"2 \"Quoted\" \\ 100%.zip",
+			"attachment; filename=\"2 _Quoted_ _ 100_.zip\"; filename*=UTF-8''2%20%22Quoted%22%20%5C%20100%25.zip",
+		},
+		{
+			"3 ア.zip",
+			"attachment; filename=\"3 _.zip\"; filename*=UTF-8''3%20%E3%82%A2.zip",
+		},
+		{
+			"4 a\r\nb.zip",
+			"attachment; filename=\"4 a__b.zip\"; filename*=UTF-8''4%20a%0D%0Ab.zip",
+		},
+	}
+
+	for _, test := range tests {
+		if header := FormatContentDisposition(test.Filename); header != test.Expected {
+			t.Errorf("expected %s, got %s", test.Expected, header)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package common
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// NewTestRedisState connects to the redis server configured through
+// HEXAGON_TEST_REDIS_HOST, or skips the test if there is none. The
+// selected database is flushed before the test.
+func NewTestRedisState(t testing.TB) *State {
+	host := os.Getenv("HEXAGON_TEST_REDIS_HOST")
+	if host == "" {
+		t.Skip("HEXAGON_TEST_REDIS_HOST is not set")
+	}
+
+	ctx := context.Background()
+	rdb, err := CreateRedisSession(ctx, &RedisConfiguration{Host: host, Port: 6379, Database: 15})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = rdb.FlushDB(ctx).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &State{Redis: rdb, RedisContext: &ctx}
+}
+
+// NewTestDatabaseState connects to the database configured through
+// HEXAGON_TEST_DATABASE_HOST, _USERNAME, _PASSWORD & _NAME and to the redis
+// server of NewTestRedisState, or skips the test if either is missing. The database needs the schema of
+// hexagon-deploy, pending migrations are applied to it. Everything the test
+// writes into the database is rolled back afterwards.
+func NewTestDatabaseState(t testing.TB) *State {
+	host := os.Getenv("HEXAGON_TEST_DATABASE_HOST")
+	if host == "" {
+		t.Skip("HEXAGON_TEST_DATABASE_HOST is not set")
+	}
+
+	state := NewTestRedisState(t)
+
+	db, err := CreateDatabaseSession(&DatabaseConfiguration{
+		Host:     host,
+		Port:     5432,
+		Username: os.Getenv("HEXAGON_TEST_DATABASE_USERNAME"),
+		Password: os.Getenv("HEXAGON_TEST_DATABASE_PASSWORD"),
+		Database: os.Getenv("HEXAGON_TEST_DATABASE_NAME"),
+		MaxIdle:  2,
+		MaxOpen:  2,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state.Database = db
+
+	if _, err = RunMigrations(state); err != nil {
+		t.Fatal(err)
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		t.Fatal(tx.Error)
+	}
+
+	t.Cleanup(func() {
+		tx.Rollback()
+
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	state.Database = tx
+	return state
+}
+
+// FakeAudioProcessor is used in place of ffmpeg. It records
+// every requested snippet and returns a fixed result.
+type FakeAudioProcessor struct {
+	AudioDuration time.Duration
+	Result        []byte
+	Err           error
+	Snippets      []AudioSnippetOptions
+}
+
+func (processor *FakeAudioProcessor) Duration(audio []byte) (time.Duration, error) {
+	return processor.AudioDuration, processor.Err
+}
+
+func (processor *FakeAudioProcessor) Snippet(audio []byte, options AudioSnippetOptions) ([]byte, error) {
+	processor.Snippets = append(processor.Snippets, options)
+	return processor.Result, processor.Err
+}
@@ -36,12 +36,40 @@ if [ -z ${POSTGRES_DB+x} ]; then
     POSTGRES_DB=${POSTGRES_USER}
 fi
 
+if [ -z ${STORAGE_BACKEND+x} ]; then
+    STORAGE_BACKEND="file"
+fi
+
+if [ -z ${S3_REGION+x} ]; then
+    S3_REGION="us-east-1"
+fi
+
+if [ -z ${S3_BUCKET+x} ]; then
+    S3_BUCKET="hexagon"
+fi
+
+if [ -z ${AUDIO_PROCESSOR+x} ]; then
+    AUDIO_PROCESSOR="ffmpeg"
+fi
+
+if [ -z ${HSCORE_WORKERS+x} ]; then
+    HSCORE_WORKERS=2
+fi
+
 # Run hexagon
 go run . --hnet-host ${HNET_HOST} \
          --hnet-port ${HNET_PORT} \
          --hscore-host ${HSCORE_HOST} \
          --hscore-port ${HSCORE_PORT} \
+         --hscore-workers ${HSCORE_WORKERS} \
          --data-path ${DATA_PATH} \
+         --storage ${STORAGE_BACKEND} \
+         --s3-endpoint "${S3_ENDPOINT}" \
+         --s3-region ${S3_REGION} \
+         --s3-bucket ${S3_BUCKET} \
+         --s3-access-key "${S3_ACCESS_KEY}" \
+         --s3-secret-key "${S3_SECRET_KEY}" \
+         --audio ${AUDIO_PROCESSOR} \
          --db-host ${POSTGRES_HOST} \
          --db-port ${POSTGRES_PORT} \
          --db-username ${POSTGRES_USER} \
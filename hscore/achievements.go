@@ -0,0 +1,109 @@
+package hscore
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hexis-revival/hexagon/common"
+)
+
+// UnlockAchievements evaluates all achievement rules against a submitted
+// score & stores the ones the user has newly unlocked.
+func UnlockAchievements(score *common.Score, beatmap *common.Beatmap, user *common.User, server *ScoreServer) ([]*AchievementResponse, error) {
+	userAchievements, err := common.FetchUserAchievements(user.Id, server.State)
+	if err != nil {
+		return nil, err
+	}
+
+	unlocked := make(map[string]bool, len(userAchievements))
+
+	for _, userAchievement := range userAchievements {
+		unlocked[userAchievement.Name] = true
+	}
+
+	ctx := &common.AchievementContext{
+		Score:   score,
+		Beatmap: beatmap,
+		Stats:   &user.Stats,
+	}
+
+	achievements := common.CheckAchievements(ctx, unlocked)
+	responses := make([]*AchievementResponse, 0, len(achievements))
+	unlockedAt := time.Now()
+
+	for _, achievement := range achievements {
+		created, err := common.CreateUserAchievement(&common.UserAchievement{
+			UserId:     user.Id,
+			Name:       achievement.Name,
+			ScoreId:    &score.Id,
+			UnlockedAt: unlockedAt,
+		}, server.State)
+
+		if err != nil {
+			return responses, err
+		}
+
+		if !created {
+			// A concurrent submission has unlocked it first
+			continue
+		}
+
+		responses = append(responses, NewAchievementResponse(achievement, &unlockedAt))
+
+		server.Logger.Infof(
+			"(%s) Unlocked achievement '%s'",
+			user.Name, achievement.Title,
+		)
+	}
+
+	return responses, nil
+}
+
+// UserAchievementsHandler lists all achievements, along with the
+// time the requested user has unlocked them, if they did.
+func UserAchievementsHandler(ctx *Context) {
+	vars := mux.Vars(ctx.Request)
+
+	userId, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		ctx.Response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	user, err := common.FetchUserById(userId, ctx.Server.State)
+	if err != nil || user.Restricted {
+		ctx.Response.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	userAchievements, err := common.FetchUserAchievements(user.Id, ctx.Server.State)
+	if err != nil {
+		ctx.Server.Logger.Warningf("Error fetching achievements: %v", err)
+		ctx.Response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	unlockedAt := make(map[string]time.Time, len(userAchievements))
+
+	for _, userAchievement := range userAchievements {
+		unlockedAt[userAchievement.Name] = userAchievement.UnlockedAt
+	}
+
+	responses := make([]*AchievementResponse, 0, len(common.Achievements))
+
+	for _, achievement := range common.Achievements {
+		response := NewAchievementResponse(achievement, nil)
+
+		if unlockedTime, ok := unlockedAt[achievement.Name]; ok {
+			response.UnlockedAt = &unlockedTime
+		}
+
+		responses = append(responses, response)
+	}
+
+	ctx.Response.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(ctx.Response).Encode(responses)
+}
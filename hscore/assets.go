@@ -0,0 +1,164 @@
+package hscore
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hexis-revival/hexagon/common"
+)
+
+const (
+	ThumbnailLargeWidth  = 160
+	ThumbnailLargeHeight = 120
+	ThumbnailSmallWidth  = 80
+	ThumbnailSmallHeight = 60
+)
+
+// Assets change whenever a beatmapset gets updated, so clients may keep
+// them, but have to revalidate them using the etag before every use.
+// Placeholders are replaced once the real thumbnail is available.
+const AssetCacheControl = "public, no-cache"
+
+var placeholderColor = color.RGBA{R: 40, G: 40, B: 40, A: 255}
+
+var placeholderThumbnails = map[bool][]byte{}
+var placeholderThumbnailsMutex sync.Mutex
+
+func BeatmapThumbnailHandler(ctx *Context) {
+	vars := mux.Vars(ctx.Request)
+
+	setId, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		ctx.Response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	large := vars["size"] == "large"
+	storage := ctx.Server.State.Storage
+
+	thumbnail, err := storage.GetBeatmapsetThumbnail(setId, large)
+	if os.IsNotExist(err) {
+		// Beatmapset has no background, or does not exist
+		ServePlaceholderThumbnail(ctx, large)
+		return
+	}
+
+	if err != nil {
+		ctx.Server.Logger.Errorf("Failed to read thumbnail of set %d: %s", setId, err)
+		ctx.Response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	checksum, err := storage.BeatmapsetThumbnailChecksum(setId, large)
+	if err != nil {
+		ctx.Server.Logger.Warningf("Failed to read thumbnail checksum of set %d: %s", setId, err)
+	}
+
+	ServeAsset(ctx, thumbnail, checksum, http.DetectContentType(thumbnail))
+}
+
+func ServePlaceholderThumbnail(ctx *Context, large bool) {
+	thumbnail, err := PlaceholderThumbnail(large)
+	if err != nil {
+		ctx.Server.Logger.Errorf("Failed to create placeholder thumbnail: %s", err)
+		ctx.Response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	etag := "placeholder_small"
+	if large {
+		etag = "placeholder_large"
+	}
+
+	ServeAsset(ctx, thumbnail, etag, "image/png")
+}
+
+func BeatmapPreviewHandler(ctx *Context) {
+	vars := mux.Vars(ctx.Request)
+
+	setId, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		ctx.Response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	storage := ctx.Server.State.Storage
+
+	preview, err := storage.GetBeatmapsetPreview(setId)
+	if os.IsNotExist(err) {
+		ctx.Response.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		ctx.Server.Logger.Errorf("Failed to read preview of set %d: %s", setId, err)
+		ctx.Response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	checksum, err := storage.BeatmapsetPreviewChecksum(setId)
+	if err != nil {
+		ctx.Server.Logger.Warningf("Failed to read preview checksum of set %d: %s", setId, err)
+	}
+
+	ServeAsset(ctx, preview, checksum, "audio/mpeg")
+}
+
+// ServeAsset writes a static asset, using its checksum as the etag so
+// that conditional requests can be answered with "304 Not Modified".
+// Objects that were stored without a checksum are hashed instead.
+func ServeAsset(ctx *Context, data []byte, checksum string, contentType string) {
+	if checksum == "" {
+		checksum = common.ObjectChecksum(data)
+	}
+
+	ctx.Response.Header().Set("Content-Type", contentType)
+	ctx.Response.Header().Set("Cache-Control", AssetCacheControl)
+	ctx.Response.Header().Set("ETag", fmt.Sprintf("\"%s\"", checksum))
+
+	http.ServeContent(
+		ctx.Response,
+		ctx.Request,
+		"",
+		time.Time{},
+		bytes.NewReader(data),
+	)
+}
+
+// PlaceholderThumbnail returns a plain png thumbnail, which is served for
+// beatmapsets without a background image
+func PlaceholderThumbnail(large bool) ([]byte, error) {
+	placeholderThumbnailsMutex.Lock()
+	defer placeholderThumbnailsMutex.Unlock()
+
+	if thumbnail, ok := placeholderThumbnails[large]; ok {
+		return thumbnail, nil
+	}
+
+	width, height := ThumbnailSmallWidth, ThumbnailSmallHeight
+	if large {
+		width, height = ThumbnailLargeWidth, ThumbnailLargeHeight
+	}
+
+	rect := image.Rect(0, 0, width, height)
+	img := image.NewRGBA(rect)
+	draw.Draw(img, rect, &image.Uniform{placeholderColor}, image.Point{}, draw.Src)
+
+	buffer := bytes.Buffer{}
+	if err := png.Encode(&buffer, img); err != nil {
+		return nil, err
+	}
+
+	placeholderThumbnails[large] = buffer.Bytes()
+	return placeholderThumbnails[large], nil
+}
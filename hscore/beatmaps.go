@@ -7,9 +7,10 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"io"
 	"math"
 	"net/http"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -194,15 +195,31 @@ func RemoveInactiveBeatmaps(user *common.User, server *ScoreServer) error {
 			return err
 		}
 
-		server.State.Storage.RemoveBeatmapPreview(beatmapset.Id)
-		server.State.Storage.RemoveBeatmapThumbnail(beatmapset.Id)
-		server.State.Storage.RemoveBeatmapPackage(beatmapset.Id)
+		RemoveBeatmapsetFiles(&beatmapset, server)
+	}
+	return nil
+}
 
-		for _, beatmap := range beatmapset.Beatmaps {
-			server.State.Storage.RemoveBeatmapFile(beatmap.Id)
+// RemoveBeatmapsetFiles removes all stored objects of a beatmapset.
+// Failures are only logged, since the objects can be cleaned up later
+// using the storage gc command.
+func RemoveBeatmapsetFiles(beatmapset *common.Beatmapset, server *ScoreServer) {
+	results := []error{
+		server.State.Storage.RemoveBeatmapsetPreview(beatmapset.Id),
+		server.State.Storage.RemoveBeatmapsetThumbnail(beatmapset.Id),
+		server.State.Storage.RemoveBeatmapPackage(beatmapset.Id),
+	}
+
+	for _, beatmap := range beatmapset.Beatmaps {
+		results = append(results, server.State.Storage.RemoveBeatmapFile(beatmap.Id))
+	}
+
+	for _, err := range results {
+		// Inactive beatmapsets may never have had any files uploaded
+		if err != nil && !os.IsNotExist(err) {
+			server.Logger.Warningf("Failed to remove files of beatmapset %d: %s", beatmapset.Id, err)
 		}
 	}
-	return nil
 }
 
 func RemainingBeatmapUploads(user *common.User, server *ScoreServer) (int, error) {
@@ -233,25 +250,29 @@ func ProcessUploadPackage(request *BeatmapUploadRequest, server *ScoreServer) (m
 	files := make(map[string][]byte, 0)
 	beatmaps := make(map[string]*hbxml.Beatmap, 0)
 
+	err := ValidatePackageEntries(request.Package.File)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	for _, file := range request.Package.File {
-		fileHandle, err := request.Package.Open(file.Name)
-		if err != nil {
-			return nil, nil, fmt.Errorf("failed to open file: %s", err)
+		if file.FileInfo().IsDir() || IsIgnoredPackageFile(file.Name) {
+			continue
 		}
 
-		files[file.Name], err = io.ReadAll(fileHandle)
+		files[file.Name], err = ReadPackageFile(file)
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to read file: %s", err)
+			return nil, nil, err
 		}
 
-		if !strings.HasSuffix(file.Name, ".hbxml") {
+		if !HasExtension(file.Name, []string{".hbxml"}) {
 			continue
 		}
 
 		reader := bytes.NewReader(files[file.Name])
 		beatmaps[file.Name], err = hbxml.NewBeatmap(reader)
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to parse beatmap: %s", err)
+			return nil, nil, NewPackageError(PackageErrorInvalidBeatmap, "failed to parse beatmap: %s", err)
 		}
 	}
 
@@ -264,7 +285,7 @@ func ProcessUploadPackage(request *BeatmapUploadRequest, server *ScoreServer) (m
 	}
 
 	if packageSize > int(maximumSize) {
-		return nil, nil, errors.New("package size limit exceeded")
+		return nil, nil, NewPackageError(PackageErrorTooLarge, "package size limit exceeded")
 	}
 
 	return files, beatmaps, nil
@@ -336,6 +357,8 @@ func GetMaximumDrainLength(beatmapObjects map[string]*hbxml.Beatmap) int {
 }
 
 func HasExtension(filename string, extensions []string) bool {
+	filename = strings.ToLower(filename)
+
 	for _, extension := range extensions {
 		if strings.HasSuffix(filename, extension) {
 			return true
@@ -384,41 +407,83 @@ func UpdateBeatmapMetadata(beatmap *common.Beatmap, beatmapObject *hbxml.Beatmap
 	return common.UpdateBeatmap(beatmap, server.State)
 }
 
+var videoFileExtensions = []string{
+	".wmv", ".flv", ".mp4", ".avi", ".mkv", ".webm",
+}
+
 func UploadBeatmapPackage(setId int, files map[string][]byte, server *ScoreServer) error {
-	allowedFileExtensions := []string{
-		".hbxml", ".hxz", ".png", ".jpg", ".jpeg", ".mp3",
-		".ogg", ".wav", ".flac", ".wmv", ".flv", ".mp4",
-		".avi", ".mkv", ".webm", ".txt", ".ini",
+	data, err := CreateBeatmapPackage(files, func(filename string) bool {
+		return HasExtension(filename, allowedPackageExtensions)
+	})
+
+	if err != nil {
+		return err
 	}
 
-	buffer := bytes.Buffer{}
-	zipWriter := zip.NewWriter(&buffer)
+	err = server.State.Storage.SaveBeatmapPackage(setId, data)
+	if err != nil {
+		return err
+	}
 
-	for filename, file := range files {
-		if !HasExtension(filename, allowedFileExtensions) {
-			continue
+	if !ContainsVideo(files) {
+		return nil
+	}
+
+	data, err = CreateBeatmapPackage(files, func(filename string) bool {
+		return HasExtension(filename, allowedPackageExtensions) &&
+			!HasExtension(filename, videoFileExtensions)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return server.State.Storage.SaveBeatmapPackageNoVideo(setId, data)
+}
+
+// CreateBeatmapPackage zips all files accepted by the filter. Files are
+// added in order of their name, so equal files result in equal packages.
+func CreateBeatmapPackage(files map[string][]byte, filter func(filename string) bool) ([]byte, error) {
+	filenames := make([]string, 0, len(files))
+
+	for filename := range files {
+		if filter(filename) {
+			filenames = append(filenames, filename)
 		}
+	}
 
+	sort.Strings(filenames)
+
+	buffer := bytes.Buffer{}
+	zipWriter := zip.NewWriter(&buffer)
+
+	for _, filename := range filenames {
 		fileWriter, err := zipWriter.Create(filename)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		_, err = fileWriter.Write(file)
+		_, err = fileWriter.Write(files[filename])
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
 	err := zipWriter.Close()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return server.State.Storage.SaveBeatmapPackage(
-		setId,
-		buffer.Bytes(),
-	)
+	return buffer.Bytes(), nil
+}
+
+func ContainsVideo(files map[string][]byte) bool {
+	for filename := range files {
+		if HasExtension(filename, videoFileExtensions) {
+			return true
+		}
+	}
+	return false
 }
 
 func UploadBeatmapFiles(beatmapFiles map[int][]byte, server *ScoreServer) error {
@@ -433,11 +498,12 @@ func UploadBeatmapFiles(beatmapFiles map[int][]byte, server *ScoreServer) error
 }
 
 func UploadAudioPreview(setId int, files map[string][]byte, general hbxml.General, server *ScoreServer) error {
-	offset := general.PreviewOffset / 1000
+	offset := time.Duration(general.PreviewOffset) * time.Millisecond
 	audioFilename := general.AudioFilename
 
 	if offset < 0 {
-		return errors.New("invalid preview offset")
+		// Beatmaps without a preview point use -1
+		offset = 0
 	}
 
 	audio, ok := files[audioFilename]
@@ -445,19 +511,17 @@ func UploadAudioPreview(setId int, files map[string][]byte, general hbxml.Genera
 		return errors.New("audio file not found")
 	}
 
-	audioSnippet, err := common.ExtractAudioSnippet(
+	audioSnippet, err := common.CreateAudioPreview(
 		audio,
 		offset,
-		10,
-		64,
-		server.State.Storage,
+		server.State.Audio,
 	)
 
 	if err != nil {
 		return err
 	}
 
-	return server.State.Storage.SaveBeatmapPreview(setId, audioSnippet)
+	return server.State.Storage.SaveBeatmapsetPreview(setId, audioSnippet)
 }
 
 func UploadBeatmapThumbnail(setId int, files map[string][]byte, events hbxml.Events, server *ScoreServer) error {
@@ -476,8 +540,8 @@ func UploadBeatmapThumbnail(setId int, files map[string][]byte, events hbxml.Eve
 
 	generator := common.NewImageGenerator(common.ImageGenerator{})
 	generator.Scaler = "CatmullRom"
-	generator.Width = 160
-	generator.Height = 120
+	generator.Width = ThumbnailLargeWidth
+	generator.Height = ThumbnailLargeHeight
 
 	image, err := generator.NewImageFromByteArray(imageData)
 	if err != nil {
@@ -489,20 +553,20 @@ func UploadBeatmapThumbnail(setId int, files map[string][]byte, events hbxml.Eve
 		return err
 	}
 
-	generator.Width = 80
-	generator.Height = 60
+	generator.Width = ThumbnailSmallWidth
+	generator.Height = ThumbnailSmallHeight
 
 	smallImage, err := generator.CreateThumbnail(image)
 	if err != nil {
 		return err
 	}
 
-	err = server.State.Storage.SaveBeatmapThumbnail(setId, largeImage, true)
+	err = server.State.Storage.SaveBeatmapsetThumbnail(setId, largeImage, true)
 	if err != nil {
 		return err
 	}
 
-	err = server.State.Storage.SaveBeatmapThumbnail(setId, smallImage, false)
+	err = server.State.Storage.SaveBeatmapsetThumbnail(setId, smallImage, false)
 	if err != nil {
 		return err
 	}
@@ -695,7 +759,18 @@ func BeatmapUploadHandler(ctx *Context) {
 
 	if err != nil {
 		response.Success = false
-		ctx.Server.Logger.Warningf("[Beatmap Submission] %s", err)
+		response.ErrorCode = PackageErrorInvalid
+		response.ErrorMessage = err.Error()
+
+		var packageError *PackageError
+		if errors.As(err, &packageError) {
+			response.ErrorCode = packageError.Code
+		}
+
+		ctx.Server.Logger.Warningf(
+			"[Beatmap Submission] Rejected package of set %d by '%s' (error %d): %s",
+			beatmapset.Id, user.Name, response.ErrorCode, response.ErrorMessage,
+		)
 		ctx.Response.Write([]byte(response.Write()))
 		return
 	}
@@ -714,14 +789,10 @@ func BeatmapUploadHandler(ctx *Context) {
 
 	ctx.Server.Logger.Debugf("[Beatmap Submission] Got %d files in package.", len(files))
 
-	var general hbxml.General
 	var metadata hbxml.Meta
-	var events hbxml.Events
 
 	for filename, beatmap := range beatmapObjects {
-		general = beatmap.General
 		metadata = beatmap.Meta
-		events = beatmap.Events
 
 		err = UpdateBeatmapMetadata(
 			beatmapMap[filename],
@@ -745,6 +816,8 @@ func BeatmapUploadHandler(ctx *Context) {
 		beatmapIdMap[beatmap.Id] = files[beatmap.Filename]
 	}
 
+	beatmapset.HasVideo = ContainsVideo(files)
+
 	err = UpdateBeatmapsetMetadata(
 		beatmapset,
 		metadata,
@@ -774,20 +847,28 @@ func BeatmapUploadHandler(ctx *Context) {
 		return
 	}
 
-	err = UploadAudioPreview(beatmapset.Id, files, general, ctx.Server)
+	// Previews, thumbnails & star ratings are generated in the background
+	// The upload itself is stored already, so missing jobs can be queued
+	// again with the "jobs enqueue" command instead of failing it
+	err = EnqueueBeatmapsetJobs(beatmapset, ctx.Server)
 	if err != nil {
-		response.Success = false
-		ctx.Server.Logger.Warningf("[Beatmap Submission] Failed to upload audio preview: %s", err)
-		ctx.Response.Write([]byte(response.Write()))
-		return
+		ctx.Server.Logger.Errorf(
+			"[Beatmap Submission] Failed to enqueue processing of beatmapset %d: %s",
+			beatmapset.Id, err,
+		)
 	}
 
-	err = UploadBeatmapThumbnail(beatmapset.Id, files, events, ctx.Server)
+	// Nominations only apply to the version that was nominated
+	nominated, err := IsBeatmapsetNominated(beatmapset, ctx.Server)
 	if err != nil {
-		response.Success = false
-		ctx.Server.Logger.Warningf("[Beatmap Submission] Failed to upload beatmap thumbnail: %s", err)
-		ctx.Response.Write([]byte(response.Write()))
-		return
+		ctx.Server.Logger.Warningf("[Beatmap Submission] Failed to fetch nominations: %s", err)
+	}
+
+	if nominated {
+		err = DisqualifyBeatmapset(beatmapset, nil, "Beatmapset was updated", ctx.Server)
+		if err != nil && !errors.Is(err, ErrInvalidStatus) {
+			ctx.Server.Logger.Warningf("[Beatmap Submission] Failed to reset nominations: %s", err)
+		}
 	}
 
 	ctx.Server.Logger.Infof(
@@ -844,7 +925,7 @@ func BeatmapGenTopicHandler(ctx *Context) {
 
 	if beatmapset.TopicId == nil {
 		pendingForum, err := common.FetchForumByName(
-			"Pending Beatmaps",
+			PendingForumName,
 			ctx.Server.State,
 		)
 
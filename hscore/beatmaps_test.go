@@ -0,0 +1,68 @@
+package hscore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hexis-revival/hbxml"
+	"github.com/hexis-revival/hexagon/common"
+)
+
+func TestUploadAudioPreview(t *testing.T) {
+	tests := []struct {
+		Name          string
+		PreviewOffset int
+		Offset        time.Duration
+	}{
+		{"preview point", 20000, 20 * time.Second},
+		{"unset preview point", -1, 0},
+		{"negative preview point", -2500, 0},
+		{"preview point past the end", 58000, 50 * time.Second},
+	}
+
+	files := map[string][]byte{"audio.mp3": []byte("audio")}
+
+	for _, test := range tests {
+		processor := &common.FakeAudioProcessor{
+			AudioDuration: 60 * time.Second,
+			Result:        []byte("preview"),
+		}
+
+		server := &ScoreServer{
+			Logger: common.CreateLogger("test", common.QUIET),
+			State: &common.State{
+				Storage: common.NewFileStorage(t.TempDir()),
+				Audio:   processor,
+			},
+		}
+
+		general := hbxml.General{AudioFilename: "audio.mp3", PreviewOffset: test.PreviewOffset}
+
+		if err := UploadAudioPreview(1, files, general, server); err != nil {
+			t.Fatalf("%s: failed to upload preview: %s", test.Name, err)
+		}
+
+		if len(processor.Snippets) != 1 || processor.Snippets[0].Offset != test.Offset {
+			t.Errorf("%s: expected snippet at %s, got %+v", test.Name, test.Offset, processor.Snippets)
+		}
+
+		preview, err := server.State.Storage.GetBeatmapsetPreview(1)
+		if err != nil || string(preview) != "preview" {
+			t.Errorf("%s: expected stored preview, got %q (%v)", test.Name, preview, err)
+		}
+	}
+
+	server := &ScoreServer{
+		Logger: common.CreateLogger("test", common.QUIET),
+		State: &common.State{
+			Storage: common.NewFileStorage(t.TempDir()),
+			Audio:   &common.FakeAudioProcessor{AudioDuration: time.Minute},
+		},
+	}
+
+	general := hbxml.General{AudioFilename: "missing.mp3"}
+
+	if err := UploadAudioPreview(1, files, general, server); err == nil {
+		t.Fatal("expected missing audio file to fail")
+	}
+}
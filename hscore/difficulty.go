@@ -0,0 +1,49 @@
+package hscore
+
+import (
+	"math"
+
+	"github.com/hexis-revival/hbxml"
+	"github.com/hexis-revival/hexagon/common"
+)
+
+// BeatmapDifficulty returns the effective difficulty settings
+// of a beatmap, with the offsets of the given mods applied.
+func BeatmapDifficulty(beatmap *common.Beatmap, mods *Mods) common.JudgementDifficulty {
+	if mods == nil {
+		mods = &Mods{}
+	}
+
+	return common.NewJudgementDifficulty(
+		beatmap.CS, beatmap.OD, beatmap.AR,
+		mods.CsOffset,
+		mods.OdOffset,
+		mods.ArOffset,
+		mods.PsOffset,
+	)
+}
+
+// CalculateBeatmapDifficulty computes the difficulty attributes of a beatmap,
+// which are mod-adjusted if any mods are given.
+func CalculateBeatmapDifficulty(beatmap *common.Beatmap, beatmapObject *hbxml.Beatmap, mods *Mods) *common.DifficultyAttributes {
+	return common.CalculateDifficulty(
+		ConvertHitObjects(beatmapObject),
+		BeatmapDifficulty(beatmap, mods),
+	)
+}
+
+// UpdateBeatmapDifficulty recalculates the star rating of a stored beatmap
+func UpdateBeatmapDifficulty(beatmap *common.Beatmap, server *ScoreServer) error {
+	beatmapObject, err := LoadBeatmapObject(beatmap, server)
+	if err != nil {
+		return err
+	}
+
+	attributes := CalculateBeatmapDifficulty(beatmap, beatmapObject, nil)
+	beatmap.SR = RoundStarRating(attributes.StarRating)
+	return common.UpdateBeatmap(beatmap, server.State)
+}
+
+func RoundStarRating(starRating float64) float64 {
+	return math.Round(starRating*100) / 100
+}
@@ -0,0 +1,237 @@
+package hscore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hexis-revival/hexagon/common"
+)
+
+// How long redirected package downloads stay valid
+const PackageURLExpiry = 15 * time.Minute
+
+func BeatmapDownloadHandler(ctx *Context) {
+	request, err := NewBeatmapDownloadRequest(ctx.Request)
+	if err != nil {
+		ctx.Server.Logger.Errorf("Failed to parse beatmap download request: %s", err)
+		ctx.Response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	user, success := AuthenticateUser(
+		request.Username,
+		request.Password,
+		ctx.Server,
+	)
+
+	if !success {
+		ctx.Server.Logger.Warningf("Failed to authenticate user '%s'", request.Username)
+		ctx.Response.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	beatmapset, err := common.FetchBeatmapsetById(request.SetId, ctx.Server.State)
+	if err != nil || beatmapset.Status == common.BeatmapStatusNotSubmitted {
+		ctx.Response.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch beatmapset.AvailabilityStatus {
+	case common.BeatmapHasDMCA:
+		ctx.Response.WriteHeader(http.StatusUnavailableForLegalReasons)
+		ctx.Response.Write([]byte(beatmapset.AvailabilityInfo))
+		return
+	case common.BeatmapHasInappropriateContent:
+		ctx.Response.WriteHeader(http.StatusForbidden)
+		ctx.Response.Write([]byte(beatmapset.AvailabilityInfo))
+		return
+	}
+
+	noVideo := request.NoVideo && beatmapset.HasVideo
+
+	// Redirect to the storage directly, if it is able to serve the package
+	url, err := PresignBeatmapPackage(beatmapset, noVideo, ctx.Server)
+	if err == nil {
+		CountBeatmapDownload(beatmapset, user, ctx)
+		http.Redirect(ctx.Response, ctx.Request, url, http.StatusFound)
+		return
+	}
+
+	if !errors.Is(err, common.ErrPresignNotSupported) && !os.IsNotExist(err) {
+		ctx.Server.Logger.Warningf("Failed to create package url: %s", err)
+	}
+
+	reader, noVideo, err := OpenBeatmapPackage(beatmapset.Id, noVideo, ctx.Server)
+	if err != nil {
+		ctx.Server.Logger.Warningf("Failed to open beatmap package: %s", err)
+		ctx.Response.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer reader.Close()
+
+	CountBeatmapDownload(beatmapset, user, ctx)
+	filename := FormatPackageFilename(beatmapset, noVideo)
+
+	ctx.Response.Header().Set("Content-Type", "application/zip")
+	ctx.Response.Header().Set("ETag", FormatPackageETag(beatmapset, noVideo, ctx.Server))
+	ctx.Response.Header().Set("Content-Disposition", common.FormatContentDisposition(filename))
+
+	// ServeContent takes care of range & conditional requests,
+	// and only reads the parts of the package that are requested
+	http.ServeContent(
+		ctx.Response,
+		ctx.Request,
+		filename,
+		beatmapset.LastUpdated,
+		reader,
+	)
+}
+
+// CountBeatmapDownload increments the downloads of a beatmapset, once
+// its package was found & the request starts a new download
+func CountBeatmapDownload(beatmapset *common.Beatmapset, user *common.User, ctx *Context) {
+	if !IsInitialDownload(ctx.Request) {
+		return
+	}
+
+	err := common.IncrementBeatmapsetDownloads(beatmapset.Id, ctx.Server.State)
+	if err != nil {
+		ctx.Server.Logger.Warningf("Failed to update beatmap downloads: %s", err)
+	}
+
+	ctx.Server.Logger.Debugf(
+		"Beatmap download for set %d by '%s'",
+		beatmapset.Id, user.Name,
+	)
+}
+
+// PresignBeatmapPackage creates a temporary url to the package of a
+// beatmapset, which falls back to the full package like OpenBeatmapPackage
+func PresignBeatmapPackage(beatmapset *common.Beatmapset, noVideo bool, server *ScoreServer) (string, error) {
+	storage := server.State.Storage
+
+	if noVideo {
+		filename := FormatPackageFilename(beatmapset, true)
+		url, err := storage.GetBeatmapPackageURL(beatmapset.Id, true, filename, PackageURLExpiry)
+
+		if !os.IsNotExist(err) {
+			return url, err
+		}
+
+		server.Logger.Warningf("No-video package of set %d is missing", beatmapset.Id)
+	}
+
+	filename := FormatPackageFilename(beatmapset, false)
+	return storage.GetBeatmapPackageURL(beatmapset.Id, false, filename, PackageURLExpiry)
+}
+
+// OpenBeatmapPackage opens the package of a beatmapset, optionally the
+// variant without video, which falls back to the full package if missing.
+// It returns whether the opened package is the variant without video.
+func OpenBeatmapPackage(setId int, noVideo bool, server *ScoreServer) (io.ReadSeekCloser, bool, error) {
+	if noVideo {
+		reader, err := server.State.Storage.OpenBeatmapPackage(setId, true)
+		if err == nil {
+			return reader, true, nil
+		}
+
+		server.Logger.Warningf("Failed to open no-video package of set %d: %s", setId, err)
+	}
+
+	reader, err := server.State.Storage.OpenBeatmapPackage(setId, false)
+	return reader, false, err
+}
+
+// FormatPackageETag uses the stored checksum of a package as its etag,
+// or the last update of the beatmapset, for packages without one
+func FormatPackageETag(beatmapset *common.Beatmapset, noVideo bool, server *ScoreServer) string {
+	checksum, err := server.State.Storage.BeatmapPackageChecksum(beatmapset.Id, noVideo)
+	if err != nil {
+		server.Logger.Warningf("Failed to read package checksum of set %d: %s", beatmapset.Id, err)
+	}
+
+	if checksum == "" {
+		checksum = fmt.Sprintf("%d-%d", beatmapset.Id, beatmapset.LastUpdated.Unix())
+
+		if noVideo {
+			checksum += "-novideo"
+		}
+	}
+
+	return fmt.Sprintf("\"%s\"", checksum)
+}
+
+// IsInitialDownload returns whether a request starts a new download,
+// so that resumed downloads & revalidations of a cached package are
+// not counted more than once
+func IsInitialDownload(request *http.Request) bool {
+	if request.Method != http.MethodGet {
+		return false
+	}
+
+	rangeHeader := request.Header.Get("Range")
+	if rangeHeader == "" {
+		conditional := request.Header.Get("If-None-Match") != "" ||
+			request.Header.Get("If-Modified-Since") != ""
+
+		return !conditional
+	}
+
+	return strings.HasPrefix(strings.TrimSpace(rangeHeader), "bytes=0-")
+}
+
+func FormatPackageFilename(beatmapset *common.Beatmapset, noVideo bool) string {
+	filename := fmt.Sprintf(
+		"%d %s - %s",
+		beatmapset.Id,
+		beatmapset.Artist,
+		beatmapset.Title,
+	)
+
+	if noVideo {
+		filename += " [no video]"
+	}
+
+	// Strip characters that are not allowed in filenames
+	filename = strings.Map(func(r rune) rune {
+		if strings.ContainsRune("\\/:*?\"<>|", r) || r < 32 {
+			return -1
+		}
+		return r
+	}, filename)
+
+	return filename + ".zip"
+}
+
+func NewBeatmapDownloadRequest(request *http.Request) (*BeatmapDownloadRequest, error) {
+	setId, err := strconv.Atoi(mux.Vars(request)["id"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid beatmapset id")
+	}
+
+	query := request.URL.Query()
+
+	username := query.Get("u")
+	if username == "" {
+		return nil, fmt.Errorf("missing username")
+	}
+
+	password := query.Get("p")
+	if password == "" {
+		return nil, fmt.Errorf("missing password")
+	}
+
+	return &BeatmapDownloadRequest{
+		Username: username,
+		Password: password,
+		SetId:    setId,
+		NoVideo:  query.Get("n") == "1",
+	}, nil
+}
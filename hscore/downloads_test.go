@@ -0,0 +1,39 @@
+package hscore
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestIsInitialDownload(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Method  string
+		Headers map[string]string
+		Initial bool
+	}{
+		{"download", http.MethodGet, nil, true},
+		{"head", http.MethodHead, nil, false},
+		{"range from start", http.MethodGet, map[string]string{"Range": "bytes=0-1023"}, true},
+		{"resumed", http.MethodGet, map[string]string{"Range": "bytes=1024-"}, false},
+		{"etag revalidation", http.MethodGet, map[string]string{"If-None-Match": "\"etag\""}, false},
+		{"date revalidation", http.MethodGet, map[string]string{"If-Modified-Since": "Mon, 19 Oct 2026 08:00:00 GMT"}, false},
+		{"resumed if unchanged", http.MethodGet, map[string]string{"Range": "bytes=1024-", "If-Range": "\"etag\""}, false},
+		{"conditional range from start", http.MethodGet, map[string]string{"Range": "bytes=0-", "If-None-Match": "\"etag\""}, true},
+	}
+
+	for _, test := range tests {
+		request, err := http.NewRequest(test.Method, "/d/1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for key, value := range test.Headers {
+			request.Header.Set(key, value)
+		}
+
+		if initial := IsInitialDownload(request); initial != test.Initial {
+			t.Errorf("%s: expected %t, got %t", test.Name, test.Initial, initial)
+		}
+	}
+}
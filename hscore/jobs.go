@@ -0,0 +1,200 @@
+package hscore
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hexis-revival/hbxml"
+	"github.com/hexis-revival/hexagon/common"
+)
+
+// How long a worker waits for a job, before checking for delayed jobs again
+const JobPollTimeout = 5 * time.Second
+
+type JobHandler func(job *common.Job, server *ScoreServer) error
+
+var jobHandlers = map[common.JobType]JobHandler{
+	common.JobTypeAudioPreview: AudioPreviewJob,
+	common.JobTypeThumbnail:    ThumbnailJob,
+	common.JobTypeDifficulty:   DifficultyJob,
+}
+
+// RunJobWorkers processes queued jobs with the given amount of workers,
+// and blocks until all of them have stopped
+func (server *ScoreServer) RunJobWorkers(count int) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			server.runJobWorker()
+		}()
+	}
+
+	server.Logger.Infof("[Jobs] Started %d workers", count)
+	wg.Wait()
+}
+
+func (server *ScoreServer) runJobWorker() {
+	for {
+		if _, err := common.PromoteDelayedJobs(server.State); err != nil {
+			server.Logger.Warningf("[Jobs] Failed to promote delayed jobs: %s", err)
+		}
+
+		// Recovers jobs of workers that crashed or were restarted
+		requeued, err := common.RequeueExpiredJobs(server.State)
+		if err != nil {
+			server.Logger.Warningf("[Jobs] Failed to requeue expired jobs: %s", err)
+		}
+
+		if requeued > 0 {
+			server.Logger.Warningf("[Jobs] Requeued %d abandoned jobs", requeued)
+		}
+
+		job, err := common.DequeueJob(JobPollTimeout, server.State)
+		if err != nil {
+			server.Logger.Warningf("[Jobs] Failed to dequeue job: %s", err)
+			time.Sleep(JobPollTimeout)
+			continue
+		}
+
+		if job == nil {
+			continue
+		}
+
+		server.ProcessJob(job)
+	}
+}
+
+// ProcessJob runs a dequeued job & marks it as completed or failed
+func (server *ScoreServer) ProcessJob(job *common.Job) {
+	err := RunJob(job, server)
+
+	if err == nil {
+		server.Logger.Debugf("[Jobs] Completed job %s", job)
+
+		if err = common.CompleteJob(job, server.State); err != nil {
+			server.Logger.Warningf("[Jobs] Failed to complete job %s: %s", job, err)
+		}
+		return
+	}
+
+	server.Logger.Warningf("[Jobs] Job %s failed (attempt %d): %s", job, job.Attempts+1, err)
+
+	if err = common.FailJob(job, err, server.State); err != nil {
+		server.Logger.Errorf("[Jobs] Failed to reschedule job %s: %s", job, err)
+	}
+}
+
+func RunJob(job *common.Job, server *ScoreServer) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+
+	handler, ok := jobHandlers[job.Type]
+	if !ok {
+		return fmt.Errorf("unknown job type '%s'", job.Type)
+	}
+
+	return handler(job, server)
+}
+
+// EnqueueBeatmapsetJobs queues the media & difficulty processing of an
+// uploaded beatmapset
+func EnqueueBeatmapsetJobs(beatmapset *common.Beatmapset, server *ScoreServer) error {
+	jobs := []*common.Job{
+		common.NewJob(common.JobTypeAudioPreview, beatmapset.Id),
+		common.NewJob(common.JobTypeThumbnail, beatmapset.Id),
+	}
+
+	for _, beatmap := range beatmapset.Beatmaps {
+		jobs = append(jobs, common.NewJob(common.JobTypeDifficulty, beatmap.Id))
+	}
+
+	for _, job := range jobs {
+		if err := common.EnqueueJob(job, server.State); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func AudioPreviewJob(job *common.Job, server *ScoreServer) error {
+	files, beatmapObject, err := LoadBeatmapsetFiles(job.Target, server)
+	if err != nil {
+		return err
+	}
+
+	return UploadAudioPreview(job.Target, files, beatmapObject.General, server)
+}
+
+func ThumbnailJob(job *common.Job, server *ScoreServer) error {
+	files, beatmapObject, err := LoadBeatmapsetFiles(job.Target, server)
+	if err != nil {
+		return err
+	}
+
+	return UploadBeatmapThumbnail(job.Target, files, beatmapObject.Events, server)
+}
+
+func DifficultyJob(job *common.Job, server *ScoreServer) error {
+	beatmap, err := common.FetchBeatmapById(job.Target, server.State)
+	if err != nil {
+		return err
+	}
+
+	return UpdateBeatmapDifficulty(beatmap, server)
+}
+
+// LoadBeatmapsetFiles extracts the stored package of a beatmapset, and
+// parses one of its beatmaps for the set-wide audio & background info
+func LoadBeatmapsetFiles(setId int, server *ScoreServer) (map[string][]byte, *hbxml.Beatmap, error) {
+	beatmapset, err := common.FetchBeatmapsetById(setId, server.State, "Beatmaps")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(beatmapset.Beatmaps) == 0 {
+		return nil, nil, errors.New("beatmapset has no beatmaps")
+	}
+
+	data, err := server.State.Storage.GetBeatmapPackage(setId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files := make(map[string][]byte, len(reader.File))
+
+	for _, file := range reader.File {
+		files[file.Name], err = ReadPackageFile(file)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	beatmapFile, ok := files[beatmapset.Beatmaps[0].Filename]
+	if !ok {
+		return nil, nil, errors.New("beatmap file not found in package")
+	}
+
+	beatmapObject, err := hbxml.NewBeatmap(bytes.NewReader(beatmapFile))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return files, beatmapObject, nil
+}
@@ -0,0 +1,81 @@
+package hscore
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hexis-revival/hexagon/common"
+)
+
+func newTestJobServer(t *testing.T) *ScoreServer {
+	return &ScoreServer{
+		Logger: common.CreateLogger("test", common.QUIET),
+		State:  common.NewTestRedisState(t),
+	}
+}
+
+func TestRunJob(t *testing.T) {
+	server := &ScoreServer{Logger: common.CreateLogger("test", common.QUIET)}
+
+	err := RunJob(common.NewJob("unknown", 1), server)
+	if err == nil || !strings.Contains(err.Error(), "unknown job type") {
+		t.Fatalf("expected unknown job type error, got %v", err)
+	}
+
+	jobHandlers["panic"] = func(job *common.Job, server *ScoreServer) error {
+		panic("broken job")
+	}
+	defer delete(jobHandlers, "panic")
+
+	err = RunJob(common.NewJob("panic", 1), server)
+	if err == nil || !strings.Contains(err.Error(), "broken job") {
+		t.Fatalf("expected panic to be returned as error, got %v", err)
+	}
+}
+
+func TestProcessJobFailure(t *testing.T) {
+	server := newTestJobServer(t)
+	state := server.State
+
+	if err := common.EnqueueJob(common.NewJob("unknown", 1), state); err != nil {
+		t.Fatal(err)
+	}
+
+	for attempt := 1; attempt <= common.JobMaxAttempts; attempt++ {
+		job, err := common.DequeueJob(time.Second, state)
+		if err != nil || job == nil {
+			t.Fatalf("expected job on attempt %d, got %v (%v)", attempt, job, err)
+		}
+
+		server.ProcessJob(job)
+
+		// Skip the backoff of the failed job
+		delayed, err := state.Redis.ZRange(*state.RedisContext, common.JobsDelayedKey, 0, -1).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if attempt < common.JobMaxAttempts && len(delayed) != 1 {
+			t.Fatalf("expected job to be delayed after attempt %d, got %d delayed jobs", attempt, len(delayed))
+		}
+
+		for _, raw := range delayed {
+			state.Redis.ZRem(*state.RedisContext, common.JobsDelayedKey, raw)
+			state.Redis.LPush(*state.RedisContext, common.JobsQueueKey, raw)
+		}
+	}
+
+	dead, err := common.FetchDeadJobs(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dead) != 1 || dead[0].Attempts != common.JobMaxAttempts {
+		t.Fatalf("expected job to be dead after %d attempts, got %v", common.JobMaxAttempts, dead)
+	}
+
+	if !strings.Contains(dead[0].Error, "unknown job type") {
+		t.Fatalf("expected job error to be kept, got '%s'", dead[0].Error)
+	}
+}
@@ -0,0 +1,120 @@
+package hscore
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/hexis-revival/hbxml"
+	"github.com/hexis-revival/hexagon/common"
+)
+
+const (
+	// Share of objects whose claimed judgement may differ from the
+	// simulated one, before the score is flagged
+	JudgementFlagThreshold = 0.02
+
+	// Claimed combo may exceed the estimated combo by this factor
+	JudgementComboTolerance = 1.1
+)
+
+var hitObjectTypes = map[hbxml.HitObjectType]common.HitObjectType{
+	hbxml.HitObjectCircle:  common.HitObjectCircle,
+	hbxml.HitObjectSlider:  common.HitObjectSlider,
+	hbxml.HitObjectSpinner: common.HitObjectSpinner,
+	hbxml.HitObjectHold:    common.HitObjectHold,
+}
+
+func LoadBeatmapObject(beatmap *common.Beatmap, server *ScoreServer) (*hbxml.Beatmap, error) {
+	file, err := server.State.Storage.GetBeatmapFile(beatmap.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return hbxml.NewBeatmap(bytes.NewReader(file))
+}
+
+func ConvertHitObjects(beatmapObject *hbxml.Beatmap) []common.HitObject {
+	objects := make([]common.HitObject, 0, len(beatmapObject.HitObjects))
+
+	for _, object := range beatmapObject.HitObjects {
+		objects = append(objects, common.HitObject{
+			Type:    hitObjectTypes[object.Type],
+			Time:    object.Time,
+			EndTime: object.EndTime,
+			X:       object.X,
+			Y:       object.Y,
+		})
+	}
+
+	return objects
+}
+
+// RejudgeScore simulates the submitted replay against the beatmap and
+// compares the result to the claimed judgements. It returns a flag if
+// they diverge. The simulation only judges the heads of sliders & holds
+// and has not been validated against real replays, so it never rejects.
+func RejudgeScore(beatmap *common.Beatmap, request *ScoreSubmissionRequest, server *ScoreServer) (*common.ScoreFlag, error) {
+	if !request.ScoreData.Passed {
+		// Failed replays end early & can't be compared
+		return nil, nil
+	}
+
+	beatmapObject, err := LoadBeatmapObject(beatmap, server)
+	if err != nil {
+		return nil, err
+	}
+
+	scoreData := request.ScoreData
+	simulated := common.JudgeReplay(
+		ConvertHitObjects(beatmapObject),
+		request.ReplayFrames,
+		BeatmapDifficulty(beatmap, scoreData.Mods),
+	)
+
+	claimed := &common.JudgementResult{
+		Count300:  scoreData.Count300,
+		Count100:  scoreData.Count100,
+		Count50:   scoreData.Count50,
+		CountMiss: scoreData.CountMiss,
+		MaxCombo:  scoreData.MaxCombo,
+	}
+
+	divergence := claimed.Divergence(simulated)
+	details := fmt.Sprintf(
+		"claimed %d/%d/%d/%d x%d, simulated %d/%d/%d/%d",
+		claimed.Count300, claimed.Count100, claimed.Count50, claimed.CountMiss, claimed.MaxCombo,
+		simulated.Count300, simulated.Count100, simulated.Count50, simulated.CountMiss,
+	)
+
+	if divergence > JudgementFlagThreshold {
+		return &common.ScoreFlag{
+			Type:    common.ScoreFlagJudgement,
+			Details: fmt.Sprintf("judgement divergence of %.2f%%: %s", divergence*100, details),
+		}, nil
+	}
+
+	if scoreData.Perfect && simulated.CountMiss > 0 {
+		return &common.ScoreFlag{
+			Type:    common.ScoreFlagJudgement,
+			Details: fmt.Sprintf("claimed full combo with simulated misses: %s", details),
+		}, nil
+	}
+
+	if beatmap.TotalObjects() <= 0 {
+		return nil, nil
+	}
+
+	// Simulated combo only counts objects, so scale it by the
+	// beatmap's combo per object to account for slider ticks.
+	comboPerObject := float64(beatmap.MaxCombo) / float64(beatmap.TotalObjects())
+	estimatedCombo := float64(simulated.MaxCombo) * comboPerObject
+
+	if float64(claimed.MaxCombo) > estimatedCombo*JudgementComboTolerance+5 {
+		return &common.ScoreFlag{
+			Type:    common.ScoreFlagJudgement,
+			Details: fmt.Sprintf("claimed combo exceeds estimated combo of %.0f: %s", estimatedCombo, details),
+		}, nil
+	}
+
+	return nil, nil
+}
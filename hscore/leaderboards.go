@@ -0,0 +1,151 @@
+package hscore
+
+import (
+	"github.com/hexis-revival/hexagon/common"
+)
+
+// RebuildBeatmapLeaderboard re-evaluates all scores on a beatmap after its
+// status has changed. On ranked & approved beatmaps, the best passed score
+// of every user becomes their personal best, otherwise all scores become
+// unranked again. It returns the ids of all users with scores on it.
+func RebuildBeatmapLeaderboard(beatmap *common.Beatmap, server *ScoreServer) ([]int, error) {
+	scores, err := common.FetchBeatmapScores(beatmap.Id, server.State)
+	if err != nil {
+		return nil, err
+	}
+
+	ranked := beatmap.Status >= common.BeatmapStatusRanked
+	statusChanges := leaderboardStatusChanges(scores, ranked)
+	userIds := []int{}
+	seenUsers := map[int]bool{}
+
+	for _, score := range scores {
+		if !seenUsers[score.UserId] {
+			seenUsers[score.UserId] = true
+			userIds = append(userIds, score.UserId)
+		}
+	}
+
+	for status, scoreIds := range statusChanges {
+		if err = common.UpdateScoresStatus(scoreIds, status, server.State); err != nil {
+			return nil, err
+		}
+	}
+
+	// Scores on unranked beatmaps are worth no pp
+	if _, err = UpdateScoresPerformance(beatmap, scores, server); err != nil {
+		return nil, err
+	}
+
+	return userIds, nil
+}
+
+// leaderboardStatusChanges assigns the status of every score on a beatmap
+// and returns the ids of the changed scores by their new status. Scores
+// have to be ordered like FetchBeatmapScores, so that the older of two
+// equal scores stays the personal best, as it does on submission.
+func leaderboardStatusChanges(scores []*common.Score, ranked bool) map[common.ScoreStatus][]int {
+	statusChanges := map[common.ScoreStatus][]int{}
+	hasPersonalBest := map[int]bool{}
+
+	for _, score := range scores {
+		status := common.ScoreStatusUnranked
+
+		if ranked {
+			switch {
+			case !score.Passed:
+				status = common.ScoreStatusFailed
+			case !hasPersonalBest[score.UserId]:
+				status = common.ScoreStatusPB
+				hasPersonalBest[score.UserId] = true
+			default:
+				status = common.ScoreStatusSubmitted
+			}
+		}
+
+		if status == score.Status {
+			continue
+		}
+
+		statusChanges[status] = append(statusChanges[status], score.Id)
+		score.Status = status
+	}
+
+	return statusChanges
+}
+
+// RebuildBeatmapsetLeaderboards rebuilds the leaderboards of all
+// beatmaps in a set & returns the ids of all affected users.
+func RebuildBeatmapsetLeaderboards(setId int, server *ScoreServer) ([]int, error) {
+	beatmaps, err := common.FetchBeatmapsBySetId(setId, server.State)
+	if err != nil {
+		return nil, err
+	}
+
+	userIds := []int{}
+	seenUsers := map[int]bool{}
+
+	for _, beatmap := range beatmaps {
+		beatmapUserIds, err := RebuildBeatmapLeaderboard(&beatmap, server)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, userId := range beatmapUserIds {
+			if seenUsers[userId] {
+				continue
+			}
+
+			seenUsers[userId] = true
+			userIds = append(userIds, userId)
+		}
+	}
+
+	return userIds, nil
+}
+
+// RefreshUserStatistics recalculates the stats of users, whose personal
+// bests have changed outside of a submission, e.g. after a beatmap was
+// ranked. Totals of their plays are left as they are.
+func RefreshUserStatistics(userIds []int, server *ScoreServer) error {
+	errors := common.NewErrorCollection()
+
+	for _, userId := range userIds {
+		user, err := common.FetchUserById(userId, server.State, "Stats")
+		if err != nil {
+			errors.Add(err)
+			continue
+		}
+
+		if err = user.EnsureStats(server.State); err != nil {
+			errors.Add(err)
+			continue
+		}
+
+		err = server.Transaction(func(tx *ScoreServer) error {
+			// Keep submissions from changing the stats in the meantime
+			stats, err := common.FetchStatsForUpdate(user.Id, tx.State)
+			if err != nil {
+				return err
+			}
+			user.Stats = *stats
+
+			stats, err = CalculateUserStatistics(user, tx)
+			if err != nil {
+				return err
+			}
+			user.Stats = *stats
+
+			return common.UpdateStatsBests(&user.Stats, tx.State)
+		})
+
+		if err != nil {
+			errors.Add(err)
+			continue
+		}
+
+		errors.Add(UpdateUserRankings(user, server))
+	}
+
+	return errors.Next()
+}
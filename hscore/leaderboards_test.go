@@ -0,0 +1,208 @@
+package hscore
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hexis-revival/hexagon/common"
+)
+
+func createTestScore(t *testing.T, user *common.User, beatmap *common.Beatmap, totalScore int64, status common.ScoreStatus, server *ScoreServer) *common.Score {
+	score := &common.Score{
+		BeatmapId:  beatmap.Id,
+		UserId:     user.Id,
+		Checksum:   fmt.Sprintf("%016d%016d", user.Id, totalScore),
+		Status:     status,
+		TotalScore: totalScore,
+		MaxCombo:   100,
+		Accuracy:   0.95,
+		PP:         100,
+		Passed:     true,
+		Grade:      common.GradeA,
+		Count300:   95,
+		Count100:   5,
+	}
+
+	if err := common.CreateScore(score, server.State); err != nil {
+		t.Fatalf("failed to create score: %s", err)
+	}
+
+	return score
+}
+
+func TestLeaderboardStatusChanges(t *testing.T) {
+	// Ordered like FetchBeatmapScores, the older of the equal scores comes first
+	newScores := func() []*common.Score {
+		return []*common.Score{
+			{Id: 2, UserId: 1, TotalScore: 1000, Passed: true, Status: common.ScoreStatusSubmitted},
+			{Id: 5, UserId: 1, TotalScore: 1000, Passed: true, Status: common.ScoreStatusPB},
+			{Id: 3, UserId: 2, TotalScore: 900, Passed: false, Status: common.ScoreStatusUnranked},
+			{Id: 4, UserId: 2, TotalScore: 800, Passed: true, Status: common.ScoreStatusUnranked},
+			{Id: 1, UserId: 1, TotalScore: 700, Passed: true, Status: common.ScoreStatusSubmitted},
+		}
+	}
+
+	tests := []struct {
+		Name     string
+		Ranked   bool
+		Statuses map[int]common.ScoreStatus
+		Changes  map[common.ScoreStatus][]int
+	}{
+		{
+			"ranked", true,
+			map[int]common.ScoreStatus{
+				2: common.ScoreStatusPB,
+				5: common.ScoreStatusSubmitted,
+				3: common.ScoreStatusFailed,
+				4: common.ScoreStatusPB,
+				1: common.ScoreStatusSubmitted,
+			},
+			map[common.ScoreStatus][]int{
+				common.ScoreStatusPB:        {2, 4},
+				common.ScoreStatusSubmitted: {5},
+				common.ScoreStatusFailed:    {3},
+			},
+		},
+		{
+			"unranked", false,
+			map[int]common.ScoreStatus{
+				2: common.ScoreStatusUnranked,
+				5: common.ScoreStatusUnranked,
+				3: common.ScoreStatusUnranked,
+				4: common.ScoreStatusUnranked,
+				1: common.ScoreStatusUnranked,
+			},
+			map[common.ScoreStatus][]int{
+				common.ScoreStatusUnranked: {2, 5, 1},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		scores := newScores()
+		changes := leaderboardStatusChanges(scores, test.Ranked)
+
+		if fmt.Sprint(changes) != fmt.Sprint(test.Changes) {
+			t.Errorf("%s: expected changes %v, got %v", test.Name, test.Changes, changes)
+		}
+
+		for _, score := range scores {
+			if score.Status != test.Statuses[score.Id] {
+				t.Errorf("%s: expected status %d for score %d, got %d", test.Name, test.Statuses[score.Id], score.Id, score.Status)
+			}
+		}
+	}
+}
+
+func TestRebuildBeatmapLeaderboard(t *testing.T) {
+	server := newTestDatabaseServer(t)
+	creator := createTestUser(t, "creator", 0, server)
+	first := createTestUser(t, "first", 0, server)
+	second := createTestUser(t, "second", 0, server)
+	_, beatmap := createTestBeatmapset(t, creator, server)
+
+	// Scores of a beatmap that has been ranked before
+	createTestScore(t, first, beatmap, 1000, common.ScoreStatusPB, server)
+	createTestScore(t, first, beatmap, 500, common.ScoreStatusSubmitted, server)
+	createTestScore(t, second, beatmap, 800, common.ScoreStatusPB, server)
+
+	userIds, err := RebuildBeatmapLeaderboard(beatmap, server)
+	if err != nil {
+		t.Fatalf("failed to rebuild leaderboard: %s", err)
+	}
+
+	if fmt.Sprint(userIds) != fmt.Sprint([]int{first.Id, second.Id}) {
+		t.Fatalf("expected affected users %d & %d, got %v", first.Id, second.Id, userIds)
+	}
+
+	scores, err := common.FetchBeatmapScores(beatmap.Id, server.State)
+	if err != nil {
+		t.Fatalf("failed to fetch scores: %s", err)
+	}
+
+	for _, score := range scores {
+		if score.Status != common.ScoreStatusUnranked || score.PP != 0 {
+			t.Errorf("expected unranked score without pp, got status %d with %fpp", score.Status, score.PP)
+		}
+	}
+}
+
+func TestRefreshUserStatistics(t *testing.T) {
+	server := newTestDatabaseServer(t)
+	creator := createTestUser(t, "creator", 0, server)
+	user := createTestUser(t, "player", 0, server)
+	beatmapset, beatmap := createTestBeatmapset(t, creator, server)
+
+	err := common.UpdateBeatmapsStatusBySetId(beatmapset.Id, common.BeatmapStatusRanked, server.State)
+	if err != nil {
+		t.Fatalf("failed to rank beatmap: %s", err)
+	}
+
+	if err = user.EnsureStats(server.State); err != nil {
+		t.Fatalf("failed to create stats: %s", err)
+	}
+
+	user.Stats.TotalScore = 1500
+	user.Stats.Playcount = 2
+
+	if err = common.UpdateStats(&user.Stats, server.State); err != nil {
+		t.Fatalf("failed to update stats: %s", err)
+	}
+
+	best := createTestScore(t, user, beatmap, 1000, common.ScoreStatusPB, server)
+	createTestScore(t, user, beatmap, 500, common.ScoreStatusSubmitted, server)
+
+	if err = RefreshUserStatistics([]int{user.Id}, server); err != nil {
+		t.Fatalf("failed to refresh stats: %s", err)
+	}
+
+	stats, err := common.FetchStatsForUpdate(user.Id, server.State)
+	if err != nil {
+		t.Fatalf("failed to fetch stats: %s", err)
+	}
+
+	if stats.RankedScore != best.TotalScore || stats.Accuracy != common.RoundAccuracy(best.Accuracy) {
+		t.Errorf("expected ranked score %d & accuracy %f, got %d & %f", best.TotalScore, best.Accuracy, stats.RankedScore, stats.Accuracy)
+	}
+
+	if stats.ACount != 1 || stats.MaxCombo != best.MaxCombo || stats.PP <= 0 {
+		t.Errorf("expected bests of the ranked score, got %+v", stats)
+	}
+
+	if stats.TotalScore != 1500 || stats.Playcount != 2 {
+		t.Errorf("expected totals to stay as they are, got %d score & %d plays", stats.TotalScore, stats.Playcount)
+	}
+
+	if stats.Rank != 1 {
+		t.Errorf("expected user to be ranked first, got %d", stats.Rank)
+	}
+
+	// The only personal best is gone once the beatmap is unranked again
+	beatmap.Status = common.BeatmapStatusPending
+
+	err = common.UpdateBeatmapsStatusBySetId(beatmapset.Id, beatmap.Status, server.State)
+	if err != nil {
+		t.Fatalf("failed to unrank beatmap: %s", err)
+	}
+
+	if _, err = RebuildBeatmapLeaderboard(beatmap, server); err != nil {
+		t.Fatalf("failed to rebuild leaderboard: %s", err)
+	}
+
+	if err = RefreshUserStatistics([]int{user.Id}, server); err != nil {
+		t.Fatalf("failed to refresh stats: %s", err)
+	}
+
+	stats, err = common.FetchStatsForUpdate(user.Id, server.State)
+	if err != nil {
+		t.Fatalf("failed to fetch stats: %s", err)
+	}
+
+	if stats.RankedScore != 0 || stats.PP != 0 || stats.Clears() != 0 {
+		t.Errorf("expected stats without bests, got %+v", stats)
+	}
+
+	if stats.Rank != common.RankUnranked {
+		t.Errorf("expected user to be unranked, got rank %d", stats.Rank)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hexis-revival/hexagon/common"
 )
@@ -31,6 +32,54 @@ func (req *ScoreSubmissionRequest) ClientDataBase64() string {
 type ScoreSubmissionResponse struct {
 	Success bool   `json:"success"`
 	Error   string `json:"error,omitempty"`
+
+	ScoreId      int     `json:"score_id,omitempty"`
+	PersonalBest bool    `json:"personal_best"`
+	Position     int     `json:"position,omitempty"`
+	PP           float64 `json:"pp"`
+	RankDelta    int     `json:"rank_delta"`
+
+	Achievements []*AchievementResponse `json:"achievements"`
+
+	// Stats before are left out for duplicate submissions
+	Before *ScoreSubmissionStats `json:"before,omitempty"`
+	After  *ScoreSubmissionStats `json:"after,omitempty"`
+}
+
+type ScoreSubmissionStats struct {
+	Rank        int     `json:"rank"`
+	RankedScore int64   `json:"ranked_score"`
+	TotalScore  int64   `json:"total_score"`
+	Accuracy    float64 `json:"accuracy"`
+	PP          float64 `json:"pp"`
+	Playcount   int     `json:"playcount"`
+}
+
+type AchievementResponse struct {
+	Name        string     `json:"name"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	UnlockedAt  *time.Time `json:"unlocked_at,omitempty"`
+}
+
+func NewAchievementResponse(achievement *common.Achievement, unlockedAt *time.Time) *AchievementResponse {
+	return &AchievementResponse{
+		Name:        achievement.Name,
+		Title:       achievement.Title,
+		Description: achievement.Description,
+		UnlockedAt:  unlockedAt,
+	}
+}
+
+func NewScoreSubmissionStats(stats *common.Stats, rank int) *ScoreSubmissionStats {
+	return &ScoreSubmissionStats{
+		Rank:        rank,
+		RankedScore: stats.RankedScore,
+		TotalScore:  stats.TotalScore,
+		Accuracy:    stats.Accuracy,
+		PP:          stats.PP,
+		Playcount:   stats.Playcount,
+	}
 }
 
 func (resp *ScoreSubmissionResponse) String() string {
@@ -47,6 +96,146 @@ func (req *ReplayDownloadRequest) String() string {
 	return common.FormatStruct(req)
 }
 
+type BeatmapDownloadRequest struct {
+	Username string
+	Password string
+	SetId    int
+	NoVideo  bool
+}
+
+func (req *BeatmapDownloadRequest) String() string {
+	return common.FormatStruct(req)
+}
+
+const (
+	ReplayExportJSON = "json"
+	ReplayExportCSV  = "csv"
+)
+
+type ReplayExportRequest struct {
+	Username string
+	Password string
+	ScoreId  int
+	Format   string
+}
+
+func (req *ReplayExportRequest) String() string {
+	return common.FormatStruct(req)
+}
+
+type BeatmapStatusRequest struct {
+	Username string
+	Password string
+	SetId    int
+	Action   common.BeatmapStatusAction
+	Reason   string
+}
+
+func (req *BeatmapStatusRequest) String() string {
+	return common.FormatStruct(req)
+}
+
+type BeatmapStatusResponse struct {
+	Success     bool                 `json:"success"`
+	Error       string               `json:"error,omitempty"`
+	Status      common.BeatmapStatus `json:"status"`
+	Qualified   bool                 `json:"qualified"`
+	QualifiedAt *time.Time           `json:"qualified_at,omitempty"`
+	Nominations int                  `json:"nominations"`
+}
+
+type BeatmapStatusChangeResponse struct {
+	Action         common.BeatmapStatusAction `json:"action"`
+	PreviousStatus common.BeatmapStatus       `json:"previous_status"`
+	Status         common.BeatmapStatus       `json:"status"`
+	Reason         string                     `json:"reason,omitempty"`
+	User           string                     `json:"user,omitempty"`
+	CreatedAt      time.Time                  `json:"created_at"`
+}
+
+type BeatmapSearchResponse struct {
+	Beatmapsets []*BeatmapsetResponse `json:"beatmapsets"`
+	Cursor      string                `json:"cursor,omitempty"`
+}
+
+type BeatmapsetResponse struct {
+	Id        int                  `json:"id"`
+	Title     string               `json:"title"`
+	Artist    string               `json:"artist"`
+	Source    string               `json:"source"`
+	Tags      []string             `json:"tags"`
+	Creator   string               `json:"creator"`
+	CreatorId int                  `json:"creator_id"`
+	Status    common.BeatmapStatus `json:"status"`
+	// Qualified beatmapsets keep the pending status, which is all
+	// that clients know, until they are ranked
+	Qualified   bool               `json:"qualified"`
+	QualifiedAt *time.Time         `json:"qualified_at,omitempty"`
+	HasVideo    bool               `json:"has_video"`
+	Downloads   int                `json:"downloads"`
+	CreatedAt   time.Time          `json:"created_at"`
+	LastUpdated time.Time          `json:"last_updated"`
+	ApprovedAt  *time.Time         `json:"approved_at,omitempty"`
+	Beatmaps    []*BeatmapResponse `json:"beatmaps"`
+}
+
+type BeatmapResponse struct {
+	Id          int                  `json:"id"`
+	Version     string               `json:"version"`
+	Checksum    string               `json:"checksum"`
+	Status      common.BeatmapStatus `json:"status"`
+	TotalLength int                  `json:"total_length"`
+	DrainLength int                  `json:"drain_length"`
+	MaxCombo    int                  `json:"max_combo"`
+	Bpm         float64              `json:"bpm"`
+	CS          float64              `json:"cs"`
+	HP          float64              `json:"hp"`
+	OD          float64              `json:"od"`
+	AR          float64              `json:"ar"`
+	SR          float64              `json:"sr"`
+}
+
+func NewBeatmapsetResponse(beatmapset *common.Beatmapset) *BeatmapsetResponse {
+	response := &BeatmapsetResponse{
+		Id:          beatmapset.Id,
+		Title:       beatmapset.Title,
+		Artist:      beatmapset.Artist,
+		Source:      beatmapset.Source,
+		Tags:        beatmapset.Tags,
+		Creator:     beatmapset.Creator.Name,
+		CreatorId:   beatmapset.CreatorId,
+		Status:      beatmapset.Status,
+		Qualified:   beatmapset.QualifiedAt != nil,
+		QualifiedAt: beatmapset.QualifiedAt,
+		HasVideo:    beatmapset.HasVideo,
+		Downloads:   beatmapset.Downloads,
+		CreatedAt:   beatmapset.CreatedAt,
+		LastUpdated: beatmapset.LastUpdated,
+		ApprovedAt:  beatmapset.ApprovedAt,
+		Beatmaps:    make([]*BeatmapResponse, 0, len(beatmapset.Beatmaps)),
+	}
+
+	for _, beatmap := range beatmapset.Beatmaps {
+		response.Beatmaps = append(response.Beatmaps, &BeatmapResponse{
+			Id:          beatmap.Id,
+			Version:     beatmap.Version,
+			Checksum:    beatmap.Checksum,
+			Status:      beatmap.Status,
+			TotalLength: beatmap.TotalLength,
+			DrainLength: beatmap.DrainLength,
+			MaxCombo:    beatmap.MaxCombo,
+			Bpm:         beatmap.MedianBpm,
+			CS:          beatmap.CS,
+			HP:          beatmap.HP,
+			OD:          beatmap.OD,
+			AR:          beatmap.AR,
+			SR:          beatmap.SR,
+		})
+	}
+
+	return response
+}
+
 type BeatmapSubmissionRequest struct {
 	Username      string
 	Password      string
@@ -97,6 +286,10 @@ func (req *BeatmapUploadRequest) String() string {
 
 type BeatmapUploadResponse struct {
 	Success bool
+	// Reason of a rejected package, which is only logged,
+	// since clients don't read anything but the status
+	ErrorCode    int
+	ErrorMessage string
 }
 
 func (resp *BeatmapUploadResponse) String() string {
@@ -0,0 +1,203 @@
+package hscore
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+)
+
+const (
+	// Hard limit of the uncompressed package size, before the beatmaps
+	// are parsed and the limit based on their length is known
+	MaxPackageSize = 100_000_000
+	// Maximum amount of files inside of a package
+	MaxPackageFiles = 256
+	// Maximum compression ratio of a single file
+	MaxCompressionRatio = 100
+	// Files below this size are not checked for their compression ratio,
+	// since small text files can compress very well
+	CompressionRatioThreshold = 1_000_000
+)
+
+// Error codes of rejected packages. Clients only read whether an upload
+// succeeded, so they are reported in the server logs, along with the
+// message of the error.
+const (
+	PackageErrorNone = iota
+	// The package could not be read
+	PackageErrorInvalid
+	// The package exceeds its size limit
+	PackageErrorTooLarge
+	// The package contains too many files
+	PackageErrorTooManyFiles
+	// A file is compressed suspiciously well, e.g. a zip bomb
+	PackageErrorCompressionRatio
+	// A filename is absolute or leaves the package directory
+	PackageErrorInvalidFilename
+	// A filename appears more than once
+	PackageErrorDuplicateFile
+	// The package contains another archive
+	PackageErrorNestedArchive
+	// The package contains a file type that is not allowed
+	PackageErrorDisallowedType
+	// A beatmap file could not be parsed
+	PackageErrorInvalidBeatmap
+)
+
+var allowedPackageExtensions = []string{
+	".hbxml", ".hxz", ".png", ".jpg", ".jpeg", ".mp3",
+	".ogg", ".wav", ".flac", ".wmv", ".flv", ".mp4",
+	".avi", ".mkv", ".webm", ".txt", ".ini",
+}
+
+// Files that operating systems leave behind, which
+// are dropped from packages instead of rejecting them
+var ignoredPackageFiles = []string{
+	"thumbs.db", ".ds_store", "desktop.ini",
+}
+
+var archiveExtensions = []string{
+	".zip", ".rar", ".7z", ".tar", ".gz", ".bz2", ".xz",
+}
+
+var archiveSignatures = [][]byte{
+	[]byte("PK\x03\x04"),         // zip
+	[]byte("Rar!\x1a\x07"),       // rar
+	[]byte("7z\xbc\xaf\x27\x1c"), // 7z
+	[]byte("\x1f\x8b"),           // gzip
+	[]byte("BZh"),                // bzip2
+	[]byte("\xfd7zXZ\x00"),       // xz
+}
+
+type PackageError struct {
+	Code    int
+	Message string
+}
+
+func (err *PackageError) Error() string {
+	return err.Message
+}
+
+func NewPackageError(code int, format string, args ...any) *PackageError {
+	return &PackageError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// ValidatePackageEntries checks the central directory of a package, before
+// any of its files are decompressed
+func ValidatePackageEntries(files []*zip.File) error {
+	if len(files) > MaxPackageFiles {
+		return NewPackageError(PackageErrorTooManyFiles, "package contains %d files", len(files))
+	}
+
+	filenames := make(map[string]bool, len(files))
+	totalSize := uint64(0)
+
+	for _, file := range files {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+
+		if !IsValidPackageFilename(file.Name) {
+			return NewPackageError(PackageErrorInvalidFilename, "invalid filename '%s'", file.Name)
+		}
+
+		if IsIgnoredPackageFile(file.Name) {
+			continue
+		}
+
+		// Filesystems of most clients are case-insensitive
+		filename := strings.ToLower(file.Name)
+		if filenames[filename] {
+			return NewPackageError(PackageErrorDuplicateFile, "duplicate file '%s'", file.Name)
+		}
+		filenames[filename] = true
+
+		if HasExtension(file.Name, archiveExtensions) {
+			return NewPackageError(PackageErrorNestedArchive, "nested archive '%s'", file.Name)
+		}
+
+		if !HasExtension(file.Name, allowedPackageExtensions) {
+			return NewPackageError(PackageErrorDisallowedType, "disallowed file '%s'", file.Name)
+		}
+
+		if file.UncompressedSize64 > CompressionRatioThreshold &&
+			file.UncompressedSize64 > file.CompressedSize64*MaxCompressionRatio {
+			return NewPackageError(PackageErrorCompressionRatio, "compression ratio of '%s' too high", file.Name)
+		}
+
+		totalSize += file.UncompressedSize64
+		if totalSize > MaxPackageSize {
+			return NewPackageError(PackageErrorTooLarge, "package size limit exceeded")
+		}
+	}
+
+	return nil
+}
+
+// ReadPackageFile decompresses a single file, without trusting the size
+// stated in its header
+func ReadPackageFile(file *zip.File) ([]byte, error) {
+	fileHandle, err := file.Open()
+	if err != nil {
+		return nil, NewPackageError(PackageErrorInvalid, "failed to open file: %s", err)
+	}
+	defer fileHandle.Close()
+
+	buffer := bytes.NewBuffer(make([]byte, 0, file.UncompressedSize64))
+	reader := io.LimitReader(fileHandle, int64(file.UncompressedSize64)+1)
+
+	if _, err = buffer.ReadFrom(reader); err != nil {
+		return nil, NewPackageError(PackageErrorInvalid, "failed to read file: %s", err)
+	}
+
+	if uint64(buffer.Len()) > file.UncompressedSize64 {
+		return nil, NewPackageError(PackageErrorTooLarge, "file '%s' exceeds its stated size", file.Name)
+	}
+
+	if IsArchive(buffer.Bytes()) {
+		return nil, NewPackageError(PackageErrorNestedArchive, "nested archive '%s'", file.Name)
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// IsValidPackageFilename returns whether a filename stays inside of the
+// package directory, once extracted
+func IsValidPackageFilename(filename string) bool {
+	if filename == "" || strings.ContainsAny(filename, ":\x00") {
+		return false
+	}
+
+	filename = strings.ReplaceAll(filename, "\\", "/")
+
+	if path.IsAbs(filename) || path.Clean(filename) != filename {
+		return false
+	}
+
+	return filename != ".." && !strings.HasPrefix(filename, "../")
+}
+
+// IsIgnoredPackageFile returns whether a file was created by the operating
+// system of the uploader, e.g. thumbnail caches or macOS resource forks
+func IsIgnoredPackageFile(filename string) bool {
+	filename = strings.ToLower(strings.ReplaceAll(filename, "\\", "/"))
+
+	if strings.HasPrefix(filename, "__macosx/") {
+		return true
+	}
+
+	return slices.Contains(ignoredPackageFiles, path.Base(filename))
+}
+
+func IsArchive(data []byte) bool {
+	for _, signature := range archiveSignatures {
+		if bytes.HasPrefix(data, signature) {
+			return true
+		}
+	}
+	return false
+}
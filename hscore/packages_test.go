@@ -0,0 +1,240 @@
+package hscore
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"testing"
+)
+
+type packageEntry struct {
+	Name string
+	Data []byte
+	// Uncompressed size stated in the header, if it differs from the data
+	StatedSize int
+}
+
+func createPackage(t *testing.T, entries []packageEntry) *zip.Reader {
+	buffer := bytes.Buffer{}
+	writer := zip.NewWriter(&buffer)
+
+	for _, entry := range entries {
+		header := &zip.FileHeader{
+			Name:               entry.Name,
+			Method:             zip.Store,
+			CRC32:              crc32.ChecksumIEEE(entry.Data),
+			CompressedSize64:   uint64(len(entry.Data)),
+			UncompressedSize64: uint64(len(entry.Data)),
+		}
+
+		if entry.StatedSize > 0 {
+			header.UncompressedSize64 = uint64(entry.StatedSize)
+		}
+
+		file, err := writer.CreateRaw(header)
+		if err != nil {
+			t.Fatalf("failed to create '%s': %s", entry.Name, err)
+		}
+
+		if _, err = file.Write(entry.Data); err != nil {
+			t.Fatalf("failed to write '%s': %s", entry.Name, err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close package: %s", err)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	if err != nil {
+		t.Fatalf("failed to open package: %s", err)
+	}
+
+	return reader
+}
+
+func packageErrorCode(err error) int {
+	var packageError *PackageError
+	if errors.As(err, &packageError) {
+		return packageError.Code
+	}
+	if err != nil {
+		return -1
+	}
+	return PackageErrorNone
+}
+
+func TestIsValidPackageFilename(t *testing.T) {
+	tests := []struct {
+		Filename string
+		Valid    bool
+	}{
+		{"beatmap.hbxml", true},
+		{"sb/background.png", true},
+		{"sb\\background.png", true},
+		{"..beatmap.hbxml", true},
+		{"", false},
+		{"..", false},
+		{"../beatmap.hbxml", false},
+		{"sb/../../beatmap.hbxml", false},
+		{"..\\beatmap.hbxml", false},
+		{"/etc/passwd", false},
+		{"\\windows\\system.ini", false},
+		{"C:\\beatmap.hbxml", false},
+		{"C:beatmap.hbxml", false},
+		{"sb//background.png", false},
+		{"./beatmap.hbxml", false},
+		{"beatmap\x00.hbxml", false},
+	}
+
+	for _, test := range tests {
+		if valid := IsValidPackageFilename(test.Filename); valid != test.Valid {
+			t.Errorf("IsValidPackageFilename(%q) = %t, expected %t", test.Filename, valid, test.Valid)
+		}
+	}
+}
+
+func TestIsIgnoredPackageFile(t *testing.T) {
+	tests := []struct {
+		Filename string
+		Ignored  bool
+	}{
+		{"Thumbs.db", true},
+		{"sb/thumbs.db", true},
+		{".DS_Store", true},
+		{"sb\\.DS_Store", true},
+		{"desktop.ini", true},
+		{"__MACOSX/._beatmap.hbxml", true},
+		{"beatmap.hbxml", false},
+		{"skin.ini", false},
+		{"sb/__MACOSX.png", false},
+	}
+
+	for _, test := range tests {
+		if ignored := IsIgnoredPackageFile(test.Filename); ignored != test.Ignored {
+			t.Errorf("IsIgnoredPackageFile(%q) = %t, expected %t", test.Filename, ignored, test.Ignored)
+		}
+	}
+}
+
+func TestIsArchive(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Data    []byte
+		Archive bool
+	}{
+		{"zip", []byte("PK\x03\x04rest"), true},
+		{"rar", []byte("Rar!\x1a\x07\x00"), true},
+		{"7z", []byte("7z\xbc\xaf\x27\x1c"), true},
+		{"gzip", []byte("\x1f\x8b\x08"), true},
+		{"bzip2", []byte("BZh91AY"), true},
+		{"xz", []byte("\xfd7zXZ\x00\x00"), true},
+		{"png", []byte("\x89PNG\r\n\x1a\n"), false},
+		{"text", []byte("PK is not enough"), false},
+		{"empty", []byte{}, false},
+	}
+
+	for _, test := range tests {
+		if archive := IsArchive(test.Data); archive != test.Archive {
+			t.Errorf("IsArchive(%s) = %t, expected %t", test.Name, archive, test.Archive)
+		}
+	}
+}
+
+func TestValidatePackageEntries(t *testing.T) {
+	tooManyFiles := make([]packageEntry, MaxPackageFiles+1)
+	for i := range tooManyFiles {
+		tooManyFiles[i] = packageEntry{Name: string(rune('a'+i%26)) + string(rune('a'+i/26)) + ".txt"}
+	}
+
+	tests := []struct {
+		Name    string
+		Entries []packageEntry
+		Code    int
+	}{
+		{"valid", []packageEntry{
+			{Name: "beatmap.hbxml", Data: []byte("<beatmap/>")},
+			{Name: "audio.mp3", Data: []byte("audio")},
+		}, PackageErrorNone},
+		{"junk files", []packageEntry{
+			{Name: "beatmap.hbxml", Data: []byte("<beatmap/>")},
+			{Name: "Thumbs.db", Data: []byte("cache")},
+			{Name: "__MACOSX/._beatmap.hbxml", Data: []byte("fork")},
+		}, PackageErrorNone},
+		{"path traversal", []packageEntry{
+			{Name: "../beatmap.hbxml"},
+		}, PackageErrorInvalidFilename},
+		{"junk path traversal", []packageEntry{
+			{Name: "../Thumbs.db"},
+		}, PackageErrorInvalidFilename},
+		{"duplicate file", []packageEntry{
+			{Name: "beatmap.hbxml"},
+			{Name: "Beatmap.HBXML"},
+		}, PackageErrorDuplicateFile},
+		{"nested archive", []packageEntry{
+			{Name: "package.zip"},
+		}, PackageErrorNestedArchive},
+		{"disallowed type", []packageEntry{
+			{Name: "virus.exe"},
+		}, PackageErrorDisallowedType},
+		{"compression ratio", []packageEntry{
+			{Name: "audio.mp3", Data: []byte("audio"), StatedSize: CompressionRatioThreshold + 1},
+		}, PackageErrorCompressionRatio},
+		{"too many files", tooManyFiles, PackageErrorTooManyFiles},
+	}
+
+	for _, test := range tests {
+		reader := createPackage(t, test.Entries)
+		err := ValidatePackageEntries(reader.File)
+
+		if code := packageErrorCode(err); code != test.Code {
+			t.Errorf("%s: expected error code %d, got %d (%v)", test.Name, test.Code, code, err)
+		}
+	}
+}
+
+func TestReadPackageFile(t *testing.T) {
+	tests := []struct {
+		Name  string
+		Entry packageEntry
+		Code  int
+	}{
+		{"valid", packageEntry{Name: "audio.mp3", Data: []byte("audio")}, PackageErrorNone},
+		// The zip reader stops at the stated size, before the limit is reached
+		{"lying size", packageEntry{Name: "audio.mp3", Data: []byte("much more audio"), StatedSize: 4}, PackageErrorInvalid},
+		{"disguised archive", packageEntry{Name: "audio.mp3", Data: []byte("PK\x03\x04")}, PackageErrorNestedArchive},
+	}
+
+	for _, test := range tests {
+		reader := createPackage(t, []packageEntry{test.Entry})
+		data, err := ReadPackageFile(reader.File[0])
+
+		if code := packageErrorCode(err); code != test.Code {
+			t.Errorf("%s: expected error code %d, got %d (%v)", test.Name, test.Code, code, err)
+			continue
+		}
+
+		if err == nil && !bytes.Equal(data, test.Entry.Data) {
+			t.Errorf("%s: expected %q, got %q", test.Name, test.Entry.Data, data)
+		}
+	}
+}
+
+func TestBeatmapUploadResponse(t *testing.T) {
+	tests := []struct {
+		Response BeatmapUploadResponse
+		Expected string
+	}{
+		{BeatmapUploadResponse{Success: true}, "0"},
+		{BeatmapUploadResponse{}, "1"},
+		// Clients only read the status, the error is logged instead
+		{BeatmapUploadResponse{ErrorCode: PackageErrorTooLarge, ErrorMessage: "package is too large"}, "1"},
+	}
+
+	for _, test := range tests {
+		if response := test.Response.Write(); response != test.Expected {
+			t.Errorf("expected response %q for %v, got %q", test.Expected, test.Response, response)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package hscore
+
+import (
+	"math"
+
+	"github.com/hexis-revival/hbxml"
+	"github.com/hexis-revival/hexagon/common"
+)
+
+// ScoreMods returns the mods a stored score was set with
+func ScoreMods(score *common.Score) *Mods {
+	return &Mods{
+		ArOffset: score.AROffset,
+		OdOffset: score.ODOffset,
+		CsOffset: score.CSOffset,
+		HpOffset: score.HPOffset,
+		PsOffset: score.PSOffset,
+		Hidden:   score.ModHidden,
+		NoFail:   score.ModNoFail,
+	}
+}
+
+// AwardsPerformance returns whether scores on this beatmap are worth pp
+func AwardsPerformance(beatmap *common.Beatmap) bool {
+	return beatmap.Status == common.BeatmapStatusRanked ||
+		beatmap.Status == common.BeatmapStatusApproved
+}
+
+// CalculateScorePerformance computes the pp of a score, using the
+// difficulty attributes of its beatmap with the score's mods applied.
+func CalculateScorePerformance(score *common.Score, beatmap *common.Beatmap, attributes *common.DifficultyAttributes) float64 {
+	if !score.Passed || !AwardsPerformance(beatmap) {
+		return 0
+	}
+
+	performance := common.CalculatePerformance(
+		attributes,
+		common.NewPerformanceScore(score, beatmap.MaxCombo),
+	)
+
+	return RoundPerformance(performance)
+}
+
+// ScorePerformance loads the beatmap of a score & computes its pp
+func ScorePerformance(score *common.Score, beatmap *common.Beatmap, server *ScoreServer) (float64, error) {
+	if !score.Passed || !AwardsPerformance(beatmap) {
+		return 0, nil
+	}
+
+	beatmapObject, err := LoadBeatmapObject(beatmap, server)
+	if err != nil {
+		return 0, err
+	}
+
+	attributes := CalculateBeatmapDifficulty(beatmap, beatmapObject, ScoreMods(score))
+	return CalculateScorePerformance(score, beatmap, attributes), nil
+}
+
+// UpdateScoresPerformance recalculates the pp of the given scores on a
+// beatmap, reusing the difficulty attributes for scores with equal mods.
+// It returns the amount of scores whose pp have changed.
+func UpdateScoresPerformance(beatmap *common.Beatmap, scores []*common.Score, server *ScoreServer) (int, error) {
+	if len(scores) == 0 {
+		return 0, nil
+	}
+
+	var beatmapObject *hbxml.Beatmap
+	var err error
+
+	if AwardsPerformance(beatmap) {
+		beatmapObject, err = LoadBeatmapObject(beatmap, server)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	attributes := map[common.JudgementDifficulty]*common.DifficultyAttributes{}
+	updated := 0
+
+	for _, score := range scores {
+		performance := 0.0
+
+		if beatmapObject != nil {
+			mods := ScoreMods(score)
+			difficulty := BeatmapDifficulty(beatmap, mods)
+
+			if _, ok := attributes[difficulty]; !ok {
+				attributes[difficulty] = CalculateBeatmapDifficulty(beatmap, beatmapObject, mods)
+			}
+
+			performance = CalculateScorePerformance(score, beatmap, attributes[difficulty])
+		}
+
+		if performance == score.PP {
+			continue
+		}
+
+		score.PP = performance
+		updated++
+
+		if err = common.UpdateScore(score, server.State); err != nil {
+			return updated, err
+		}
+	}
+
+	return updated, nil
+}
+
+// UserPerformance returns the weighted pp total of a user's best scores
+func UserPerformance(bestScores ...[]*common.Score) float64 {
+	performances := []float64{}
+
+	for _, scores := range bestScores {
+		for _, score := range scores {
+			performances = append(performances, score.PP)
+		}
+	}
+
+	return RoundPerformance(common.CalculateWeightedPerformance(performances))
+}
+
+// UpdateUserPerformance recalculates the pp total of a user from their
+// best scores, and updates their stats & rankings entries accordingly.
+func UpdateUserPerformance(user *common.User, server *ScoreServer) error {
+	if err := user.EnsureStats(server.State); err != nil {
+		return err
+	}
+
+	bestScoresRanked, err := common.FetchBestScores(
+		user.Id,
+		int(common.BeatmapStatusRanked),
+		server.State,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	bestScoresApproved, err := common.FetchBestScores(
+		user.Id,
+		int(common.BeatmapStatusApproved),
+		server.State,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	user.Stats.PP = UserPerformance(bestScoresRanked, bestScoresApproved)
+
+	if err = common.UpdateStats(&user.Stats, server.State); err != nil {
+		return err
+	}
+
+	if user.Restricted || len(bestScoresRanked)+len(bestScoresApproved) <= 0 {
+		// User has no rankings entries
+		return nil
+	}
+
+	return common.UpdateRankingsEntry(&user.Stats, user.Country, server.State)
+}
+
+func RoundPerformance(performance float64) float64 {
+	return math.Round(performance*1000) / 1000
+}
@@ -0,0 +1,567 @@
+package hscore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hexis-revival/hexagon/common"
+)
+
+const (
+	// Nominations needed for a beatmapset to become qualified
+	RequiredNominations = 2
+
+	// Time a beatmapset stays qualified before it can be ranked,
+	// to give everyone the chance to look for issues.
+	QualificationCooldown = 7 * 24 * time.Hour
+
+	PendingForumName   = "Pending Beatmaps"
+	QualifiedForumName = "Qualified Beatmaps"
+	RankedForumName    = "Ranked Beatmaps"
+)
+
+var (
+	ErrMissingPermission = errors.New("missing permission")
+	ErrInvalidStatus     = errors.New("invalid beatmapset status")
+	ErrOwnBeatmapset     = errors.New("cannot nominate own beatmapset")
+	ErrAlreadyNominated  = errors.New("beatmapset was already nominated by this user")
+	ErrCooldown          = errors.New("beatmapset is still in its qualification cooldown")
+	ErrMissingReason     = errors.New("missing reason")
+)
+
+// NominateBeatmapset adds a nomination to a pending beatmapset, which
+// becomes qualified once it has received enough nominations.
+func NominateBeatmapset(beatmapset *common.Beatmapset, user *common.User, server *ScoreServer) error {
+	if !user.HasPermission(common.PermissionNominate) {
+		return ErrMissingPermission
+	}
+
+	if beatmapset.CreatorId == user.Id {
+		return ErrOwnBeatmapset
+	}
+
+	qualified := false
+
+	err := server.Transaction(func(tx *ScoreServer) error {
+		if err := lockBeatmapset(beatmapset, tx); err != nil {
+			return err
+		}
+
+		if beatmapset.Status != common.BeatmapStatusPending || beatmapset.QualifiedAt != nil {
+			return ErrInvalidStatus
+		}
+
+		nominations, err := common.FetchBeatmapNominations(beatmapset.Id, tx.State)
+		if err != nil {
+			return err
+		}
+
+		for _, nomination := range nominations {
+			if nomination.UserId == user.Id {
+				return ErrAlreadyNominated
+			}
+		}
+
+		nomination := &common.BeatmapNomination{SetId: beatmapset.Id, UserId: user.Id}
+		if err = common.CreateBeatmapNomination(nomination, tx.State); err != nil {
+			return err
+		}
+
+		err = recordStatusChange(beatmapset, user, common.BeatmapActionNominate, beatmapset.Status, "", tx)
+		if err != nil {
+			return err
+		}
+
+		if len(nominations)+1 < RequiredNominations {
+			return nil
+		}
+
+		qualifiedAt := time.Now()
+		beatmapset.QualifiedAt = &qualifiedAt
+
+		if err = common.UpdateBeatmapsetStatus(beatmapset, tx.State); err != nil {
+			return err
+		}
+
+		qualified = true
+		return recordStatusChange(beatmapset, user, common.BeatmapActionQualify, beatmapset.Status, "", tx)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if qualified {
+		MoveBeatmapsetTopic(beatmapset, QualifiedForumName, server)
+	}
+
+	return nil
+}
+
+// DisqualifyBeatmapset resets the nominations of a nominated or
+// qualified beatmapset, which then has to be nominated again.
+func DisqualifyBeatmapset(beatmapset *common.Beatmapset, user *common.User, reason string, server *ScoreServer) error {
+	if user != nil && !user.HasPermission(common.PermissionNominate) {
+		return ErrMissingPermission
+	}
+
+	if reason == "" {
+		return ErrMissingReason
+	}
+
+	wasQualified := false
+
+	err := server.Transaction(func(tx *ScoreServer) error {
+		if err := lockBeatmapset(beatmapset, tx); err != nil {
+			return err
+		}
+
+		if beatmapset.Status != common.BeatmapStatusPending {
+			return ErrInvalidStatus
+		}
+
+		nominations, err := common.FetchBeatmapNominations(beatmapset.Id, tx.State)
+		if err != nil {
+			return err
+		}
+
+		if len(nominations) == 0 && beatmapset.QualifiedAt == nil {
+			return ErrInvalidStatus
+		}
+
+		if err = common.RemoveBeatmapNominations(beatmapset.Id, tx.State); err != nil {
+			return err
+		}
+
+		wasQualified = beatmapset.QualifiedAt != nil
+		beatmapset.QualifiedAt = nil
+
+		if err = common.UpdateBeatmapsetStatus(beatmapset, tx.State); err != nil {
+			return err
+		}
+
+		return recordStatusChange(beatmapset, user, common.BeatmapActionDisqualify, beatmapset.Status, reason, tx)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if wasQualified {
+		MoveBeatmapsetTopic(beatmapset, PendingForumName, server)
+	}
+
+	return nil
+}
+
+// RankBeatmapset ranks a qualified beatmapset, once its cooldown has passed.
+// The user may be nil, when the beatmapset is ranked automatically.
+func RankBeatmapset(beatmapset *common.Beatmapset, user *common.User, server *ScoreServer) error {
+	if user != nil && !user.HasPermission(common.PermissionRank) {
+		return ErrMissingPermission
+	}
+
+	var userIds []int
+
+	err := server.Transaction(func(tx *ScoreServer) error {
+		if err := lockBeatmapset(beatmapset, tx); err != nil {
+			return err
+		}
+
+		if beatmapset.Status != common.BeatmapStatusPending || beatmapset.QualifiedAt == nil {
+			return ErrInvalidStatus
+		}
+
+		if time.Since(*beatmapset.QualifiedAt) < QualificationCooldown {
+			return ErrCooldown
+		}
+
+		approvedBy := 0
+
+		if user != nil {
+			approvedBy = user.Id
+		} else {
+			// Credit the nominator that qualified the beatmapset
+			nominations, err := common.FetchBeatmapNominations(beatmapset.Id, tx.State)
+			if err != nil {
+				return err
+			}
+
+			if len(nominations) == 0 {
+				return ErrInvalidStatus
+			}
+
+			approvedBy = nominations[len(nominations)-1].UserId
+		}
+
+		err := updateBeatmapsetStatus(
+			beatmapset, user,
+			common.BeatmapStatusRanked,
+			common.BeatmapActionRank,
+			"", &approvedBy, tx,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		userIds, err = RebuildBeatmapsetLeaderboards(beatmapset.Id, tx)
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	MoveBeatmapsetTopic(beatmapset, RankedForumName, server)
+	refreshAffectedUsers(beatmapset, userIds, server)
+	return nil
+}
+
+// UnrankBeatmapset moves a ranked or approved beatmapset back to pending
+func UnrankBeatmapset(beatmapset *common.Beatmapset, user *common.User, reason string, server *ScoreServer) error {
+	if !user.HasPermission(common.PermissionRank) {
+		return ErrMissingPermission
+	}
+
+	if reason == "" {
+		return ErrMissingReason
+	}
+
+	var userIds []int
+
+	err := server.Transaction(func(tx *ScoreServer) error {
+		if err := lockBeatmapset(beatmapset, tx); err != nil {
+			return err
+		}
+
+		if beatmapset.Status < common.BeatmapStatusRanked {
+			return ErrInvalidStatus
+		}
+
+		if err := common.RemoveBeatmapNominations(beatmapset.Id, tx.State); err != nil {
+			return err
+		}
+
+		err := updateBeatmapsetStatus(
+			beatmapset, user,
+			common.BeatmapStatusPending,
+			common.BeatmapActionUnrank,
+			reason, nil, tx,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		userIds, err = RebuildBeatmapsetLeaderboards(beatmapset.Id, tx)
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	MoveBeatmapsetTopic(beatmapset, PendingForumName, server)
+	refreshAffectedUsers(beatmapset, userIds, server)
+	return nil
+}
+
+// RankQualifiedBeatmapsets ranks every qualified beatmapset, whose
+// cooldown has passed. It returns the amount of ranked beatmapsets.
+func RankQualifiedBeatmapsets(server *ScoreServer) (int, error) {
+	beatmapsets, err := common.FetchQualifiedBeatmapsets(
+		time.Now().Add(-QualificationCooldown),
+		server.State,
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	ranked := 0
+
+	for _, beatmapset := range beatmapsets {
+		if err = RankBeatmapset(beatmapset, nil, server); err != nil {
+			server.Logger.Warningf("Failed to rank beatmapset %d: %s", beatmapset.Id, err)
+			continue
+		}
+
+		server.Logger.Infof("Ranked beatmapset %d", beatmapset.Id)
+		ranked++
+	}
+
+	return ranked, nil
+}
+
+// IsBeatmapsetNominated returns whether a pending beatmapset
+// has received any nominations or is qualified
+func IsBeatmapsetNominated(beatmapset *common.Beatmapset, server *ScoreServer) (bool, error) {
+	if beatmapset.Status != common.BeatmapStatusPending {
+		return false, nil
+	}
+
+	if beatmapset.QualifiedAt != nil {
+		return true, nil
+	}
+
+	nominations, err := common.FetchBeatmapNominations(beatmapset.Id, server.State)
+	if err != nil {
+		return false, err
+	}
+
+	return len(nominations) > 0, nil
+}
+
+// refreshAffectedUsers updates the stats & rankings of all users with
+// scores on a beatmapset, after its leaderboards have been rebuilt.
+func refreshAffectedUsers(beatmapset *common.Beatmapset, userIds []int, server *ScoreServer) {
+	if err := RefreshUserStatistics(userIds, server); err != nil {
+		server.Logger.Warningf(
+			"Failed to refresh stats of %d users for beatmapset %d: %s",
+			len(userIds), beatmapset.Id, err,
+		)
+	}
+}
+
+// MoveBeatmapsetTopic moves the forum topic of a beatmapset into the forum
+// with the given name, if the beatmapset has a topic. Status changes don't
+// depend on it, so failures, e.g. a missing forum, are only logged.
+func MoveBeatmapsetTopic(beatmapset *common.Beatmapset, forumName string, server *ScoreServer) {
+	if beatmapset.TopicId == nil {
+		return
+	}
+
+	forum, err := common.FetchForumByName(forumName, server.State)
+	if err != nil {
+		server.Logger.Warningf("[Beatmap Ranking] Could not find forum '%s': %s", forumName, err)
+		return
+	}
+
+	topic, err := common.FetchTopicById(*beatmapset.TopicId, server.State)
+	if err != nil {
+		server.Logger.Warningf("[Beatmap Ranking] Could not find topic of beatmapset %d: %s", beatmapset.Id, err)
+		return
+	}
+
+	if topic.ForumId == forum.Id {
+		return
+	}
+
+	if err = common.MoveTopic(topic, forum.Id, server.State); err != nil {
+		server.Logger.Warningf("[Beatmap Ranking] Failed to move topic of beatmapset %d: %s", beatmapset.Id, err)
+	}
+}
+
+// lockBeatmapset locks the beatmapset inside of a transaction, and reloads
+// its status, which may have changed since it was fetched
+func lockBeatmapset(beatmapset *common.Beatmapset, server *ScoreServer) error {
+	locked, err := common.FetchBeatmapsetForUpdate(beatmapset.Id, server.State)
+	if err != nil {
+		return err
+	}
+
+	beatmapset.Status = locked.Status
+	beatmapset.QualifiedAt = locked.QualifiedAt
+	beatmapset.ApprovedAt = locked.ApprovedAt
+	beatmapset.ApprovedBy = locked.ApprovedBy
+	beatmapset.TopicId = locked.TopicId
+	return nil
+}
+
+// updateBeatmapsetStatus changes the status of a beatmapset & its beatmaps,
+// and records the change
+func updateBeatmapsetStatus(beatmapset *common.Beatmapset, user *common.User, status common.BeatmapStatus, action common.BeatmapStatusAction, reason string, approvedBy *int, server *ScoreServer) error {
+	previousStatus := beatmapset.Status
+	beatmapset.Status = status
+	beatmapset.QualifiedAt = nil
+	beatmapset.ApprovedBy = approvedBy
+	beatmapset.ApprovedAt = nil
+
+	if approvedBy != nil {
+		approvedAt := time.Now()
+		beatmapset.ApprovedAt = &approvedAt
+	}
+
+	if err := common.UpdateBeatmapsetStatus(beatmapset, server.State); err != nil {
+		return err
+	}
+
+	if err := common.UpdateBeatmapsStatusBySetId(beatmapset.Id, status, server.State); err != nil {
+		return err
+	}
+
+	return recordStatusChange(beatmapset, user, action, previousStatus, reason, server)
+}
+
+func recordStatusChange(beatmapset *common.Beatmapset, user *common.User, action common.BeatmapStatusAction, previousStatus common.BeatmapStatus, reason string, server *ScoreServer) error {
+	change := &common.BeatmapStatusChange{
+		SetId:          beatmapset.Id,
+		Action:         action,
+		PreviousStatus: previousStatus,
+		Status:         beatmapset.Status,
+		Reason:         reason,
+	}
+
+	if user != nil {
+		change.UserId = &user.Id
+	}
+
+	return common.CreateBeatmapStatusChange(change, server.State)
+}
+
+func BeatmapStatusHandler(ctx *Context) {
+	ctx.Response.Header().Set("Content-Type", "application/json")
+
+	request, err := NewBeatmapStatusRequest(ctx.Request)
+	if err != nil {
+		ctx.Server.Logger.Warningf("[Beatmap Ranking] Status request error: %s", err)
+		writeBeatmapStatusError(http.StatusBadRequest, err, ctx)
+		return
+	}
+
+	user, success := AuthenticateUser(
+		request.Username,
+		request.Password,
+		ctx.Server,
+	)
+
+	if !success {
+		writeBeatmapStatusError(http.StatusUnauthorized, errors.New("authentication failed"), ctx)
+		return
+	}
+
+	beatmapset, err := common.FetchBeatmapsetById(request.SetId, ctx.Server.State)
+	if err != nil {
+		writeBeatmapStatusError(http.StatusNotFound, errors.New("beatmapset not found"), ctx)
+		return
+	}
+
+	switch request.Action {
+	case common.BeatmapActionNominate:
+		err = NominateBeatmapset(beatmapset, user, ctx.Server)
+	case common.BeatmapActionDisqualify:
+		err = DisqualifyBeatmapset(beatmapset, user, request.Reason, ctx.Server)
+	case common.BeatmapActionRank:
+		err = RankBeatmapset(beatmapset, user, ctx.Server)
+	case common.BeatmapActionUnrank:
+		err = UnrankBeatmapset(beatmapset, user, request.Reason, ctx.Server)
+	default:
+		err = fmt.Errorf("invalid action: %s", request.Action)
+	}
+
+	switch {
+	case err == nil:
+		break
+	case errors.Is(err, ErrMissingPermission):
+		writeBeatmapStatusError(http.StatusForbidden, err, ctx)
+		return
+	case errors.Is(err, ErrInvalidStatus), errors.Is(err, ErrCooldown), errors.Is(err, ErrAlreadyNominated):
+		writeBeatmapStatusError(http.StatusConflict, err, ctx)
+		return
+	case errors.Is(err, ErrOwnBeatmapset), errors.Is(err, ErrMissingReason):
+		writeBeatmapStatusError(http.StatusBadRequest, err, ctx)
+		return
+	default:
+		ctx.Server.Logger.Errorf("[Beatmap Ranking] Failed to %s beatmapset %d: %s", request.Action, beatmapset.Id, err)
+		writeBeatmapStatusError(http.StatusInternalServerError, errors.New("internal server error"), ctx)
+		return
+	}
+
+	ctx.Server.Logger.Infof(
+		"[Beatmap Ranking] '%s' performed '%s' on beatmapset %d",
+		user.Name, request.Action, beatmapset.Id,
+	)
+
+	nominations, err := common.FetchBeatmapNominations(beatmapset.Id, ctx.Server.State)
+	if err != nil {
+		ctx.Server.Logger.Warningf("[Beatmap Ranking] Failed to fetch nominations: %s", err)
+	}
+
+	response := BeatmapStatusResponse{
+		Success:     true,
+		Status:      beatmapset.Status,
+		Qualified:   beatmapset.QualifiedAt != nil,
+		QualifiedAt: beatmapset.QualifiedAt,
+		Nominations: len(nominations),
+	}
+
+	json.NewEncoder(ctx.Response).Encode(response)
+}
+
+func BeatmapStatusHistoryHandler(ctx *Context) {
+	vars := mux.Vars(ctx.Request)
+
+	setId, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		ctx.Response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	changes, err := common.FetchBeatmapStatusChanges(setId, ctx.Server.State, "User")
+	if err != nil {
+		ctx.Server.Logger.Warningf("[Beatmap Ranking] Failed to fetch status history: %s", err)
+		ctx.Response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	responses := make([]*BeatmapStatusChangeResponse, 0, len(changes))
+
+	for _, change := range changes {
+		response := &BeatmapStatusChangeResponse{
+			Action:         change.Action,
+			PreviousStatus: change.PreviousStatus,
+			Status:         change.Status,
+			Reason:         change.Reason,
+			CreatedAt:      change.CreatedAt,
+		}
+
+		if change.User != nil {
+			response.User = change.User.Name
+		}
+
+		responses = append(responses, response)
+	}
+
+	ctx.Response.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(ctx.Response).Encode(responses)
+}
+
+func NewBeatmapStatusRequest(request *http.Request) (*BeatmapStatusRequest, error) {
+	setId, err := strconv.Atoi(mux.Vars(request)["id"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid beatmapset id")
+	}
+
+	username := request.FormValue("u")
+	password := request.FormValue("p")
+	action := request.FormValue("a")
+
+	if username == "" || password == "" {
+		return nil, fmt.Errorf("missing credentials")
+	}
+
+	if action == "" {
+		return nil, fmt.Errorf("missing action")
+	}
+
+	return &BeatmapStatusRequest{
+		Username: username,
+		Password: password,
+		SetId:    setId,
+		Action:   common.BeatmapStatusAction(action),
+		Reason:   strings.TrimSpace(request.FormValue("r")),
+	}, nil
+}
+
+func writeBeatmapStatusError(statusCode int, err error, ctx *Context) {
+	ctx.Response.WriteHeader(statusCode)
+	response := BeatmapStatusResponse{Success: false, Error: err.Error()}
+	json.NewEncoder(ctx.Response).Encode(response)
+}
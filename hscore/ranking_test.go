@@ -0,0 +1,315 @@
+package hscore
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hexis-revival/hexagon/common"
+)
+
+func newTestDatabaseServer(t *testing.T) *ScoreServer {
+	return &ScoreServer{
+		Logger: common.CreateLogger("test", common.QUIET),
+		State:  common.NewTestDatabaseState(t),
+	}
+}
+
+func createTestUser(t *testing.T, name string, permissions common.UserPermissions, server *ScoreServer) *common.User {
+	user := &common.User{
+		Name:        name,
+		Email:       fmt.Sprintf("%s@example.com", name),
+		Password:    "password",
+		Permissions: permissions,
+	}
+
+	if err := common.CreateUser(user, server.State); err != nil {
+		t.Fatalf("failed to create user '%s': %s", name, err)
+	}
+
+	return user
+}
+
+func createTestBeatmapset(t *testing.T, creator *common.User, server *ScoreServer) (*common.Beatmapset, *common.Beatmap) {
+	beatmapset := &common.Beatmapset{
+		Title:     "Title",
+		Artist:    "Artist",
+		CreatorId: creator.Id,
+		Status:    common.BeatmapStatusPending,
+	}
+
+	if err := common.CreateBeatmapset(beatmapset, server.State); err != nil {
+		t.Fatalf("failed to create beatmapset: %s", err)
+	}
+
+	beatmap := &common.Beatmap{
+		SetId:     beatmapset.Id,
+		Checksum:  fmt.Sprintf("%032d", beatmapset.Id),
+		Version:   "Normal",
+		Filename:  "beatmap.hbxml",
+		CreatorId: creator.Id,
+		Status:    common.BeatmapStatusPending,
+	}
+
+	if err := common.CreateBeatmap(beatmap, server.State); err != nil {
+		t.Fatalf("failed to create beatmap: %s", err)
+	}
+
+	return beatmapset, beatmap
+}
+
+// qualifyTestBeatmapset nominates a beatmapset until it is qualified,
+// and moves the qualification back to before its cooldown
+func qualifyTestBeatmapset(t *testing.T, beatmapset *common.Beatmapset, server *ScoreServer) {
+	for i := 0; i < RequiredNominations; i++ {
+		nominator := createTestUser(t, fmt.Sprintf("qualifier%d", i), common.PermissionNominate, server)
+
+		if err := NominateBeatmapset(beatmapset, nominator, server); err != nil {
+			t.Fatalf("failed to nominate beatmapset: %s", err)
+		}
+	}
+
+	qualifiedAt := time.Now().Add(-QualificationCooldown - time.Hour)
+	beatmapset.QualifiedAt = &qualifiedAt
+
+	if err := common.UpdateBeatmapsetStatus(beatmapset, server.State); err != nil {
+		t.Fatalf("failed to update qualification: %s", err)
+	}
+}
+
+func expectStatusActions(t *testing.T, setId int, expected []common.BeatmapStatusAction, server *ScoreServer) {
+	changes, err := common.FetchBeatmapStatusChanges(setId, server.State)
+	if err != nil {
+		t.Fatalf("failed to fetch status changes: %s", err)
+	}
+
+	actions := make([]common.BeatmapStatusAction, 0, len(changes))
+	for _, change := range changes {
+		actions = append(actions, change.Action)
+	}
+
+	if fmt.Sprint(actions) != fmt.Sprint(expected) {
+		t.Fatalf("expected status changes %v, got %v", expected, actions)
+	}
+}
+
+func TestNominateBeatmapset(t *testing.T) {
+	server := newTestDatabaseServer(t)
+	creator := createTestUser(t, "creator", common.PermissionNominate, server)
+	first := createTestUser(t, "first", common.PermissionNominate, server)
+	second := createTestUser(t, "second", common.PermissionNominate, server)
+	player := createTestUser(t, "player", 0, server)
+	beatmapset, _ := createTestBeatmapset(t, creator, server)
+
+	if err := NominateBeatmapset(beatmapset, player, server); !errors.Is(err, ErrMissingPermission) {
+		t.Fatalf("expected missing permission, got %v", err)
+	}
+
+	if err := NominateBeatmapset(beatmapset, creator, server); !errors.Is(err, ErrOwnBeatmapset) {
+		t.Fatalf("expected own beatmapset error, got %v", err)
+	}
+
+	if err := NominateBeatmapset(beatmapset, first, server); err != nil {
+		t.Fatalf("failed to nominate beatmapset: %s", err)
+	}
+
+	if beatmapset.QualifiedAt != nil {
+		t.Fatalf("expected beatmapset to need %d nominations", RequiredNominations)
+	}
+
+	if err := NominateBeatmapset(beatmapset, first, server); !errors.Is(err, ErrAlreadyNominated) {
+		t.Fatalf("expected duplicate nomination error, got %v", err)
+	}
+
+	if err := NominateBeatmapset(beatmapset, second, server); err != nil {
+		t.Fatalf("failed to nominate beatmapset: %s", err)
+	}
+
+	stored, err := common.FetchBeatmapsetById(beatmapset.Id, server.State)
+	if err != nil {
+		t.Fatalf("failed to fetch beatmapset: %s", err)
+	}
+
+	if stored.QualifiedAt == nil || stored.Status != common.BeatmapStatusPending {
+		t.Fatalf("expected qualified pending beatmapset, got status %d", stored.Status)
+	}
+
+	// Qualified beatmapsets can't be nominated any further
+	third := createTestUser(t, "third", common.PermissionNominate, server)
+	if err = NominateBeatmapset(beatmapset, third, server); !errors.Is(err, ErrInvalidStatus) {
+		t.Fatalf("expected invalid status, got %v", err)
+	}
+
+	expectStatusActions(t, beatmapset.Id, []common.BeatmapStatusAction{
+		common.BeatmapActionNominate,
+		common.BeatmapActionNominate,
+		common.BeatmapActionQualify,
+	}, server)
+}
+
+func TestDisqualifyBeatmapset(t *testing.T) {
+	server := newTestDatabaseServer(t)
+	creator := createTestUser(t, "creator", 0, server)
+	nominator := createTestUser(t, "nominator", common.PermissionNominate, server)
+	beatmapset, _ := createTestBeatmapset(t, creator, server)
+
+	if err := DisqualifyBeatmapset(beatmapset, nominator, "issues", server); !errors.Is(err, ErrInvalidStatus) {
+		t.Fatalf("expected beatmapset without nominations to be invalid, got %v", err)
+	}
+
+	qualifyTestBeatmapset(t, beatmapset, server)
+
+	if err := DisqualifyBeatmapset(beatmapset, nominator, "", server); !errors.Is(err, ErrMissingReason) {
+		t.Fatalf("expected missing reason, got %v", err)
+	}
+
+	if err := DisqualifyBeatmapset(beatmapset, nominator, "issues", server); err != nil {
+		t.Fatalf("failed to disqualify beatmapset: %s", err)
+	}
+
+	nominations, err := common.FetchBeatmapNominations(beatmapset.Id, server.State)
+	if err != nil {
+		t.Fatalf("failed to fetch nominations: %s", err)
+	}
+
+	if len(nominations) != 0 {
+		t.Fatalf("expected nominations to be reset, got %d", len(nominations))
+	}
+
+	stored, err := common.FetchBeatmapsetById(beatmapset.Id, server.State)
+	if err != nil {
+		t.Fatalf("failed to fetch beatmapset: %s", err)
+	}
+
+	if stored.QualifiedAt != nil {
+		t.Fatal("expected qualification to be reset")
+	}
+
+	// Previous nominators can nominate the beatmapset again
+	if err = NominateBeatmapset(beatmapset, nominator, server); err != nil {
+		t.Fatalf("failed to nominate disqualified beatmapset: %s", err)
+	}
+}
+
+func TestRankBeatmapset(t *testing.T) {
+	server := newTestDatabaseServer(t)
+	creator := createTestUser(t, "creator", 0, server)
+	nominator := createTestUser(t, "nominator", common.PermissionNominate, server)
+	ranker := createTestUser(t, "ranker", common.PermissionRank, server)
+	beatmapset, beatmap := createTestBeatmapset(t, creator, server)
+
+	if err := RankBeatmapset(beatmapset, ranker, server); !errors.Is(err, ErrInvalidStatus) {
+		t.Fatalf("expected unqualified beatmapset to be invalid, got %v", err)
+	}
+
+	qualifyTestBeatmapset(t, beatmapset, server)
+
+	if err := RankBeatmapset(beatmapset, nominator, server); !errors.Is(err, ErrMissingPermission) {
+		t.Fatalf("expected missing permission, got %v", err)
+	}
+
+	qualifiedAt := time.Now()
+	beatmapset.QualifiedAt = &qualifiedAt
+
+	if err := common.UpdateBeatmapsetStatus(beatmapset, server.State); err != nil {
+		t.Fatalf("failed to update qualification: %s", err)
+	}
+
+	if err := RankBeatmapset(beatmapset, ranker, server); !errors.Is(err, ErrCooldown) {
+		t.Fatalf("expected cooldown error, got %v", err)
+	}
+
+	qualifiedAt = time.Now().Add(-QualificationCooldown - time.Hour)
+	beatmapset.QualifiedAt = &qualifiedAt
+
+	if err := common.UpdateBeatmapsetStatus(beatmapset, server.State); err != nil {
+		t.Fatalf("failed to update qualification: %s", err)
+	}
+
+	if err := RankBeatmapset(beatmapset, ranker, server); err != nil {
+		t.Fatalf("failed to rank beatmapset: %s", err)
+	}
+
+	stored, err := common.FetchBeatmapsetById(beatmapset.Id, server.State)
+	if err != nil {
+		t.Fatalf("failed to fetch beatmapset: %s", err)
+	}
+
+	if stored.Status != common.BeatmapStatusRanked || stored.QualifiedAt != nil {
+		t.Fatalf("expected ranked beatmapset, got status %d", stored.Status)
+	}
+
+	if stored.ApprovedBy == nil || *stored.ApprovedBy != ranker.Id || stored.ApprovedAt == nil {
+		t.Fatalf("expected beatmapset to be approved by %d, got %v", ranker.Id, stored.ApprovedBy)
+	}
+
+	storedBeatmap, err := common.FetchBeatmapById(beatmap.Id, server.State)
+	if err != nil {
+		t.Fatalf("failed to fetch beatmap: %s", err)
+	}
+
+	if storedBeatmap.Status != common.BeatmapStatusRanked {
+		t.Fatalf("expected ranked beatmap, got status %d", storedBeatmap.Status)
+	}
+}
+
+func TestUnrankBeatmapset(t *testing.T) {
+	server := newTestDatabaseServer(t)
+	creator := createTestUser(t, "creator", 0, server)
+	ranker := createTestUser(t, "ranker", common.PermissionRank, server)
+	beatmapset, beatmap := createTestBeatmapset(t, creator, server)
+
+	if err := UnrankBeatmapset(beatmapset, ranker, "issues", server); !errors.Is(err, ErrInvalidStatus) {
+		t.Fatalf("expected pending beatmapset to be invalid, got %v", err)
+	}
+
+	qualifyTestBeatmapset(t, beatmapset, server)
+
+	if err := RankBeatmapset(beatmapset, ranker, server); err != nil {
+		t.Fatalf("failed to rank beatmapset: %s", err)
+	}
+
+	if err := UnrankBeatmapset(beatmapset, ranker, "", server); !errors.Is(err, ErrMissingReason) {
+		t.Fatalf("expected missing reason, got %v", err)
+	}
+
+	if err := UnrankBeatmapset(beatmapset, ranker, "issues", server); err != nil {
+		t.Fatalf("failed to unrank beatmapset: %s", err)
+	}
+
+	stored, err := common.FetchBeatmapsetById(beatmapset.Id, server.State)
+	if err != nil {
+		t.Fatalf("failed to fetch beatmapset: %s", err)
+	}
+
+	if stored.Status != common.BeatmapStatusPending || stored.ApprovedBy != nil || stored.ApprovedAt != nil {
+		t.Fatalf("expected unapproved pending beatmapset, got status %d", stored.Status)
+	}
+
+	storedBeatmap, err := common.FetchBeatmapById(beatmap.Id, server.State)
+	if err != nil {
+		t.Fatalf("failed to fetch beatmap: %s", err)
+	}
+
+	if storedBeatmap.Status != common.BeatmapStatusPending {
+		t.Fatalf("expected pending beatmap, got status %d", storedBeatmap.Status)
+	}
+
+	nominations, err := common.FetchBeatmapNominations(beatmapset.Id, server.State)
+	if err != nil {
+		t.Fatalf("failed to fetch nominations: %s", err)
+	}
+
+	if len(nominations) != 0 {
+		t.Fatalf("expected nominations to be removed, got %d", len(nominations))
+	}
+
+	expectStatusActions(t, beatmapset.Id, []common.BeatmapStatusAction{
+		common.BeatmapActionNominate,
+		common.BeatmapActionNominate,
+		common.BeatmapActionQualify,
+		common.BeatmapActionRank,
+		common.BeatmapActionUnrank,
+	}, server)
+}
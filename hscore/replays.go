@@ -1,9 +1,12 @@
 package hscore
 
 import (
+	"encoding/binary"
 	"fmt"
 	"net/http"
 	"strconv"
+
+	"github.com/hexis-revival/hexagon/common"
 )
 
 func ReplayDownloadHandler(ctx *Context) {
@@ -14,7 +17,7 @@ func ReplayDownloadHandler(ctx *Context) {
 		return
 	}
 
-	_, success := AuthenticateUser(
+	user, success := AuthenticateUser(
 		request.Username,
 		request.Password,
 		ctx.Server,
@@ -26,15 +29,112 @@ func ReplayDownloadHandler(ctx *Context) {
 		return
 	}
 
-	file, err := ctx.Server.State.Storage.GetReplayFile(request.ScoreId)
+	score, replay, err := FetchScoreReplay(request.ScoreId, ctx.Server)
 	if err != nil {
-		ctx.Server.Logger.Errorf("Failed to get replay file: %s", err)
+		ctx.Server.Logger.Warningf("Failed to fetch replay: %s", err)
 		ctx.Response.WriteHeader(http.StatusNotFound)
 		return
 	}
 
+	stream := common.NewIOStream([]byte{}, binary.BigEndian)
+	replay.Serialize(stream)
+
+	if user.Id != score.UserId {
+		err = common.IncrementReplayViews(score.Id, ctx.Server.State)
+		if err != nil {
+			ctx.Server.Logger.Warningf("Failed to update replay views: %s", err)
+		}
+	}
+
+	ctx.Server.Logger.Debugf(
+		"Replay download for score %d by '%s'",
+		score.Id, user.Name,
+	)
+
+	ctx.Response.Header().Set("Content-Type", "application/octet-stream")
 	ctx.Response.WriteHeader(http.StatusOK)
-	ctx.Response.Write(file)
+	ctx.Response.Write(stream.Get())
+}
+
+func ReplayExportHandler(ctx *Context) {
+	request, err := NewReplayExportRequest(*ctx.Request)
+	if err != nil {
+		ctx.Server.Logger.Errorf("Failed to parse replay export request: %s", err)
+		ctx.Response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	user, success := AuthenticateUser(
+		request.Username,
+		request.Password,
+		ctx.Server,
+	)
+
+	if !success {
+		ctx.Server.Logger.Warningf("Failed to authenticate user '%s'", request.Username)
+		ctx.Response.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	score, replay, err := FetchScoreReplay(request.ScoreId, ctx.Server)
+	if err != nil {
+		ctx.Server.Logger.Warningf("Failed to fetch replay: %s", err)
+		ctx.Response.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var data []byte
+	var contentType string
+
+	switch request.Format {
+	case ReplayExportCSV:
+		data, err = replay.ExportFramesCSV()
+		contentType = "text/csv"
+	default:
+		data, err = replay.ExportJSON()
+		contentType = "application/json"
+	}
+
+	if err != nil {
+		ctx.Server.Logger.Errorf("Failed to export replay: %s", err)
+		ctx.Response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	ctx.Server.Logger.Debugf(
+		"Replay export (%s) for score %d by '%s'",
+		request.Format, score.Id, user.Name,
+	)
+
+	ctx.Response.Header().Set("Content-Type", contentType)
+	ctx.Response.Header().Set(
+		"Content-Disposition",
+		common.FormatContentDisposition(fmt.Sprintf("%d.%s", score.Id, request.Format)),
+	)
+	ctx.Response.WriteHeader(http.StatusOK)
+	ctx.Response.Write(data)
+}
+
+// FetchScoreReplay resolves a score and its complete replay. Unlike packages,
+// replays can't be downloaded from presigned urls, since only their frames
+// are stored and the rest is rebuilt from the score on every download.
+func FetchScoreReplay(scoreId int, server *ScoreServer) (*common.Score, *common.ReplayData, error) {
+	score, err := common.FetchScoreById(
+		scoreId,
+		server.State,
+		"Beatmap", "User",
+	)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	replay, err := common.LoadScoreReplay(score, server.State.Storage)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return score, replay, nil
 }
 
 func NewReplayDownloadRequest(request http.Request) (*ReplayDownloadRequest, error) {
@@ -65,4 +165,27 @@ func NewReplayDownloadRequest(request http.Request) (*ReplayDownloadRequest, err
 		Password: password,
 		ScoreId:  scoreIdInt,
 	}, nil
-}
\ No newline at end of file
+}
+
+func NewReplayExportRequest(request http.Request) (*ReplayExportRequest, error) {
+	downloadRequest, err := NewReplayDownloadRequest(request)
+	if err != nil {
+		return nil, err
+	}
+
+	format := request.URL.Query().Get("f")
+	if format == "" {
+		format = ReplayExportJSON
+	}
+
+	if format != ReplayExportJSON && format != ReplayExportCSV {
+		return nil, fmt.Errorf("invalid export format: %s", format)
+	}
+
+	return &ReplayExportRequest{
+		Username: downloadRequest.Username,
+		Password: downloadRequest.Password,
+		ScoreId:  downloadRequest.ScoreId,
+		Format:   format,
+	}, nil
+}
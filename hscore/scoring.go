@@ -6,9 +6,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math"
 	"net/http"
 	"regexp"
+	"slices"
 
 	"github.com/hexis-revival/hexagon/common"
 )
@@ -103,7 +103,9 @@ func ValidateScore(user *common.User, beatmap *common.Beatmap, request *ScoreSub
 	return true, errors.New("could not find hexis inside process list")
 }
 
-func InsertScore(user *common.User, beatmap *common.Beatmap, scoreData *ScoreData, server *ScoreServer) (*common.Score, error) {
+// InsertScore stores a new score, and demotes the previous personal best
+// if it was beaten. The previous personal best is returned alongside it.
+func InsertScore(user *common.User, beatmap *common.Beatmap, scoreData *ScoreData, server *ScoreServer) (*common.Score, *common.Score, error) {
 	score := &common.Score{
 		BeatmapId:     beatmap.Id,
 		UserId:        user.Id,
@@ -134,15 +136,22 @@ func InsertScore(user *common.User, beatmap *common.Beatmap, scoreData *ScoreDat
 
 	if beatmap.Status < common.BeatmapStatusRanked {
 		// Beatmap is unranked, insert unranked score
-		return score, common.CreateScore(score, server.State)
+		return score, nil, common.CreateScore(score, server.State)
 	}
 
 	if !score.Passed {
 		// User did not pass this map, insert failed score
 		score.Status = common.ScoreStatusFailed
-		return score, common.CreateScore(score, server.State)
+		return score, nil, common.CreateScore(score, server.State)
 	}
 
+	performance, err := ScorePerformance(score, beatmap, server)
+	if err != nil {
+		// Score will be picked up by the next pp recalculation
+		server.Logger.Warningf("Failed to calculate pp for score on beatmap %d: %v", beatmap.Id, err)
+	}
+
+	score.PP = performance
 	personalBest, err := common.FetchPersonalBest(
 		user.Id,
 		beatmap.Id,
@@ -150,19 +159,20 @@ func InsertScore(user *common.User, beatmap *common.Beatmap, scoreData *ScoreDat
 	)
 
 	if err != nil && err.Error() != "record not found" {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if personalBest == nil {
 		// No personal best, insert score as PB
 		score.Status = common.ScoreStatusPB
-		return score, common.CreateScore(score, server.State)
+		return score, nil, common.CreateScore(score, server.State)
 	}
 
-	if personalBest.TotalScore > score.TotalScore {
-		// New score is lower than PB, insert as submitted
+	if personalBest.TotalScore >= score.TotalScore {
+		// New score doesn't beat the PB, which keeps ties like on the
+		// leaderboards, so insert it as submitted
 		score.Status = common.ScoreStatusSubmitted
-		return score, common.CreateScore(score, server.State)
+		return score, nil, common.CreateScore(score, server.State)
 	}
 
 	// A new personal best has been achieved
@@ -170,10 +180,173 @@ func InsertScore(user *common.User, beatmap *common.Beatmap, scoreData *ScoreDat
 	personalBest.Status = common.ScoreStatusSubmitted
 
 	if err = common.UpdateScore(personalBest, server.State); err != nil {
+		return nil, nil, err
+	}
+
+	return score, personalBest, common.CreateScore(score, server.State)
+}
+
+// SubmitScore inserts the score, demotes the previous personal best,
+// uploads the replay & updates the user's stats in a single transaction.
+// The user's stats row stays locked until the transaction ends, so that
+// concurrent submissions can't both become a personal best. If anything
+// fails, the uploaded replay is removed again.
+//
+// Clients retry submissions on timeouts, so a score whose checksum was
+// already submitted by the user is returned as is, with duplicate = true.
+func SubmitScore(user *common.User, beatmap *common.Beatmap, request *ScoreSubmissionRequest, server *ScoreServer) (score *common.Score, duplicate bool, err error) {
+	if err := user.EnsureStats(server.State); err != nil {
+		return nil, false, err
+	}
+
+	replayUploaded := false
+
+	err = server.Transaction(func(tx *ScoreServer) error {
+		stats, err := common.FetchStatsForUpdate(user.Id, tx.State)
+		if err != nil {
+			return err
+		}
+
+		// Stats may have changed while waiting for the lock
+		user.Stats = *stats
+
+		score, err = common.FetchScoreByChecksum(user.Id, request.ScoreData.ScoreChecksum, tx.State)
+		if err == nil {
+			duplicate = true
+			return nil
+		}
+
+		if err.Error() != "record not found" {
+			return err
+		}
+
+		var previousBest *common.Score
+		score, previousBest, err = InsertScore(user, beatmap, request.ScoreData, tx)
+		if err != nil {
+			return fmt.Errorf("failed to insert score: %w", err)
+		}
+
+		if score.Passed {
+			err = UploadReplay(score.Id, request.ReplayFrames, tx.State.Storage)
+			if err != nil {
+				return fmt.Errorf("failed to upload replay: %w", err)
+			}
+			replayUploaded = true
+		}
+
+		err = UpdateUserStatistics(request.ScoreData, score, previousBest, beatmap, user, tx)
+		if err != nil {
+			return fmt.Errorf("failed to update user statistics: %w", err)
+		}
+
+		return nil
+	})
+
+	if err == nil {
+		return score, duplicate, nil
+	}
+
+	if replayUploaded {
+		if removeErr := server.State.Storage.RemoveReplayFile(score.Id); removeErr != nil {
+			server.Logger.Errorf("Failed to remove replay of rolled back score: %v", removeErr)
+		}
+	}
+
+	if common.IsUniqueViolation(err) {
+		// A concurrent submission of the same score was inserted first
+		score, fetchErr := common.FetchScoreByChecksum(user.Id, request.ScoreData.ScoreChecksum, server.State)
+		if fetchErr == nil {
+			return score, true, nil
+		}
+	}
+
+	return nil, false, err
+}
+
+// RemoveDuplicateScores deletes resubmitted copies of the same score, that
+// were stored before submissions were deduplicated by checksum, along with
+// their replays, and recalculates the stats of their users. The personal
+// best is always kept, so leaderboards stay the same.
+func RemoveDuplicateScores(server *ScoreServer) ([]*common.Score, error) {
+	var duplicates []*common.Score
+	users := []*common.User{}
+
+	err := server.Transaction(func(tx *ScoreServer) error {
+		var err error
+		duplicates, err = common.FetchDuplicateScores(tx.State)
+		if err != nil || len(duplicates) == 0 {
+			return err
+		}
+
+		scoreIds := make([]int, 0, len(duplicates))
+		userIds := []int{}
+
+		for _, score := range duplicates {
+			scoreIds = append(scoreIds, score.Id)
+
+			if !slices.Contains(userIds, score.UserId) {
+				userIds = append(userIds, score.UserId)
+			}
+		}
+
+		// Keep submissions from changing the stats in the meantime
+		for _, userId := range userIds {
+			user, err := common.FetchUserById(userId, tx.State)
+			if err != nil {
+				return err
+			}
+
+			if err = user.EnsureStats(tx.State); err != nil {
+				return err
+			}
+
+			stats, err := common.FetchStatsForUpdate(user.Id, tx.State)
+			if err != nil {
+				return err
+			}
+
+			user.Stats = *stats
+			users = append(users, user)
+		}
+
+		if err = common.DeleteScores(scoreIds, tx.State); err != nil {
+			return err
+		}
+
+		for _, user := range users {
+			stats, err := CalculateUserStatistics(user, tx)
+			if err != nil {
+				return err
+			}
+
+			user.Stats = *stats
+
+			if err = common.UpdateStats(&user.Stats, tx.State); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
 		return nil, err
 	}
 
-	return score, common.CreateScore(score, server.State)
+	// Replays & rankings can only be updated once the scores are gone
+	collection := common.NewErrorCollection()
+
+	for _, score := range duplicates {
+		if score.Passed {
+			collection.Add(server.State.Storage.RemoveReplayFile(score.Id))
+		}
+	}
+
+	for _, user := range users {
+		collection.Add(UpdateUserRankings(user, server))
+	}
+
+	return duplicates, collection.Next()
 }
 
 func UploadReplay(scoreId int, frames []*common.ReplayFrame, storage common.Storage) error {
@@ -183,11 +356,150 @@ func UploadReplay(scoreId int, frames []*common.ReplayFrame, storage common.Stor
 	return storage.SaveReplayFile(scoreId, stream.Get())
 }
 
-func UpdateUserStatistics(scoreData *ScoreData, user *common.User, server *ScoreServer) (err error) {
+func AnalyzeReplay(score *common.Score, frames []*common.ReplayFrame, server *ScoreServer) error {
+	flags := common.AnalyzeReplayFrames(
+		frames,
+		common.PlaybackRate(score.PSOffset),
+	)
+
+	return StoreScoreFlags(score, flags, server)
+}
+
+// CheckReplayTheft compares the replay against the closest replays of
+// other users on the same beatmap, and stores its fingerprint afterwards.
+func CheckReplayTheft(score *common.Score, frames []*common.ReplayFrame, server *ScoreServer) error {
+	fingerprint := common.NewReplayFingerprint(frames)
+	centroidX, centroidY := fingerprint.Centroid()
+
+	existingFingerprints, err := common.FetchScoreFingerprints(
+		score.BeatmapId,
+		score.UserId,
+		centroidX, centroidY,
+		server.State,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	flags := []*common.ScoreFlag{}
+
+	for _, existing := range existingFingerprints {
+		other, err := common.ReadReplayFingerprint(existing.Fingerprint)
+		if err != nil {
+			server.Logger.Warningf("Invalid fingerprint for score %d: %v", existing.ScoreId, err)
+			continue
+		}
+
+		distance, ok := fingerprint.MeanDistance(other)
+		if !ok || distance >= common.FingerprintSimilarityThreshold {
+			continue
+		}
+
+		flags = append(flags, &common.ScoreFlag{
+			Type: common.ScoreFlagReplayTheft,
+			Details: fmt.Sprintf(
+				"near-duplicate of score %d by user %d (mean distance %.2fpx)",
+				existing.ScoreId, existing.UserId, distance,
+			),
+		})
+	}
+
+	if err = StoreScoreFlags(score, flags, server); err != nil {
+		return err
+	}
+
+	return common.CreateScoreFingerprint(
+		&common.ScoreFingerprint{
+			ScoreId:     score.Id,
+			BeatmapId:   score.BeatmapId,
+			UserId:      score.UserId,
+			CentroidX:   centroidX,
+			CentroidY:   centroidY,
+			Fingerprint: fingerprint.Serialize(),
+		},
+		server.State,
+	)
+}
+
+func StoreScoreFlags(score *common.Score, flags []*common.ScoreFlag, server *ScoreServer) error {
+	for _, flag := range flags {
+		flag.ScoreId = score.Id
+		flag.UserId = score.UserId
+
+		server.Logger.Anomalyf(
+			"(%d) Replay of score %d flagged as '%s': %s",
+			score.UserId, score.Id, flag.Type, flag.Details,
+		)
+	}
+
+	return common.CreateScoreFlags(flags, server.State)
+}
+
+// UpdateUserStatistics applies a submitted score to the stats of a user.
+// Score-derived stats are updated from the difference between the new &
+// previous personal best, so that the cost doesn't grow with play history.
+func UpdateUserStatistics(scoreData *ScoreData, score *common.Score, previousBest *common.Score, beatmap *common.Beatmap, user *common.User, server *ScoreServer) error {
 	user.Stats.TotalScore += int64(scoreData.TotalScore)
 	user.Stats.TotalHits += int64(scoreData.TotalHits())
 	user.Stats.Playcount += 1
 
+	if score.Status != common.ScoreStatusPB {
+		// Personal bests are unchanged
+		return common.UpdateStats(&user.Stats, server.State)
+	}
+
+	user.Stats.ApplyPersonalBest(previousBest, score, beatmap.Status)
+
+	accuracy, err := common.FetchBestAccuracy(user.Id, server.State)
+	if err != nil {
+		return err
+	}
+	user.Stats.Accuracy = common.RoundAccuracy(accuracy)
+
+	if previousBest != nil && previousBest.MaxCombo > score.MaxCombo && previousBest.MaxCombo >= user.Stats.MaxCombo {
+		// Previous personal best may have held the highest combo
+		maxCombo, err := common.FetchBestMaxCombo(user.Id, server.State)
+		if err != nil {
+			return err
+		}
+		user.Stats.MaxCombo = maxCombo
+	}
+
+	if score.PP > 0 || (previousBest != nil && previousBest.PP > 0) {
+		// Only the top scores are weighted into the pp total
+		bestScores, err := common.FetchTopPerformanceScores(
+			user.Id,
+			common.PerformanceMaxScores,
+			server.State,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		user.Stats.PP = UserPerformance(bestScores)
+	}
+
+	return common.UpdateStats(&user.Stats, server.State)
+}
+
+// CalculateUserStatistics recalculates the stats of a user from all of
+// their scores. Submissions update stats incrementally, so this is only
+// used to verify & rebuild them. Playtime & rank are left as they are.
+func CalculateUserStatistics(user *common.User, server *ScoreServer) (*common.Stats, error) {
+	stats := user.Stats
+	stats.UserId = user.Id
+
+	totalScore, totalHits, playcount, err := common.FetchScoreTotals(user.Id, server.State)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.TotalScore = totalScore
+	stats.TotalHits = totalHits
+	stats.Playcount = playcount
+
 	bestScoresRanked, err := common.FetchBestScores(
 		user.Id,
 		int(common.BeatmapStatusRanked),
@@ -195,7 +507,7 @@ func UpdateUserStatistics(scoreData *ScoreData, user *common.User, server *Score
 	)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	bestScoresApproved, err := common.FetchBestScores(
@@ -205,20 +517,17 @@ func UpdateUserStatistics(scoreData *ScoreData, user *common.User, server *Score
 	)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	totalScores := len(bestScoresRanked) + len(bestScoresApproved)
 
-	if totalScores <= 0 {
-		// User has not set any scores yet
-		return nil
-	}
-
-	user.Stats.RankedScore = 0
+	stats.RankedScore = 0
+	stats.Accuracy = 0
+	stats.PP = UserPerformance(bestScoresRanked, bestScoresApproved)
 
 	for _, score := range bestScoresRanked {
-		user.Stats.RankedScore += score.TotalScore
+		stats.RankedScore += score.TotalScore
 	}
 
 	gradeMap := map[common.Grade]int{
@@ -253,16 +562,41 @@ func UpdateUserStatistics(scoreData *ScoreData, user *common.User, server *Score
 		}
 	}
 
-	user.Stats.MaxCombo = maxCombo
-	user.Stats.Accuracy = math.Round(accuracySum/float64(totalScores)*1e6) / 1e6
-	user.Stats.XHCount = gradeMap[common.GradeXH]
-	user.Stats.XCount = gradeMap[common.GradeX]
-	user.Stats.SHCount = gradeMap[common.GradeSH]
-	user.Stats.SCount = gradeMap[common.GradeS]
-	user.Stats.ACount = gradeMap[common.GradeA]
-	user.Stats.BCount = gradeMap[common.GradeB]
-	user.Stats.CCount = gradeMap[common.GradeC]
-	user.Stats.DCount = gradeMap[common.GradeD]
+	if totalScores > 0 {
+		stats.Accuracy = common.RoundAccuracy(accuracySum / float64(totalScores))
+	}
+
+	stats.MaxCombo = maxCombo
+	stats.XHCount = gradeMap[common.GradeXH]
+	stats.XCount = gradeMap[common.GradeX]
+	stats.SHCount = gradeMap[common.GradeSH]
+	stats.SCount = gradeMap[common.GradeS]
+	stats.ACount = gradeMap[common.GradeA]
+	stats.BCount = gradeMap[common.GradeB]
+	stats.CCount = gradeMap[common.GradeC]
+	stats.DCount = gradeMap[common.GradeD]
+
+	return &stats, nil
+}
+
+// UpdateUserRankings writes the stats of a user into the rankings and
+// stores their new rank. It should run once the stats are committed.
+func UpdateUserRankings(user *common.User, server *ScoreServer) (err error) {
+	if user.Restricted || user.Stats.Clears() <= 0 {
+		// User is restricted or has no personal bests (anymore)
+		if user.Stats.Rank == common.RankUnranked {
+			return nil
+		}
+
+		user.Stats.Rank = common.RankUnranked
+
+		err = common.RemoveRankingsEntry(&user.Stats, user.Country, server.State)
+		if err != nil {
+			server.Logger.Errorf("Failed to remove rankings entry: %v", err)
+		}
+
+		return common.UpdateStatsRank(user.Id, user.Stats.Rank, server.State)
+	}
 
 	err = common.UpdateRankingsEntry(&user.Stats, user.Country, server.State)
 	if err != nil {
@@ -274,7 +608,30 @@ func UpdateUserStatistics(scoreData *ScoreData, user *common.User, server *Score
 		server.Logger.Errorf("Failed to get user rank: %v", err)
 	}
 
-	return common.UpdateStats(&user.Stats, server.State)
+	return common.UpdateStatsRank(user.Id, user.Stats.Rank, server.State)
+}
+
+// SetUserRestricted restricts or unrestricts a user, and removes
+// them from or adds them back into the rankings
+func SetUserRestricted(user *common.User, restricted bool, server *ScoreServer) error {
+	if err := common.UpdateUserRestricted(user.Id, restricted, server.State); err != nil {
+		return err
+	}
+
+	user.Restricted = restricted
+
+	if !restricted {
+		return UpdateUserRankings(user, server)
+	}
+
+	user.Stats.Rank = common.RankUnranked
+
+	err := common.RemoveRankingsEntry(&user.Stats, user.Country, server.State)
+	if err != nil {
+		return err
+	}
+
+	return common.UpdateStatsRank(user.Id, user.Stats.Rank, server.State)
 }
 
 func WriteError(statusCode int, errorMessage string, ctx *Context) error {
@@ -338,40 +695,98 @@ func ScoreSubmissionHandler(ctx *Context) {
 		}
 	}
 
-	score, err := InsertScore(
-		user, beatmap,
-		request.ScoreData,
-		ctx.Server,
-	)
+	previousStats := user.Stats
+	previousRank, err := common.GetScoreRank(user.Id, ctx.Server.State)
+	if err != nil {
+		ctx.Server.Logger.Warningf("Error fetching user rank: %v", err)
+	}
+
+	score, duplicate, err := SubmitScore(user, beatmap, request, ctx.Server)
 
 	if err != nil {
-		ctx.Server.Logger.Warningf("Error inserting score: %v", err)
+		ctx.Server.Logger.Warningf("Error submitting score: %v", err)
 		WriteError(http.StatusInternalServerError, ServerError, ctx)
 		return
 	}
 
+	if duplicate {
+		ctx.Server.Logger.Infof("(%s) Duplicate submission of score %d", user.Name, score.Id)
+		response := NewScoreSubmissionResponse(score, nil, user, ctx.Server)
+		json.NewEncoder(ctx.Response).Encode(response)
+		return
+	}
+
+	if err = UpdateUserRankings(user, ctx.Server); err != nil {
+		ctx.Server.Logger.Warningf("Error updating user rankings: %v", err)
+	}
+
 	if score.Passed {
-		err = UploadReplay(
-			score.Id,
-			request.ReplayFrames,
-			ctx.Server.State.Storage,
-		)
+		err = CheckReplayTheft(score, request.ReplayFrames, ctx.Server)
+		if err != nil {
+			ctx.Server.Logger.Warningf("Error checking replay similarity: %v", err)
+		}
+	}
+
+	if err = AnalyzeReplay(score, request.ReplayFrames, ctx.Server); err != nil {
+		ctx.Server.Logger.Warningf("Error analyzing replay: %v", err)
+	}
+
+	judgementFlag, err := RejudgeScore(beatmap, request, ctx.Server)
+	if err != nil {
+		ctx.Server.Logger.Warningf("Error judging replay: %v", err)
+	}
+
+	if judgementFlag != nil {
+		err = StoreScoreFlags(score, []*common.ScoreFlag{judgementFlag}, ctx.Server)
 
 		if err != nil {
-			ctx.Server.Logger.Warningf("Error uploading replay: %v", err)
+			ctx.Server.Logger.Warningf("Error storing judgement flag: %v", err)
 		}
 	}
 
-	if err = UpdateUserStatistics(request.ScoreData, user, ctx.Server); err != nil {
-		ctx.Server.Logger.Warningf("Error updating user statistics: %v", err)
-		WriteError(http.StatusInternalServerError, ServerError, ctx)
-		return
+	before := NewScoreSubmissionStats(&previousStats, previousRank)
+	response := NewScoreSubmissionResponse(score, before, user, ctx.Server)
+
+	achievements, err := UnlockAchievements(score, beatmap, user, ctx.Server)
+	if err != nil {
+		ctx.Server.Logger.Warningf("Error unlocking achievements: %v", err)
+	} else {
+		response.Achievements = achievements
 	}
 
-	response := ScoreSubmissionResponse{Success: true}
 	json.NewEncoder(ctx.Response).Encode(response)
 }
 
+// NewScoreSubmissionResponse describes the outcome of a submitted score,
+// comparing the user's current stats to the given stats before it.
+func NewScoreSubmissionResponse(score *common.Score, before *ScoreSubmissionStats, user *common.User, server *ScoreServer) *ScoreSubmissionResponse {
+	response := &ScoreSubmissionResponse{
+		Success:      true,
+		ScoreId:      score.Id,
+		PersonalBest: score.Status == common.ScoreStatusPB,
+		PP:           score.PP,
+		Achievements: []*AchievementResponse{},
+		Before:       before,
+		After:        NewScoreSubmissionStats(&user.Stats, user.Stats.Rank),
+	}
+
+	if response.PersonalBest {
+		position, err := common.FetchScorePosition(score, server.State)
+		if err != nil {
+			server.Logger.Warningf("Error fetching score position: %v", err)
+		}
+		response.Position = position
+	}
+
+	if before == nil || before.Rank == common.RankUnranked || user.Stats.Rank == common.RankUnranked {
+		return response
+	}
+
+	// Positive when the user climbed up the rankings
+	response.RankDelta = before.Rank - user.Stats.Rank
+	return response
+}
+
 func NewScoreSubmissionRequest(request *http.Request) (*ScoreSubmissionRequest, error) {
 	err := request.ParseMultipartForm(10 << 20) // ~10 MB
 	if err != nil {
@@ -0,0 +1,132 @@
+package hscore
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hexis-revival/hexagon/common"
+)
+
+func TestNewScoreSubmissionResponse(t *testing.T) {
+	server := newTestDatabaseServer(t)
+	creator := createTestUser(t, "creator", 0, server)
+	user := createTestUser(t, "player", 0, server)
+
+	tests := []struct {
+		Name       string
+		Ranked     bool
+		TotalScore int64
+		Status     common.ScoreStatus
+		Before     *ScoreSubmissionStats
+		After      common.Stats
+
+		PersonalBest bool
+		Position     int
+		RankDelta    int
+	}{
+		{
+			Name:       "first score",
+			Ranked:     true,
+			TotalScore: 1000,
+			Status:     common.ScoreStatusPB,
+			Before:     &ScoreSubmissionStats{Rank: common.RankUnranked},
+			After:      common.Stats{Rank: 2, RankedScore: 1000, TotalScore: 1000, Accuracy: 0.95, Playcount: 1},
+
+			PersonalBest: true,
+			Position:     2,
+		},
+		{
+			Name:       "new personal best",
+			Ranked:     true,
+			TotalScore: 3000,
+			Status:     common.ScoreStatusPB,
+			Before:     &ScoreSubmissionStats{Rank: 5, RankedScore: 1000, TotalScore: 1000, Accuracy: 0.9, Playcount: 1},
+			After:      common.Stats{Rank: 3, RankedScore: 3000, TotalScore: 4000, Accuracy: 0.95, Playcount: 2},
+
+			PersonalBest: true,
+			Position:     1,
+			RankDelta:    2,
+		},
+		{
+			// The older score keeps its position
+			Name:       "tied personal best",
+			Ranked:     true,
+			TotalScore: 2000,
+			Status:     common.ScoreStatusPB,
+			Before:     &ScoreSubmissionStats{Rank: 3, RankedScore: 1000, TotalScore: 1000, Accuracy: 0.95, Playcount: 1},
+			After:      common.Stats{Rank: 4, RankedScore: 2000, TotalScore: 3000, Accuracy: 0.95, Playcount: 2},
+
+			PersonalBest: true,
+			Position:     2,
+			RankDelta:    -1,
+		},
+		{
+			Name:       "no personal best",
+			Ranked:     true,
+			TotalScore: 500,
+			Status:     common.ScoreStatusSubmitted,
+			Before:     &ScoreSubmissionStats{Rank: 3, RankedScore: 3000, TotalScore: 4000, Accuracy: 0.95, Playcount: 2},
+			After:      common.Stats{Rank: 3, RankedScore: 3000, TotalScore: 4500, Accuracy: 0.95, Playcount: 3},
+		},
+		{
+			Name:       "unranked beatmap",
+			TotalScore: 700,
+			Status:     common.ScoreStatusUnranked,
+			Before:     &ScoreSubmissionStats{Rank: 3, RankedScore: 3000, TotalScore: 4500, Accuracy: 0.95, Playcount: 3},
+			After:      common.Stats{Rank: 3, RankedScore: 3000, TotalScore: 5200, Accuracy: 0.95, Playcount: 4},
+		},
+		{
+			// Stats before are unknown for duplicate submissions
+			Name:       "duplicate submission",
+			Ranked:     true,
+			TotalScore: 800,
+			Status:     common.ScoreStatusSubmitted,
+			After:      common.Stats{Rank: 3, RankedScore: 3000, TotalScore: 5200, Accuracy: 0.95, Playcount: 4},
+		},
+	}
+
+	for i, test := range tests {
+		beatmapset, beatmap := createTestBeatmapset(t, creator, server)
+
+		if test.Ranked {
+			beatmap.Status = common.BeatmapStatusRanked
+
+			err := common.UpdateBeatmapsStatusBySetId(beatmapset.Id, beatmap.Status, server.State)
+			if err != nil {
+				t.Fatalf("%s: failed to rank beatmap: %s", test.Name, err)
+			}
+
+			rival := createTestUser(t, fmt.Sprintf("rival%d", i), 0, server)
+			createTestScore(t, rival, beatmap, 2000, common.ScoreStatusPB, server)
+		}
+
+		score := createTestScore(t, user, beatmap, test.TotalScore, test.Status, server)
+		user.Stats = test.After
+
+		response := NewScoreSubmissionResponse(score, test.Before, user, server)
+
+		if !response.Success || response.ScoreId != score.Id || response.PP != score.PP {
+			t.Errorf("%s: expected successful response for score %d, got %v", test.Name, score.Id, response)
+		}
+
+		if response.PersonalBest != test.PersonalBest || response.Position != test.Position {
+			t.Errorf(
+				"%s: expected personal best %t at position %d, got %t at position %d",
+				test.Name, test.PersonalBest, test.Position, response.PersonalBest, response.Position,
+			)
+		}
+
+		if response.RankDelta != test.RankDelta {
+			t.Errorf("%s: expected rank delta %d, got %d", test.Name, test.RankDelta, response.RankDelta)
+		}
+
+		if response.Before != test.Before {
+			t.Errorf("%s: expected stats before %v, got %v", test.Name, test.Before, response.Before)
+		}
+
+		expectedAfter := NewScoreSubmissionStats(&test.After, test.After.Rank)
+		if *response.After != *expectedAfter {
+			t.Errorf("%s: expected stats after %v, got %v", test.Name, expectedAfter, response.After)
+		}
+	}
+}
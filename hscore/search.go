@@ -0,0 +1,133 @@
+package hscore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/hexis-revival/hexagon/common"
+)
+
+func BeatmapSearchHandler(ctx *Context) {
+	search, err := NewBeatmapSearch(ctx.Request.URL.Query())
+	if err != nil {
+		ctx.Server.Logger.Warningf("Failed to parse beatmap search: %s", err)
+		ctx.Response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	beatmapsets, cursor, err := common.SearchBeatmapsets(
+		search,
+		ctx.Server.State,
+		"Beatmaps", "Creator",
+	)
+
+	if errors.Is(err, common.ErrInvalidSearchSort) || errors.Is(err, common.ErrInvalidSearchCursor) {
+		ctx.Response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		ctx.Server.Logger.Errorf("Failed to search beatmaps: %s", err)
+		ctx.Response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response := &BeatmapSearchResponse{
+		Beatmapsets: make([]*BeatmapsetResponse, 0, len(beatmapsets)),
+		Cursor:      cursor,
+	}
+
+	for _, beatmapset := range beatmapsets {
+		response.Beatmapsets = append(response.Beatmapsets, NewBeatmapsetResponse(beatmapset))
+	}
+
+	ctx.Response.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(ctx.Response).Encode(response)
+}
+
+// NewBeatmapSearch parses the query parameters of a search request.
+// Ranges are given as "<name>_min" & "<name>_max", e.g. "sr_min=4.5".
+func NewBeatmapSearch(query url.Values) (*common.BeatmapSearch, error) {
+	search := &common.BeatmapSearch{
+		Query:   query.Get("q"),
+		Creator: query.Get("creator"),
+		Sort:    common.BeatmapSearchSort(query.Get("sort")),
+		Cursor:  query.Get("cursor"),
+	}
+
+	if status := query.Get("status"); status != "" {
+		value, err := strconv.Atoi(status)
+		if err != nil {
+			return nil, fmt.Errorf("invalid status: %s", status)
+		}
+
+		beatmapStatus := common.BeatmapStatus(value)
+		search.Status = &beatmapStatus
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid limit: %s", limit)
+		}
+
+		search.Limit = value
+	}
+
+	switch query.Get("order") {
+	case "asc":
+		search.Descending = false
+	case "desc":
+		search.Descending = true
+	case "":
+		// Alphabetical sorts are ascending, everything else descending
+		search.Descending = search.Sort != common.BeatmapSortTitle &&
+			search.Sort != common.BeatmapSortArtist
+	default:
+		return nil, fmt.Errorf("invalid order: %s", query.Get("order"))
+	}
+
+	ranges := map[string]*common.SearchRange{
+		"sr":     &search.SR,
+		"bpm":    &search.Bpm,
+		"length": &search.Length,
+		"cs":     &search.CS,
+		"ar":     &search.AR,
+		"od":     &search.OD,
+	}
+
+	for name, searchRange := range ranges {
+		min, err := parseSearchBound(query, name+"_min")
+		if err != nil {
+			return nil, err
+		}
+
+		max, err := parseSearchBound(query, name+"_max")
+		if err != nil {
+			return nil, err
+		}
+
+		searchRange.Min = min
+		searchRange.Max = max
+	}
+
+	return search, nil
+}
+
+func parseSearchBound(query url.Values, name string) (*float64, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	bound, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", name, value)
+	}
+
+	return &bound, nil
+}
@@ -33,6 +33,15 @@ func (server *ScoreServer) Serve() {
 	r.HandleFunc("/web/bss-post.php", server.contextMiddleware(BeatmapPostHandler)).Methods("POST")
 	r.HandleFunc("/web/hxs-bup.php", server.contextMiddleware(BeatmapUpdateHandler)).Methods("GET")
 	r.HandleFunc("/score/submit", server.contextMiddleware(ScoreSubmissionHandler)).Methods("POST")
+	r.HandleFunc("/score/replay", server.contextMiddleware(ReplayDownloadHandler)).Methods("GET")
+	r.HandleFunc("/score/replay/export", server.contextMiddleware(ReplayExportHandler)).Methods("GET")
+	r.HandleFunc("/beatmaps/search", server.contextMiddleware(BeatmapSearchHandler)).Methods("GET")
+	r.HandleFunc("/beatmaps/{id}/status", server.contextMiddleware(BeatmapStatusHandler)).Methods("POST")
+	r.HandleFunc("/beatmaps/{id}/history", server.contextMiddleware(BeatmapStatusHistoryHandler)).Methods("GET")
+	r.HandleFunc("/users/{id}/achievements", server.contextMiddleware(UserAchievementsHandler)).Methods("GET")
+	r.HandleFunc("/d/{id}", server.contextMiddleware(BeatmapDownloadHandler)).Methods("GET", "HEAD")
+	r.HandleFunc("/thumb/{id:[0-9]+}/{size:large|small}", server.contextMiddleware(BeatmapThumbnailHandler)).Methods("GET", "HEAD")
+	r.HandleFunc("/preview/{id:[0-9]+}.mp3", server.contextMiddleware(BeatmapPreviewHandler)).Methods("GET", "HEAD")
 	r.HandleFunc("/a/{id}", server.contextMiddleware(AvatarHandler)).Methods("GET")
 
 	loggedMux := server.loggingMiddleware(r)
@@ -48,6 +57,16 @@ func NewServer(host string, port int, logger *common.Logger, state *common.State
 	}
 }
 
+// Transaction runs the callback with a copy of the server,
+// whose state queries go through a database transaction.
+func (server *ScoreServer) Transaction(callback func(tx *ScoreServer) error) error {
+	return server.State.Transaction(func(state *common.State) error {
+		tx := *server
+		tx.State = state
+		return callback(&tx)
+	})
+}
+
 func (server *ScoreServer) contextMiddleware(handler func(*Context)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		context := &Context{
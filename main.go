@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"os"
 	"sync"
 
 	"github.com/hexis-revival/hexagon/common"
@@ -15,10 +16,12 @@ type Config struct {
 		Port int
 	}
 	HScore struct {
-		Host string
-		Port int
+		Host    string
+		Port    int
+		Workers int
 	}
-	State *common.StateConfiguration
+	State   *common.StateConfiguration
+	Migrate bool
 }
 
 func loadConfig() Config {
@@ -30,6 +33,7 @@ func loadConfig() Config {
 
 	flag.StringVar(&config.HScore.Host, "hscore-host", "0.0.0.0", "Host for the hscore server")
 	flag.IntVar(&config.HScore.Port, "hscore-port", 80, "Port for the hscore server")
+	flag.IntVar(&config.HScore.Workers, "hscore-workers", 2, "Amount of background job workers")
 
 	flag.StringVar(&config.State.Database.Host, "db-host", "localhost", "Database host")
 	flag.IntVar(&config.State.Database.Port, "db-port", 5432, "Database port")
@@ -40,6 +44,7 @@ func loadConfig() Config {
 	flag.IntVar(&config.State.Database.MaxIdle, "db-max-idle", 10, "Database max idle connections")
 	flag.IntVar(&config.State.Database.MaxOpen, "db-max-open", 100, "Database max open connections")
 	flag.DurationVar(&config.State.Database.MaxLifetime, "db-max-lifetime", 0, "Database max connection lifetime")
+	flag.BoolVar(&config.Migrate, "db-migrate", true, "Apply pending database migrations on startup")
 
 	flag.StringVar(&config.State.Redis.Host, "redis-host", "localhost", "Redis host")
 	flag.IntVar(&config.State.Redis.Port, "redis-port", 6379, "Redis port")
@@ -47,6 +52,14 @@ func loadConfig() Config {
 	flag.IntVar(&config.State.Redis.Database, "redis-database", 0, "Redis database")
 
 	flag.StringVar(&config.State.DataPath, "data-path", ".data", "Path to store data")
+	flag.StringVar(&config.State.StorageBackend, "storage", common.StorageBackendFile, "Storage backend (file or s3)")
+	flag.StringVar(&config.State.AudioProcessor, "audio", common.AudioProcessorFFmpeg, "Audio processor for previews (ffmpeg or none)")
+
+	flag.StringVar(&config.State.S3.Endpoint, "s3-endpoint", "", "Endpoint of an s3-compatible service, empty for aws")
+	flag.StringVar(&config.State.S3.Region, "s3-region", "us-east-1", "S3 region")
+	flag.StringVar(&config.State.S3.Bucket, "s3-bucket", "hexagon", "S3 bucket")
+	flag.StringVar(&config.State.S3.AccessKey, "s3-access-key", "", "S3 access key")
+	flag.StringVar(&config.State.S3.SecretKey, "s3-secret-key", "", "S3 secret key")
 	flag.Parse()
 
 	return config
@@ -71,6 +84,31 @@ func main() {
 		return
 	}
 
+	if config.Migrate {
+		applied, err := common.RunMigrations(state)
+		if err != nil {
+			logger.Errorf("Failed to apply database migrations: %v", err)
+			os.Exit(1)
+		}
+
+		for _, name := range applied {
+			logger.Infof("Applied database migration %s", name)
+		}
+	}
+
+	if flag.NArg() > 0 {
+		err = runCommand(flag.Args(), state, logger)
+		if err != nil {
+			logger.Error(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err = common.EnsureRankings(state, logger); err != nil {
+		logger.Errorf("Failed to rebuild rankings: %v", err)
+	}
+
 	hnetServer := hnet.NewServer(
 		config.HNet.Host,
 		config.HNet.Port,
@@ -89,6 +127,7 @@ func main() {
 
 	runService(&wg, hnetServer.Serve)
 	runService(&wg, hscoreServer.Serve)
+	runService(&wg, func() { hscoreServer.RunJobWorkers(config.HScore.Workers) })
 
 	wg.Wait()
 }